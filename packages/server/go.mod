@@ -8,5 +8,6 @@ require (
 	github.com/gorilla/websocket v1.4.1
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/tus/tusd v1.0.2
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 	google.golang.org/appengine v1.5.0
 )
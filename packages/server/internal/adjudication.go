@@ -0,0 +1,349 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Classes of the groups of aligned annotations
+const (
+	GroupAgreed      = "agreed"      // every annotator made the same annotation
+	GroupConflicting = "conflicting" // annotators disagree on the boundaries or the topic, or some missed it
+	GroupSingle      = "single"      // a single annotator annotated this part of the document
+)
+
+// AdjudicationGroup is a set of overlapping annotations from the layers of several annotators
+type AdjudicationGroup struct {
+	Class          string       `json:"class"`
+	CharacterStart uint         `json:"characterStart"`
+	CharacterEnd   uint         `json:"characterEnd"`
+	Annotations    []Annotation `json:"annotations"`
+	Gold           []Annotation `json:"gold"` // the gold annotations already covering the group
+}
+
+type Adjudication struct {
+	DocumentID uint                `json:"documentId"`
+	UserIDs    []uint              `json:"userIds"`
+	Counts     map[string]int      `json:"counts"`
+	Groups     []AdjudicationGroup `json:"groups"`
+}
+
+// GoldRequest adds annotations to the gold layer of a document
+type GoldRequest struct {
+	AnnotationIDs []uint       `json:"annotationIds"` // annotations of the annotators copied as they are
+	Annotations   []Annotation `json:"annotations"`   // new or edited spans
+	Agreed        bool         `json:"agreed"`        // also copy the annotation of every agreed group
+}
+
+// classifyGroup tells if the annotators of the document agree on a group of annotations
+func classifyGroup(annotations []Annotation, annotators int) string {
+	users := map[uint]bool{}
+	for _, annotation := range annotations {
+		users[*annotation.UserID] = true
+	}
+
+	if len(users) == 1 {
+		return GroupSingle
+	}
+
+	if len(users) < annotators || len(annotations) != len(users) {
+		return GroupConflicting
+	}
+
+	first := annotations[0]
+	for _, annotation := range annotations[1:] {
+		if annotation.CharacterStart != first.CharacterStart || annotation.CharacterEnd != first.CharacterEnd || annotation.TopicID != first.TopicID {
+			return GroupConflicting
+		}
+	}
+
+	return GroupAgreed
+}
+
+// adjudicate aligns the layers of the annotators of a document, all of them when userIDs is empty,
+// grouping the annotations which overlap each other whatever their topic
+func adjudicate(q querier, documentID uint, userIDs []uint) (Adjudication, error) {
+	adjudication := Adjudication{
+		DocumentID: documentID,
+		UserIDs:    []uint{},
+		Counts:     map[string]int{GroupAgreed: 0, GroupConflicting: 0, GroupSingle: 0},
+		Groups:     []AdjudicationGroup{},
+	}
+
+	gold := false
+	annotations, err := queryAnnotations(q, AnnotationFilter{
+		DocumentID: documentID,
+		Statuses:   []string{StatusSuggested, StatusAccepted},
+		UserIDs:    userIDs,
+		Gold:       &gold,
+	})
+	if err != nil {
+		return adjudication, err
+	}
+
+	gold = true
+	goldAnnotations, err := queryAnnotations(q, AnnotationFilter{DocumentID: documentID, Gold: &gold})
+	if err != nil {
+		return adjudication, err
+	}
+
+	users := map[uint]bool{}
+	layered := []Annotation{}
+	for _, annotation := range annotations {
+		if annotation.UserID == nil {
+			continue
+		}
+		if !users[*annotation.UserID] {
+			users[*annotation.UserID] = true
+			adjudication.UserIDs = append(adjudication.UserIDs, *annotation.UserID)
+		}
+		layered = append(layered, annotation)
+	}
+	sort.Slice(adjudication.UserIDs, func(i, j int) bool { return adjudication.UserIDs[i] < adjudication.UserIDs[j] })
+
+	// Annotations are sorted by start, a group ends before the first annotation starting after all of its annotations ended
+	var group *AdjudicationGroup
+	for _, annotation := range layered {
+		if group != nil && annotation.CharacterStart < group.CharacterEnd {
+			group.Annotations = append(group.Annotations, annotation)
+			if annotation.CharacterEnd > group.CharacterEnd {
+				group.CharacterEnd = annotation.CharacterEnd
+			}
+			continue
+		}
+
+		adjudication.Groups = append(adjudication.Groups, AdjudicationGroup{
+			CharacterStart: annotation.CharacterStart,
+			CharacterEnd:   annotation.CharacterEnd,
+			Annotations:    []Annotation{annotation},
+		})
+		group = &adjudication.Groups[len(adjudication.Groups)-1]
+	}
+
+	for i := range adjudication.Groups {
+		group := &adjudication.Groups[i]
+
+		group.Class = classifyGroup(group.Annotations, len(adjudication.UserIDs))
+		adjudication.Counts[group.Class]++
+
+		group.Gold = []Annotation{}
+		for _, annotation := range goldAnnotations {
+			if annotation.CharacterStart < group.CharacterEnd && group.CharacterStart < annotation.CharacterEnd {
+				group.Gold = append(group.Gold, annotation)
+			}
+		}
+	}
+
+	return adjudication, nil
+}
+
+// hasGoldAnnotation tells if the gold layer already has an annotation with these boundaries and topic
+func hasGoldAnnotation(q querier, documentID uint, annotation Annotation) (bool, error) {
+	var count int
+
+	err := q.QueryRow(`SELECT COUNT(*) FROM annotations
+								WHERE document_id = ? AND gold AND character_start = ? AND character_end = ? AND topic_id = ?`,
+		documentID, annotation.CharacterStart, annotation.CharacterEnd, annotation.TopicID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("Unable to check gold annotations: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// exportAnnotations returns the annotations exported for a document: its gold layer when it has one,
+// its accepted annotations otherwise. With layer "accepted", the gold layer is ignored.
+func exportAnnotations(q querier, documentID uint, layer string) ([]Annotation, error) {
+	if layer != "accepted" {
+		gold := true
+		annotations, err := queryAnnotations(q, AnnotationFilter{DocumentID: documentID, Gold: &gold})
+		if err != nil || len(annotations) > 0 {
+			return annotations, err
+		}
+	}
+
+	gold := false
+	return queryAnnotations(q, AnnotationFilter{DocumentID: documentID, Statuses: []string{StatusAccepted}, Gold: &gold})
+}
+
+// GetAdjudicationHandler aligns the layers of the annotators of a document, ?userId= restricts the annotators
+// and ?class= the groups returned
+func GetAdjudicationHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	userIDs, err := parseIDs(r.URL.Query()["userId"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid userId: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	adjudication, err := adjudicate(db, uint(documentID), userIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if class := r.URL.Query().Get("class"); class != "" {
+		groups := []AdjudicationGroup{}
+		for _, group := range adjudication.Groups {
+			if group.Class == class {
+				groups = append(groups, group)
+			}
+		}
+		adjudication.Groups = groups
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(adjudication)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// PostGoldHandler adds picked or edited annotations to the gold layer of a document, along with the relations
+// between the picked annotations. Annotations already in the gold layer are not added twice.
+func PostGoldHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	var request GoldRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	picked := request.AnnotationIDs
+	if request.Agreed {
+		adjudication, err := adjudicate(tx, uint(documentID), nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, group := range adjudication.Groups {
+			if group.Class == GroupAgreed {
+				picked = append(picked, group.Annotations[0].AnnotationID)
+			}
+		}
+	}
+
+	// The gold annotation made from each picked annotation
+	golds := map[uint]uint{}
+	isPicked := map[uint]bool{}
+	for _, annotationID := range picked {
+		annotation, err := getAnnotation(tx, uint(documentID), annotationID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Annotation %d does not belong to document %d", annotationID, documentID), http.StatusBadRequest)
+			return
+		}
+
+		if annotation.Gold {
+			http.Error(w, fmt.Sprintf("Annotation %d is already a gold annotation", annotationID), http.StatusBadRequest)
+			return
+		}
+
+		request.Annotations = append(request.Annotations, annotation)
+		isPicked[annotationID] = true
+	}
+
+	for _, annotation := range request.Annotations {
+		exists, err := hasGoldAnnotation(tx, uint(documentID), annotation)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if exists {
+			continue
+		}
+
+		// The copy belongs to the reviewer, it remembers which annotation it was made from
+		pickedID := annotation.AnnotationID
+		annotation.SourceAnnotationID = nil
+		if isPicked[pickedID] {
+			annotation.SourceAnnotationID = &pickedID
+		}
+		annotation.Gold = true
+		annotation.Status = StatusAccepted
+		if annotation.Source == "" {
+			annotation.Source = SourceHuman
+		}
+		if !validSource(annotation.Source) {
+			http.Error(w, "Invalid annotation source", http.StatusBadRequest)
+			return
+		}
+
+		goldID, err := insertAnnotation(tx, uint(documentID), annotation, currentUser(r).UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if isPicked[pickedID] {
+			golds[pickedID] = goldID
+		}
+	}
+
+	relations, err := queryRelations(tx, uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, relation := range relations {
+		from, fromPicked := golds[relation.FromAnnotationID]
+		to, toPicked := golds[relation.ToAnnotationID]
+		if !fromPicked || !toPicked {
+			continue
+		}
+
+		relation.FromAnnotationID, relation.ToAnnotationID = from, to
+		relation.UserID = nil
+		_, err = insertRelation(tx, uint(documentID), relation, currentUser(r).UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	gold := true
+	annotations, err := queryAnnotations(tx, AnnotationFilter{DocumentID: uint(documentID), Gold: &gold})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(annotations)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	BroadcastDocument(uint(documentID), fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d}`, documentID))
+	if len(golds) > 0 {
+		BroadcastDocument(uint(documentID), fmt.Sprintf(`{"type":"relationsChanged", "documentId":%d}`, documentID))
+	}
+}
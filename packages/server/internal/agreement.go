@@ -0,0 +1,592 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// AgreementScores measures how much annotators agree, a score is null when it is undefined,
+// e.g. a kappa when every token got the same label
+type AgreementScores struct {
+	ExactF1     *float64 `json:"exactF1"`     // spans with the same boundaries and topic
+	OverlapF1   *float64 `json:"overlapF1"`   // spans of the same topic sharing at least one character
+	CohensKappa *float64 `json:"cohensKappa"` // over tokens, averaged over the pairs of annotators
+	FleissKappa *float64 `json:"fleissKappa"` // over tokens, all the annotators of a document at once
+}
+
+type TopicAgreement struct {
+	TopicID uint   `json:"topicId"`
+	Topic   string `json:"topic"`
+	AgreementScores
+}
+
+// PairAgreement is the agreement of two annotators on a document, over all topics
+type PairAgreement struct {
+	DocumentID uint `json:"documentId"`
+	UserA      uint `json:"userA"`
+	UserB      uint `json:"userB"`
+	AgreementScores
+}
+
+type AgreementReport struct {
+	DocumentIDs []uint           `json:"documentIds"`
+	UserIDs     []uint           `json:"userIds"` // the annotators who could be compared on at least one document
+	Overall     AgreementScores  `json:"overall"`
+	Topics      []TopicAgreement `json:"topics"`
+	Pairs       []PairAgreement  `json:"pairs"`
+}
+
+// Layer is the set of annotations made by one annotator on a document
+type Layer struct {
+	UserID      uint   `json:"userId"`
+	Username    string `json:"username"`
+	Annotations int    `json:"annotations"`
+}
+
+type userPair struct {
+	a, b uint
+}
+
+// spanCounts accumulates the span matches of a pair of annotators
+type spanCounts struct {
+	exact    int // spans of A with an identical span in B
+	overlapA int // spans of A overlapping a span of B
+	overlapB int // spans of B overlapping a span of A
+	totalA   int
+	totalB   int
+}
+
+func (c *spanCounts) add(other spanCounts) {
+	c.exact += other.exact
+	c.overlapA += other.overlapA
+	c.overlapB += other.overlapB
+	c.totalA += other.totalA
+	c.totalB += other.totalB
+}
+
+func (c spanCounts) exactF1() *float64 {
+	if c.totalA+c.totalB == 0 {
+		return nil
+	}
+
+	f1 := 2 * float64(c.exact) / float64(c.totalA+c.totalB)
+	return &f1
+}
+
+func (c spanCounts) overlapF1() *float64 {
+	if c.totalA+c.totalB == 0 {
+		return nil
+	}
+
+	f1 := 0.0
+	if c.totalA > 0 && c.totalB > 0 {
+		precision := float64(c.overlapB) / float64(c.totalB)
+		recall := float64(c.overlapA) / float64(c.totalA)
+		if precision+recall > 0 {
+			f1 = 2 * precision * recall / (precision + recall)
+		}
+	}
+
+	return &f1
+}
+
+// confusion counts the pairs of token labels given by two annotators
+type confusion map[[2]string]int
+
+func (c confusion) add(labelsA, labelsB []string) {
+	for i := range labelsA {
+		c[[2]string{labelsA[i], labelsB[i]}]++
+	}
+}
+
+func (c confusion) kappa() *float64 {
+	total, agreed := 0, 0
+	marginalA := map[string]int{}
+	marginalB := map[string]int{}
+
+	for labels, count := range c {
+		total += count
+		if labels[0] == labels[1] {
+			agreed += count
+		}
+		marginalA[labels[0]] += count
+		marginalB[labels[1]] += count
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	expected := 0.0
+	for label, count := range marginalA {
+		expected += float64(count) * float64(marginalB[label]) / float64(total*total)
+	}
+
+	if expected == 1 {
+		return nil
+	}
+
+	kappa := (float64(agreed)/float64(total) - expected) / (1 - expected)
+	return &kappa
+}
+
+// fleiss accumulates the token labels of any number of annotators,
+// using the generalization of Fleiss' kappa to items rated by a varying number of annotators
+type fleiss struct {
+	items      int
+	agreement  float64
+	categories map[string]int
+	ratings    int
+}
+
+func newFleiss() *fleiss {
+	return &fleiss{categories: map[string]int{}}
+}
+
+// add records the labels given to one token
+func (f *fleiss) add(labels []string) {
+	n := len(labels)
+	if n < 2 {
+		return
+	}
+
+	counts := map[string]int{}
+	for _, label := range labels {
+		counts[label]++
+	}
+
+	sum := 0
+	for label, count := range counts {
+		sum += count * count
+		f.categories[label] += count
+	}
+
+	f.agreement += float64(sum-n) / float64(n*(n-1))
+	f.items++
+	f.ratings += n
+}
+
+func (f *fleiss) kappa() *float64 {
+	if f.items == 0 {
+		return nil
+	}
+
+	expected := 0.0
+	for _, count := range f.categories {
+		p := float64(count) / float64(f.ratings)
+		expected += p * p
+	}
+
+	if expected == 1 {
+		return nil
+	}
+
+	kappa := (f.agreement/float64(f.items) - expected) / (1 - expected)
+	return &kappa
+}
+
+// meanKappa averages the kappas of the pairs of annotators
+func meanKappa(confusions map[userPair]confusion) *float64 {
+	sum, count := 0.0, 0
+
+	for _, c := range confusions {
+		if kappa := c.kappa(); kappa != nil {
+			sum += *kappa
+			count++
+		}
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	mean := sum / float64(count)
+	return &mean
+}
+
+// compareSpans counts the matches between the spans of two annotators, for a single topic when topicID is not 0
+func compareSpans(spansA, spansB []Annotation, topicID uint) spanCounts {
+	var counts spanCounts
+
+	filter := func(spans []Annotation) []Annotation {
+		kept := []Annotation{}
+		for _, span := range spans {
+			if topicID == 0 || span.TopicID == topicID {
+				kept = append(kept, span)
+			}
+		}
+		return kept
+	}
+	spansA, spansB = filter(spansA), filter(spansB)
+
+	counts.totalA, counts.totalB = len(spansA), len(spansB)
+
+	overlaps := func(a, b Annotation) bool {
+		return a.TopicID == b.TopicID && a.CharacterStart < b.CharacterEnd && b.CharacterStart < a.CharacterEnd
+	}
+
+	for _, a := range spansA {
+		exact, overlap := false, false
+		for _, b := range spansB {
+			if a.TopicID == b.TopicID && a.CharacterStart == b.CharacterStart && a.CharacterEnd == b.CharacterEnd {
+				exact = true
+			}
+			if overlaps(a, b) {
+				overlap = true
+			}
+		}
+		if exact {
+			counts.exact++
+		}
+		if overlap {
+			counts.overlapA++
+		}
+	}
+
+	for _, b := range spansB {
+		for _, a := range spansA {
+			if overlaps(a, b) {
+				counts.overlapB++
+				break
+			}
+		}
+	}
+
+	return counts
+}
+
+// tokenLabels labels each token with the topic of the first span covering it, or with a single topic
+// when topicID is not 0, "" meaning no annotation
+func tokenLabels(tokens Tokens, spans []Annotation, topicID uint) []string {
+	labels := make([]string, len(tokens))
+
+	for _, span := range spans {
+		if topicID != 0 && span.TopicID != topicID {
+			continue
+		}
+
+		for i, token := range tokens {
+			if labels[i] == "" && token.CharacterStart < span.CharacterEnd && span.CharacterStart < token.CharacterEnd {
+				labels[i] = strconv.Itoa(int(span.TopicID))
+			}
+		}
+	}
+
+	return labels
+}
+
+// taskAnnotators returns the users with a task on a document, only those of userIDs when it is not empty,
+// and whether the document has any task at all
+func taskAnnotators(q querier, documentID uint, userIDs []uint) ([]uint, bool, error) {
+	rows, err := q.Query("SELECT user_id FROM tasks WHERE document_id = ? ORDER BY user_id", documentID)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to query tasks: %w", err)
+	}
+	defer rows.Close()
+
+	wanted := map[uint]bool{}
+	for _, userID := range userIDs {
+		wanted[userID] = true
+	}
+
+	annotators := []uint{}
+	assigned := false
+	for rows.Next() {
+		var userID uint
+
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, false, fmt.Errorf("Unable to read task: %w", err)
+		}
+
+		assigned = true
+		if len(userIDs) == 0 || wanted[userID] {
+			annotators = append(annotators, userID)
+		}
+	}
+
+	return annotators, assigned, rows.Err()
+}
+
+// agreementReport compares the layers of the annotators on the documents.
+// The annotators of a document are the users it is assigned to, an empty layer counting as annotating nothing.
+// Documents without tasks fall back to the users with at least one annotation in it which is not rejected nor gold.
+func agreementReport(q querier, projectID uint, documentIDs, userIDs []uint) (AgreementReport, error) {
+	report := AgreementReport{DocumentIDs: documentIDs, UserIDs: []uint{}, Topics: []TopicAgreement{}, Pairs: []PairAgreement{}}
+	compared := map[uint]bool{}
+
+	topics, err := queryTopics(q, projectID)
+	if err != nil {
+		return report, err
+	}
+
+	overallSpans := spanCounts{}
+	overallConfusions := map[userPair]confusion{}
+	overallFleiss := newFleiss()
+
+	topicSpans := map[uint]*spanCounts{}
+	topicConfusions := map[uint]map[userPair]confusion{}
+	topicFleiss := map[uint]*fleiss{}
+	for _, topic := range topics {
+		topicSpans[topic.TopicID] = &spanCounts{}
+		topicConfusions[topic.TopicID] = map[userPair]confusion{}
+		topicFleiss[topic.TopicID] = newFleiss()
+	}
+
+	for _, documentID := range documentIDs {
+		gold := false
+		annotations, err := queryAnnotations(q, AnnotationFilter{
+			DocumentID: documentID,
+			Statuses:   []string{StatusSuggested, StatusAccepted},
+			UserIDs:    userIDs,
+			Gold:       &gold,
+		})
+		if err != nil {
+			return report, err
+		}
+
+		layers := map[uint][]Annotation{}
+		for _, annotation := range annotations {
+			if annotation.UserID != nil {
+				layers[*annotation.UserID] = append(layers[*annotation.UserID], annotation)
+			}
+		}
+
+		annotators, assigned, err := taskAnnotators(q, documentID, userIDs)
+		if err != nil {
+			return report, err
+		}
+
+		if !assigned {
+			for userID := range layers {
+				annotators = append(annotators, userID)
+			}
+			sort.Slice(annotators, func(i, j int) bool { return annotators[i] < annotators[j] })
+		}
+
+		if len(annotators) < 2 {
+			continue
+		}
+
+		for _, userID := range annotators {
+			if !compared[userID] {
+				compared[userID] = true
+				report.UserIDs = append(report.UserIDs, userID)
+			}
+		}
+
+		pages, err := loadDocumentTokens(q, documentID)
+		if err != nil {
+			return report, err
+		}
+
+		tokens := Tokens{}
+		for _, page := range pages {
+			tokens = append(tokens, page.Tokens...)
+		}
+
+		labels := map[uint][]string{}
+		for _, userID := range annotators {
+			labels[userID] = tokenLabels(tokens, layers[userID], 0)
+		}
+
+		for i, userA := range annotators {
+			for _, userB := range annotators[i+1:] {
+				pair := userPair{userA, userB}
+
+				counts := compareSpans(layers[userA], layers[userB], 0)
+				overallSpans.add(counts)
+
+				documentConfusion := confusion{}
+				documentConfusion.add(labels[userA], labels[userB])
+
+				documentFleiss := newFleiss()
+				for t := range tokens {
+					documentFleiss.add([]string{labels[userA][t], labels[userB][t]})
+				}
+
+				if overallConfusions[pair] == nil {
+					overallConfusions[pair] = confusion{}
+				}
+				overallConfusions[pair].add(labels[userA], labels[userB])
+
+				report.Pairs = append(report.Pairs, PairAgreement{
+					DocumentID: documentID,
+					UserA:      userA,
+					UserB:      userB,
+					AgreementScores: AgreementScores{
+						ExactF1:     counts.exactF1(),
+						OverlapF1:   counts.overlapF1(),
+						CohensKappa: documentConfusion.kappa(),
+						FleissKappa: documentFleiss.kappa(),
+					},
+				})
+			}
+		}
+
+		for t := range tokens {
+			ratings := make([]string, len(annotators))
+			for i, userID := range annotators {
+				ratings[i] = labels[userID][t]
+			}
+			overallFleiss.add(ratings)
+		}
+
+		for _, topic := range topics {
+			topicLabels := map[uint][]string{}
+			for _, userID := range annotators {
+				topicLabels[userID] = tokenLabels(tokens, layers[userID], topic.TopicID)
+			}
+
+			for i, userA := range annotators {
+				for _, userB := range annotators[i+1:] {
+					pair := userPair{userA, userB}
+
+					topicSpans[topic.TopicID].add(compareSpans(layers[userA], layers[userB], topic.TopicID))
+
+					if topicConfusions[topic.TopicID][pair] == nil {
+						topicConfusions[topic.TopicID][pair] = confusion{}
+					}
+					topicConfusions[topic.TopicID][pair].add(topicLabels[userA], topicLabels[userB])
+				}
+			}
+
+			for t := range tokens {
+				ratings := make([]string, len(annotators))
+				for i, userID := range annotators {
+					ratings[i] = topicLabels[userID][t]
+				}
+				topicFleiss[topic.TopicID].add(ratings)
+			}
+		}
+	}
+
+	sort.Slice(report.UserIDs, func(i, j int) bool { return report.UserIDs[i] < report.UserIDs[j] })
+
+	report.Overall = AgreementScores{
+		ExactF1:     overallSpans.exactF1(),
+		OverlapF1:   overallSpans.overlapF1(),
+		CohensKappa: meanKappa(overallConfusions),
+		FleissKappa: overallFleiss.kappa(),
+	}
+
+	for _, topic := range topics {
+		report.Topics = append(report.Topics, TopicAgreement{
+			TopicID: topic.TopicID,
+			Topic:   topic.Topic,
+			AgreementScores: AgreementScores{
+				ExactF1:     topicSpans[topic.TopicID].exactF1(),
+				OverlapF1:   topicSpans[topic.TopicID].overlapF1(),
+				CohensKappa: meanKappa(topicConfusions[topic.TopicID]),
+				FleissKappa: topicFleiss[topic.TopicID].kappa(),
+			},
+		})
+	}
+
+	return report, nil
+}
+
+// GetAgreementHandler reports the inter-annotator agreement on the documents of a project,
+// optionally restricted to some documents and annotators with ?documentId= and ?userId=
+func GetAgreementHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	documentIDs, err := parseIDs(query["documentId"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid documentId: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	userIDs, err := parseIDs(query["userId"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid userId: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	documentQuery := "SELECT document_id FROM documents WHERE project_id = ?"
+	args := []interface{}{projectID(r)}
+	if len(documentIDs) > 0 {
+		var clause string
+		clause, args = idInClause("document_id", documentIDs, args)
+		documentQuery += clause
+	}
+
+	rows, err := db.Query(documentQuery+" ORDER BY document_id", args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	documentIDs = []uint{}
+	for rows.Next() {
+		var documentID uint
+
+		err = rows.Scan(&documentID)
+		if err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		documentIDs = append(documentIDs, documentID)
+	}
+	rows.Close()
+
+	report, err := agreementReport(db, projectID(r), documentIDs, userIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GetLayersHandler lists the annotators of a document with how many annotations each one made, the gold layer aside
+func GetLayersHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	rows, err := db.Query(`SELECT u.user_id, u.username, COUNT(*) FROM annotations a
+								INNER JOIN users u ON u.user_id = a.user_id
+								WHERE a.document_id = ? AND NOT a.gold
+								GROUP BY u.user_id, u.username
+								ORDER BY u.user_id`, documentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	layers := []Layer{}
+
+	for rows.Next() {
+		var layer Layer
+
+		err = rows.Scan(&layer.UserID, &layer.Username, &layer.Annotations)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		layers = append(layers, layer)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(layers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
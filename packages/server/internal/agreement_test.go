@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// sameFloat compares an optional agreement measure, nil meaning undefined
+func sameFloat(got *float64, want *float64) bool {
+	if got == nil || want == nil {
+		return got == nil && want == nil
+	}
+	return math.Abs(*got-*want) < 1e-9
+}
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestConfusionKappa(t *testing.T) {
+	tests := []struct {
+		name      string
+		confusion confusion
+		want      *float64
+	}{
+		{"empty", confusion{}, nil},
+		{"single label", confusion{{"1", "1"}: 4}, nil},
+		{"perfect", confusion{{"1", "1"}: 2, {"", ""}: 2}, float(1)},
+		// Cohen's example: 20 yes/yes, 5 yes/no, 10 no/yes, 15 no/no
+		{"cohen", confusion{{"1", "1"}: 20, {"1", ""}: 5, {"", "1"}: 10, {"", ""}: 15}, float(0.4)},
+		{"chance", confusion{{"1", "1"}: 1, {"1", ""}: 1, {"", "1"}: 1, {"", ""}: 1}, float(0)},
+		{"opposite", confusion{{"1", ""}: 2, {"", "1"}: 2}, float(-1)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.confusion.kappa(); !sameFloat(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFleissKappa(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens [][]string
+		want   *float64
+	}{
+		{"empty", nil, nil},
+		{"single annotator", [][]string{{"1"}, {""}}, nil},
+		{"single label", [][]string{{"1", "1"}, {"1", "1"}}, nil},
+		// P = 3/4, Pe = (3/8)^2 + (5/8)^2 = 17/32
+		{"two annotators", [][]string{{"1", "1"}, {"1", ""}, {"", ""}, {"", ""}}, float(7.0 / 15)},
+		// P = (1 + 1/3) / 2 = 2/3, Pe = (4/6)^2 + (2/6)^2 = 5/9
+		{"three annotators", [][]string{{"1", "1", "1"}, {"1", "2", "2"}}, float(0.25)},
+		// P = (1 + 0) / 2, Pe = (4/5)^2 + (1/5)^2, the token of a single annotator is left out
+		{"varying annotators", [][]string{{"1", "1", "1"}, {"1", ""}, {""}}, float((0.5 - 0.68) / 0.32)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFleiss()
+			for _, labels := range test.tokens {
+				f.add(labels)
+			}
+			if got := f.kappa(); !sameFloat(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompareSpans(t *testing.T) {
+	span := func(topicID, start, end uint) Annotation {
+		return Annotation{TopicID: topicID, CharacterStart: start, CharacterEnd: end}
+	}
+	spansA := []Annotation{span(1, 0, 4), span(1, 10, 20)}
+	spansB := []Annotation{span(1, 0, 4), span(1, 15, 25), span(2, 30, 35)}
+
+	tests := []struct {
+		name      string
+		spansA    []Annotation
+		spansB    []Annotation
+		topicID   uint
+		counts    spanCounts
+		exactF1   *float64
+		overlapF1 *float64
+	}{
+		{"no spans", nil, nil, 0, spanCounts{}, nil, nil},
+		// precision 2/3, recall 2/2
+		{"all topics", spansA, spansB, 0, spanCounts{exact: 1, overlapA: 2, overlapB: 2, totalA: 2, totalB: 3}, float(0.4), float(0.8)},
+		{"one topic", spansA, spansB, 1, spanCounts{exact: 1, overlapA: 2, overlapB: 2, totalA: 2, totalB: 2}, float(0.5), float(1)},
+		{"one annotator", spansA, spansB, 2, spanCounts{totalB: 1}, float(0), float(0)},
+		{"other topic", []Annotation{span(1, 0, 4)}, []Annotation{span(2, 0, 4)}, 0, spanCounts{totalA: 1, totalB: 1}, float(0), float(0)},
+		{"adjacent", []Annotation{span(1, 0, 4)}, []Annotation{span(1, 4, 8)}, 0, spanCounts{totalA: 1, totalB: 1}, float(0), float(0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts := compareSpans(test.spansA, test.spansB, test.topicID)
+			if counts != test.counts {
+				t.Errorf("counted %+v, want %+v", counts, test.counts)
+			}
+			if got := counts.exactF1(); !sameFloat(got, test.exactF1) {
+				t.Errorf("exact F1 %v, want %v", got, test.exactF1)
+			}
+			if got := counts.overlapF1(); !sameFloat(got, test.overlapF1) {
+				t.Errorf("overlap F1 %v, want %v", got, test.overlapF1)
+			}
+		})
+	}
+}
+
+func TestAgreementReportEmptyLayer(t *testing.T) {
+	defer openTestDatabase(t)()
+
+	ann := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('ann', 'hash', '2020-01-01')")
+	bob := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('bob', 'hash', '2020-01-01')")
+	partyID := mustExec(t, "INSERT INTO topics (topic) VALUES ('Party')")
+	documentID := insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "and", "Beta"})
+
+	// Both are assigned the document, only ann finds something to annotate
+	mustExec(t, "INSERT INTO tasks (document_id, user_id, status, created_at) VALUES (?, ?, 'done', '2020-01-01'), (?, ?, 'done', '2020-01-01')",
+		documentID, ann, documentID, bob)
+	_, err := insertAnnotation(db, documentID, Annotation{CharacterStart: 0, CharacterEnd: 4, TopicID: partyID,
+		Status: StatusAccepted, Source: SourceHuman, UserID: &ann}, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := agreementReport(db, DefaultProjectID, []uint{documentID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []uint{ann, bob}; !reflect.DeepEqual(report.UserIDs, want) {
+		t.Fatalf("compared users %v, want %v", report.UserIDs, want)
+	}
+	if len(report.Pairs) != 1 || !sameFloat(report.Pairs[0].ExactF1, float(0)) {
+		t.Errorf("got pairs %+v, want a single pair without any agreed span", report.Pairs)
+	}
+}
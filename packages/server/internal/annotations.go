@@ -0,0 +1,330 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Annotation statuses
+const (
+	StatusSuggested = "suggested"
+	StatusAccepted  = "accepted"
+	StatusRejected  = "rejected"
+)
+
+// Annotation sources
+const (
+	SourceHuman = "human"
+	SourceRule  = "rule"
+	SourceModel = "model"
+)
+
+func validStatus(status string) bool {
+	return status == StatusSuggested || status == StatusAccepted || status == StatusRejected
+}
+
+func validSource(source string) bool {
+	return source == SourceHuman || source == SourceRule || source == SourceModel
+}
+
+// AnnotationFilter restricts which annotations are returned by queryAnnotations
+type AnnotationFilter struct {
+	DocumentID    uint
+	AnnotationID  uint
+	Statuses      []string
+	Sources       []string
+	MinConfidence *float64
+	ValueError    bool
+	UserIDs       []uint // the layers of these annotators
+	Gold          *bool  // only the gold layer, or everything but the gold layer
+}
+
+// parseAnnotationFilter reads the status, source, userId, gold, minConfidence and valueError query parameters.
+// status, source and userId can be repeated or comma separated.
+func parseAnnotationFilter(documentID uint, query url.Values) (AnnotationFilter, error) {
+	filter := AnnotationFilter{DocumentID: documentID}
+
+	for _, value := range query["status"] {
+		for _, status := range strings.Split(value, ",") {
+			if !validStatus(status) {
+				return filter, fmt.Errorf("Invalid status %q", status)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	for _, value := range query["source"] {
+		for _, source := range strings.Split(value, ",") {
+			if !validSource(source) {
+				return filter, fmt.Errorf("Invalid source %q", source)
+			}
+			filter.Sources = append(filter.Sources, source)
+		}
+	}
+
+	userIDs, err := parseIDs(query["userId"])
+	if err != nil {
+		return filter, fmt.Errorf("Invalid userId: %w", err)
+	}
+	filter.UserIDs = userIDs
+
+	if value := query.Get("gold"); value != "" {
+		gold := value == "true"
+		filter.Gold = &gold
+	}
+
+	filter.ValueError = query.Get("valueError") == "true"
+
+	if value := query.Get("minConfidence"); value != "" {
+		minConfidence, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid minConfidence: %w", err)
+		}
+		filter.MinConfidence = &minConfidence
+	}
+
+	return filter, nil
+}
+
+// parseIDs reads IDs from repeated or comma separated query parameters
+func parseIDs(values []string) ([]uint, error) {
+	ids := []uint{}
+
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			id, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, uint(id))
+		}
+	}
+
+	return ids, nil
+}
+
+func inClause(column string, values []string, args []interface{}) (string, []interface{}) {
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		placeholders[i] = "?"
+		args = append(args, value)
+	}
+
+	return fmt.Sprintf(" AND %s IN (%s)", column, strings.Join(placeholders, ", ")), args
+}
+
+// idInClause is inClause for integer columns, which do not match IDs bound as strings
+func idInClause(column string, ids []uint, args []interface{}) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+
+	return fmt.Sprintf(" AND %s IN (%s)", column, strings.Join(placeholders, ", ")), args
+}
+
+func queryAnnotations(q querier, filter AnnotationFilter) ([]Annotation, error) {
+	query := `SELECT a.annotation_id, a.character_start, a.character_end, a.page_start, a.page_end, a.top_px, a.left_px, t.topic_id, t.topic, a.text,
+									 a.status, a.source, a.confidence, a.model, a.notes, a.attributes, a.value, a.value_error, a.user_id, a.gold, a.source_annotation_id
+									FROM annotations a
+									INNER JOIN topics t ON t.topic_id = a.topic_id
+									WHERE a.document_id = ?`
+	args := []interface{}{filter.DocumentID}
+
+	if filter.AnnotationID != 0 {
+		query += " AND a.annotation_id = ?"
+		args = append(args, filter.AnnotationID)
+	}
+
+	if len(filter.Statuses) > 0 {
+		var clause string
+		clause, args = inClause("a.status", filter.Statuses, args)
+		query += clause
+	}
+
+	if len(filter.Sources) > 0 {
+		var clause string
+		clause, args = inClause("a.source", filter.Sources, args)
+		query += clause
+	}
+
+	if len(filter.UserIDs) > 0 {
+		var clause string
+		clause, args = idInClause("a.user_id", filter.UserIDs, args)
+		query += clause
+	}
+
+	if filter.Gold != nil {
+		query += " AND a.gold = ?"
+		args = append(args, *filter.Gold)
+	}
+
+	if filter.MinConfidence != nil {
+		query += " AND a.confidence >= ?"
+		args = append(args, *filter.MinConfidence)
+	}
+
+	if filter.ValueError {
+		query += " AND a.value_error != ''"
+	}
+
+	query += " ORDER BY a.character_start, a.character_end"
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query annotations: %w", err)
+	}
+	defer rows.Close()
+
+	annotations := []Annotation{}
+
+	for rows.Next() {
+		var annotation Annotation
+		var attributes string
+		var value sql.NullString
+
+		err = rows.Scan(&annotation.AnnotationID, &annotation.CharacterStart, &annotation.CharacterEnd, &annotation.PageStart,
+			&annotation.PageEnd, &annotation.Top, &annotation.Left, &annotation.TopicID, &annotation.Topic, &annotation.Text,
+			&annotation.Status, &annotation.Source, &annotation.Confidence, &annotation.Model, &annotation.Notes, &attributes,
+			&value, &annotation.ValueError, &annotation.UserID, &annotation.Gold, &annotation.SourceAnnotationID)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read annotation: %w", err)
+		}
+
+		if value.Valid {
+			annotation.Value = json.RawMessage(value.String)
+		}
+
+		err = json.Unmarshal([]byte(attributes), &annotation.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to unmarshal attributes of annotation %d: %w", annotation.AnnotationID, err)
+		}
+
+		annotations = append(annotations, annotation)
+	}
+
+	return annotations, rows.Err()
+}
+
+func getAnnotation(q querier, documentID, annotationID uint) (Annotation, error) {
+	annotations, err := queryAnnotations(q, AnnotationFilter{DocumentID: documentID, AnnotationID: annotationID})
+	if err != nil {
+		return Annotation{}, err
+	}
+
+	if len(annotations) == 0 {
+		return Annotation{}, sql.ErrNoRows
+	}
+
+	return annotations[0], nil
+}
+
+// spanText returns the text between the characters [start, end) of a document
+func spanText(q querier, documentID, start, end uint) (string, error) {
+	text, err := documentText(q, documentID)
+	if err != nil {
+		return "", err
+	}
+
+	if start >= end || end > uint(len(text)) {
+		return "", fmt.Errorf("Invalid span %d-%d for a document of %d characters", start, end, len(text))
+	}
+
+	return text[start:end], nil
+}
+
+// spanPosition returns where the characters [start, end) of a document are located in its pages. A span covering no
+// token, such as a blank between two words, has no position: its pages are 0.
+func spanPosition(q querier, documentID, start, end uint) (SpanLocation, error) {
+	pages, err := loadDocumentTokens(q, documentID)
+	if err != nil {
+		return SpanLocation{}, err
+	}
+
+	location, err := locateSpan(pages, start, end)
+	if err != nil {
+		return SpanLocation{}, nil
+	}
+
+	return location, nil
+}
+
+// marshalAttributes encodes attributes for the annotations.attributes column, nil being an empty object
+func marshalAttributes(attributes Attributes) (string, error) {
+	if attributes == nil {
+		attributes = Attributes{}
+	}
+
+	b, err := json.Marshal(attributes)
+	if err != nil {
+		return "", fmt.Errorf("Unable to marshal attributes: %w", err)
+	}
+
+	return string(b), nil
+}
+
+// insertAnnotation validates and stores a new annotation made by a user, or by nobody when userID is 0, and records it
+// in the audit log. Its text and value are computed from its boundaries, as well as its position when none is given.
+func insertAnnotation(q querier, documentID uint, annotation Annotation, userID uint) (uint, error) {
+	err := checkDocumentTopic(q, documentID, annotation.TopicID)
+	if err != nil {
+		return 0, err
+	}
+
+	text, err := spanText(q, documentID, annotation.CharacterStart, annotation.CharacterEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	// Pages start at 1, a page of 0 means the client did not compute the position. A span covering no token is still
+	// created, without a position.
+	if annotation.PageStart == 0 {
+		location, err := spanPosition(q, documentID, annotation.CharacterStart, annotation.CharacterEnd)
+		if err != nil {
+			return 0, err
+		}
+
+		annotation.PageStart = location.PageStart
+		annotation.PageEnd = location.PageEnd
+		annotation.Top = location.Top
+		annotation.Left = location.Left
+	}
+
+	attributes, err := marshalAttributes(annotation.Attributes)
+	if err != nil {
+		return 0, err
+	}
+
+	value, valueError, err := normalizeAnnotation(q, annotation.TopicID, text)
+	if err != nil {
+		return 0, err
+	}
+
+	var user interface{}
+	if userID != 0 {
+		user = userID
+	}
+
+	res, err := q.Exec(`INSERT INTO annotations (document_id, character_start, character_end, page_start, page_end, text, top_px, left_px, topic_id, status, source, confidence, model, notes, attributes, value, value_error, user_id, gold, source_annotation_id)
+								    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		documentID, annotation.CharacterStart, annotation.CharacterEnd, annotation.PageStart, annotation.PageEnd,
+		text, annotation.Top, annotation.Left, annotation.TopicID, annotation.Status, annotation.Source, annotation.Confidence,
+		annotation.Model, annotation.Notes, attributes, value, valueError, user, annotation.Gold, annotation.SourceAnnotationID)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to insert annotation: %w", err)
+	}
+
+	id, _ := res.LastInsertId()
+
+	created, err := getAnnotation(q, documentID, uint(id))
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(id), recordAnnotationChange(q, userID, documentID, nil, &created)
+}
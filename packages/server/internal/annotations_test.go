@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestInsertAnnotationPosition(t *testing.T) {
+	defer openTestDatabase(t)()
+	topicID := mustExec(t, "INSERT INTO topics (topic) VALUES ('Party')")
+	documentID := insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "Corp"})
+
+	tests := []struct {
+		name       string
+		annotation Annotation
+		pageStart  uint
+		left       uint
+	}{
+		{"computed", Annotation{CharacterStart: 5, CharacterEnd: 9}, 1, 60},
+		{"given by the client", Annotation{CharacterStart: 5, CharacterEnd: 9, PageStart: 1, PageEnd: 1, Left: 42}, 1, 42},
+		{"blank without token", Annotation{CharacterStart: 4, CharacterEnd: 5}, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.annotation.TopicID = topicID
+			test.annotation.Status, test.annotation.Source = StatusAccepted, SourceHuman
+
+			annotationID, err := insertAnnotation(db, documentID, test.annotation, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			annotation, err := getAnnotation(db, documentID, annotationID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if annotation.PageStart != test.pageStart || annotation.Left != test.left {
+				t.Errorf("created on page %d at %d, want page %d at %d", annotation.PageStart, annotation.Left, test.pageStart, test.left)
+			}
+		})
+	}
+
+	if _, err := insertAnnotation(db, documentID, Annotation{CharacterStart: 5, CharacterEnd: 20, TopicID: topicID}, 0); err == nil {
+		t.Error("created an annotation past the end of the document")
+	}
+}
+
+func TestPatchAnnotationWithoutPosition(t *testing.T) {
+	defer openTestDatabase(t)()
+	topicID := mustExec(t, "INSERT INTO topics (topic) VALUES ('Party')")
+	documentID := insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "Corp"})
+	rev := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('rev', 'hash', '2020-01-01')")
+	mustExec(t, "INSERT INTO role_assignments (user_id, project_id, role) VALUES (?, 1, 'reviewer')", rev)
+
+	tests := []struct {
+		name      string
+		patch     string
+		text      string
+		pageStart uint
+		left      uint
+	}{
+		{"notes", `{"notes": "blank"}`, " ", 0, 0},
+		{"status", `{"status": "rejected"}`, " ", 0, 0},
+		{"onto a token", `{"characterStart": 5, "characterEnd": 9}`, "Corp", 1, 60},
+		{"onto another blank", `{"characterStart": 9, "characterEnd": 10}`, " ", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotationID, err := insertAnnotation(db, documentID, Annotation{CharacterStart: 4, CharacterEnd: 5, TopicID: topicID,
+				Status: StatusAccepted, Source: SourceHuman}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := serveAs(PatchAnnotationHandler, rev, http.MethodPatch, test.patch,
+				map[string]string{"documentId": fmt.Sprint(documentID), "annotationId": fmt.Sprint(annotationID)})
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d %s, want 200", w.Code, w.Body.String())
+			}
+
+			annotation, err := getAnnotation(db, documentID, annotationID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if annotation.Text != test.text || annotation.PageStart != test.pageStart || annotation.Left != test.left {
+				t.Errorf("patched to %q on page %d at %d, want %q on page %d at %d", annotation.Text, annotation.PageStart,
+					annotation.Left, test.text, test.pageStart, test.left)
+			}
+		})
+	}
+}
@@ -0,0 +1,1155 @@
+package internal
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Changes recorded by the audit log
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// What the audit log records the changes of
+const (
+	EntityAnnotation = "annotation"
+	EntityTopic      = "topic"
+	EntityDocument   = "document"
+	EntityRelation   = "relation"
+)
+
+var errInconsistentUndo = errors.New("Cannot undo the change")
+
+func validEntity(entity string) bool {
+	return entity == EntityAnnotation || entity == EntityTopic || entity == EntityDocument || entity == EntityRelation
+}
+
+// recordChange appends an entry to the audit log and returns its ID, its Before is null for a creation and its After
+// null for a deletion. Updates which do not change anything are not recorded, their ID is 0.
+func recordChange(q querier, entry AuditEntry, userID uint) (uint, error) {
+	created := bytes.Equal(entry.Before, []byte("null"))
+	deleted := bytes.Equal(entry.After, []byte("null"))
+
+	switch {
+	case created:
+		entry.Action = ActionCreate
+	case deleted:
+		entry.Action = ActionDelete
+	case bytes.Equal(entry.Before, entry.After):
+		return 0, nil
+	default:
+		entry.Action = ActionUpdate
+	}
+
+	var before, after sql.NullString
+	if !created {
+		before = sql.NullString{String: string(entry.Before), Valid: true}
+	}
+	if !deleted {
+		after = sql.NullString{String: string(entry.After), Valid: true}
+	}
+
+	var actor interface{}
+	if userID != 0 {
+		actor = userID
+	}
+
+	res, err := q.Exec(`INSERT INTO audit_log (project_id, document_id, entity, entity_id, action, user_id, before, after, reverts_id, cause_id, created_at)
+								VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ProjectID, entry.DocumentID, entry.Entity, entry.EntityID, entry.Action, actor, before, after, entry.RevertsID, entry.CauseID, time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("Unable to record %s of %s %d: %w", entry.Action, entry.Entity, entry.EntityID, err)
+	}
+
+	id, _ := res.LastInsertId()
+	return uint(id), nil
+}
+
+// annotationEntry describes the creation, update or deletion of an annotation, before or after being nil
+func annotationEntry(q querier, documentID uint, before, after *Annotation) (AuditEntry, error) {
+	entry := AuditEntry{Entity: EntityAnnotation, DocumentID: &documentID}
+
+	var err error
+	entry.ProjectID, err = documentProject(q, documentID)
+	if err != nil {
+		return entry, err
+	}
+
+	if before != nil {
+		entry.EntityID = before.AnnotationID
+	} else {
+		entry.EntityID = after.AnnotationID
+	}
+
+	entry.Before, _ = json.Marshal(before)
+	entry.After, _ = json.Marshal(after)
+
+	return entry, nil
+}
+
+// recordAnnotationChange records the creation, update or deletion of an annotation, before or after being nil
+func recordAnnotationChange(q querier, userID, documentID uint, before, after *Annotation) error {
+	entry, err := annotationEntry(q, documentID, before, after)
+	if err != nil {
+		return err
+	}
+
+	_, err = recordChange(q, entry, userID)
+	return err
+}
+
+// relationEntry describes the creation, update or deletion of a relation, before or after being nil
+func relationEntry(q querier, documentID uint, before, after *Relation) (AuditEntry, error) {
+	entry := AuditEntry{Entity: EntityRelation, DocumentID: &documentID}
+
+	var err error
+	entry.ProjectID, err = documentProject(q, documentID)
+	if err != nil {
+		return entry, err
+	}
+
+	if before != nil {
+		entry.EntityID = before.RelationID
+	} else {
+		entry.EntityID = after.RelationID
+	}
+
+	entry.Before, _ = json.Marshal(before)
+	entry.After, _ = json.Marshal(after)
+
+	return entry, nil
+}
+
+// recordRelationChange records the creation, update or deletion of a relation, before or after being nil
+func recordRelationChange(q querier, userID, documentID uint, before, after *Relation) error {
+	entry, err := relationEntry(q, documentID, before, after)
+	if err != nil {
+		return err
+	}
+
+	_, err = recordChange(q, entry, userID)
+	return err
+}
+
+// topicEntry describes the creation, update or deletion of a topic, before or after being nil
+func topicEntry(before, after *Topic) AuditEntry {
+	entry := AuditEntry{Entity: EntityTopic}
+
+	if before != nil {
+		entry.ProjectID, entry.EntityID = before.ProjectID, before.TopicID
+	} else {
+		entry.ProjectID, entry.EntityID = after.ProjectID, after.TopicID
+	}
+
+	entry.Before, _ = json.Marshal(before)
+	entry.After, _ = json.Marshal(after)
+
+	return entry
+}
+
+// recordTopicChange records the creation, update or deletion of a topic, before or after being nil
+func recordTopicChange(q querier, userID uint, before, after *Topic) error {
+	_, err := recordChange(q, topicEntry(before, after), userID)
+	return err
+}
+
+// documentEntry describes the creation, update or deletion of a document, before or after being nil
+func documentEntry(before, after *DocumentSummary) AuditEntry {
+	entry := AuditEntry{Entity: EntityDocument}
+
+	if before != nil {
+		entry.ProjectID, entry.EntityID = before.ProjectID, before.ID
+	} else {
+		entry.ProjectID, entry.EntityID = after.ProjectID, after.ID
+	}
+	entry.DocumentID = &entry.EntityID
+
+	entry.Before, _ = json.Marshal(before)
+	entry.After, _ = json.Marshal(after)
+
+	return entry
+}
+
+// recordDocumentChange records the creation, update or deletion of a document, before or after being nil
+func recordDocumentChange(q querier, userID uint, before, after *DocumentSummary) error {
+	_, err := recordChange(q, documentEntry(before, after), userID)
+	return err
+}
+
+// recordAnnotationDeletions records the deletion of annotations of a document, as caused by the entry causeID when it
+// is not nil. The relations deleted along with them, among the relations of the document before the deletion, are
+// recorded as caused by the deletion of their last end, so that undoing it restores both ends first.
+func recordAnnotationDeletions(q querier, userID, documentID uint, annotations []Annotation, relations []Relation, causeID *uint) error {
+	deletions := map[uint]uint{}
+
+	for i := range annotations {
+		entry, err := annotationEntry(q, documentID, &annotations[i], nil)
+		if err != nil {
+			return err
+		}
+		entry.CauseID = causeID
+
+		deletions[annotations[i].AnnotationID], err = recordChange(q, entry, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, relation := range relations {
+		cause := deletions[relation.FromAnnotationID]
+		if deletions[relation.ToAnnotationID] > cause {
+			cause = deletions[relation.ToAnnotationID]
+		}
+		if cause == 0 {
+			continue
+		}
+
+		entry, err := relationEntry(q, documentID, &relations[i], nil)
+		if err != nil {
+			return err
+		}
+		entry.CauseID = &cause
+
+		_, err = recordChange(q, entry, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordAnnotationUpdates records the updates of annotations, given as they were before by document, as caused by the
+// entry causeID when it is not nil
+func recordAnnotationUpdates(q querier, userID uint, updated map[uint][]Annotation, causeID *uint) error {
+	documentIDs := []uint{}
+	for documentID := range updated {
+		documentIDs = append(documentIDs, documentID)
+	}
+	sort.Slice(documentIDs, func(i, j int) bool { return documentIDs[i] < documentIDs[j] })
+
+	for _, documentID := range documentIDs {
+		for i := range updated[documentID] {
+			entry, err := annotationUpdate(q, documentID, updated[documentID][i])
+			if err != nil {
+				return err
+			}
+			entry.CauseID = causeID
+
+			_, err = recordChange(q, entry, userID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// annotationUpdate describes the update of an annotation since it was as before
+func annotationUpdate(q querier, documentID uint, before Annotation) (AuditEntry, error) {
+	after, err := getAnnotation(q, documentID, before.AnnotationID)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	return annotationEntry(q, documentID, &before, &after)
+}
+
+// recordDocumentDeletion records the deletion of a document, then of its annotations and relations as caused by it. It
+// is called before the document is deleted, which deletes the rest.
+func recordDocumentDeletion(q querier, userID uint, document DocumentSummary) error {
+	annotations, err := queryAnnotations(q, AnnotationFilter{DocumentID: document.ID})
+	if err != nil {
+		return err
+	}
+
+	relations, err := queryRelations(q, document.ID)
+	if err != nil {
+		return err
+	}
+
+	causeID, err := recordChange(q, documentEntry(&document, nil), userID)
+	if err != nil {
+		return err
+	}
+
+	return recordAnnotationDeletions(q, userID, document.ID, annotations, relations, &causeID)
+}
+
+func getDocumentSummary(q querier, documentID uint) (DocumentSummary, error) {
+	var document DocumentSummary
+
+	err := q.QueryRow("SELECT document_id, project_id, name, COALESCE(pages, 0), processed, status FROM documents WHERE document_id = ?", documentID).
+		Scan(&document.ID, &document.ProjectID, &document.Name, &document.Pages, &document.Processed, &document.Status)
+
+	return document, err
+}
+
+// topicAnnotations returns the annotations of a topic, by document, before deleting or merging it
+func topicAnnotations(q querier, topicID uint) (map[uint][]Annotation, error) {
+	rows, err := q.Query("SELECT DISTINCT document_id FROM annotations WHERE topic_id = ?", topicID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query annotations: %w", err)
+	}
+
+	documentIDs := []uint{}
+	for rows.Next() {
+		var documentID uint
+
+		err = rows.Scan(&documentID)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("Unable to read annotation: %w", err)
+		}
+
+		documentIDs = append(documentIDs, documentID)
+	}
+	rows.Close()
+
+	annotations := map[uint][]Annotation{}
+	for _, documentID := range documentIDs {
+		documentAnnotations, err := queryAnnotations(q, AnnotationFilter{DocumentID: documentID})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, annotation := range documentAnnotations {
+			if annotation.TopicID == topicID {
+				annotations[documentID] = append(annotations[documentID], annotation)
+			}
+		}
+	}
+
+	return annotations, nil
+}
+
+// childTopics returns the topics directly nested under a topic
+func childTopics(q querier, topicID uint) (Topics, error) {
+	topics, err := queryTopics(q, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	children := Topics{}
+	for _, topic := range topics {
+		if topic.ParentID != nil && *topic.ParentID == topicID {
+			children = append(children, topic)
+		}
+	}
+
+	return children, nil
+}
+
+// topicRemoval is what deleting or merging a topic changes, captured before the change to record it after: the topic,
+// its children, its annotations by document and the relations of these documents
+type topicRemoval struct {
+	Topic       Topic
+	Children    Topics
+	Annotations map[uint][]Annotation
+	Relations   map[uint][]Relation
+}
+
+func captureTopicRemoval(q querier, topicID uint) (topicRemoval, error) {
+	var removal topicRemoval
+	var err error
+
+	removal.Topic, err = getTopic(q, topicID)
+	if err != nil {
+		return removal, err
+	}
+
+	removal.Children, err = childTopics(q, topicID)
+	if err != nil {
+		return removal, err
+	}
+
+	removal.Annotations, err = topicAnnotations(q, topicID)
+	if err != nil {
+		return removal, err
+	}
+
+	removal.Relations = map[uint][]Relation{}
+	for documentID := range removal.Annotations {
+		removal.Relations[documentID], err = queryRelations(q, documentID)
+		if err != nil {
+			return removal, err
+		}
+	}
+
+	return removal, nil
+}
+
+// record records what deleting or merging the topic changed: the topic itself, then as caused by its deletion its
+// children moved to another parent, its annotations moved to another topic or deleted with it, and the relations
+// deleted with these annotations. It returns the ID of the entry of the deletion.
+func (removal topicRemoval) record(q querier, userID uint) (uint, error) {
+	causeID, err := recordChange(q, topicEntry(&removal.Topic, nil), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range removal.Children {
+		after, err := getTopic(q, removal.Children[i].TopicID)
+		if err != nil {
+			return 0, err
+		}
+
+		entry := topicEntry(&removal.Children[i], &after)
+		entry.CauseID = &causeID
+		_, err = recordChange(q, entry, userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	documentIDs := []uint{}
+	for documentID := range removal.Annotations {
+		documentIDs = append(documentIDs, documentID)
+	}
+	sort.Slice(documentIDs, func(i, j int) bool { return documentIDs[i] < documentIDs[j] })
+
+	for _, documentID := range documentIDs {
+		deleted := []Annotation{}
+
+		for _, before := range removal.Annotations[documentID] {
+			after, err := getAnnotation(q, documentID, before.AnnotationID)
+			if err == sql.ErrNoRows {
+				deleted = append(deleted, before)
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+
+			entry, err := annotationEntry(q, documentID, &before, &after)
+			if err != nil {
+				return 0, err
+			}
+			entry.CauseID = &causeID
+			_, err = recordChange(q, entry, userID)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		err = recordAnnotationDeletions(q, userID, documentID, deleted, removal.Relations[documentID], &causeID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return causeID, nil
+}
+
+const auditColumns = "audit_id, project_id, document_id, entity, entity_id, action, user_id, before, after, reverts_id, cause_id, created_at"
+
+func scanAuditEntry(row interface{ Scan(...interface{}) error }) (AuditEntry, error) {
+	var entry AuditEntry
+	var before, after sql.NullString
+
+	err := row.Scan(&entry.AuditID, &entry.ProjectID, &entry.DocumentID, &entry.Entity, &entry.EntityID, &entry.Action,
+		&entry.UserID, &before, &after, &entry.RevertsID, &entry.CauseID, &entry.CreatedAt)
+	if err != nil {
+		return entry, err
+	}
+
+	entry.Before = json.RawMessage("null")
+	if before.Valid {
+		entry.Before = json.RawMessage(before.String)
+	}
+
+	entry.After = json.RawMessage("null")
+	if after.Valid {
+		entry.After = json.RawMessage(after.String)
+	}
+
+	return entry, nil
+}
+
+func getAuditEntry(q querier, auditID uint) (AuditEntry, error) {
+	return scanAuditEntry(q.QueryRow("SELECT "+auditColumns+" FROM audit_log WHERE audit_id = ?", auditID))
+}
+
+func auditProject(q querier, auditID uint) (uint, error) {
+	var projectID uint
+
+	err := q.QueryRow("SELECT project_id FROM audit_log WHERE audit_id = ?", auditID).Scan(&projectID)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read project of audit entry %d: %w", auditID, err)
+	}
+
+	return projectID, nil
+}
+
+// queryAuditLog lists the audit entries of a project, only those of a document when documentID is not 0
+func queryAuditLog(q querier, projectID, documentID uint, entities []string) (AuditEntries, error) {
+	query := "SELECT " + auditColumns + " FROM audit_log WHERE project_id = ?"
+	args := []interface{}{projectID}
+
+	if documentID != 0 {
+		query += " AND document_id = ?"
+		args = append(args, documentID)
+	}
+
+	if len(entities) > 0 {
+		var clause string
+		clause, args = inClause("entity", entities, args)
+		query += clause
+	}
+
+	rows, err := q.Query(query+" ORDER BY audit_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := AuditEntries{}
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read audit entry: %w", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// sameAnnotation tells if an annotation is still as an audit entry recorded it, whatever the name of its topic
+func sameAnnotation(current Annotation, recorded json.RawMessage) (bool, Annotation) {
+	var annotation Annotation
+	if json.Unmarshal(recorded, &annotation) != nil {
+		return false, annotation
+	}
+
+	current.Topic, annotation.Topic = "", ""
+	a, _ := json.Marshal(current)
+	b, _ := json.Marshal(annotation)
+
+	return bytes.Equal(a, b), annotation
+}
+
+// insertAnnotationRow inserts an annotation as it was recorded, with its text, position and value, keeping its ID
+// unless it is 0
+func insertAnnotationRow(q querier, documentID uint, annotation Annotation) (uint, error) {
+	attributes, err := marshalAttributes(annotation.Attributes)
+	if err != nil {
+		return 0, err
+	}
+
+	var value sql.NullString
+	if annotation.Value != nil {
+		value = sql.NullString{String: string(annotation.Value), Valid: true}
+	}
+
+	res, err := q.Exec(`INSERT INTO annotations (annotation_id, document_id, character_start, character_end, page_start, page_end, text, top_px, left_px, topic_id, status, source, confidence, model, notes, attributes, value, value_error, user_id, gold, source_annotation_id)
+								VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullID(annotation.AnnotationID), documentID, annotation.CharacterStart, annotation.CharacterEnd, annotation.PageStart, annotation.PageEnd,
+		annotation.Text, annotation.Top, annotation.Left, annotation.TopicID, annotation.Status, annotation.Source, annotation.Confidence,
+		annotation.Model, annotation.Notes, attributes, value, annotation.ValueError, annotation.UserID, annotation.Gold, annotation.SourceAnnotationID)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to restore annotation %d: %w", annotation.AnnotationID, err)
+	}
+
+	id, _ := res.LastInsertId()
+	return uint(id), nil
+}
+
+// restoreAnnotation writes back an annotation as an audit entry recorded it, inserting it again when it was deleted
+func restoreAnnotation(q querier, documentID uint, annotation Annotation, insert bool) error {
+	err := checkDocumentTopic(q, documentID, annotation.TopicID)
+	if err != nil {
+		return err
+	}
+
+	if insert {
+		_, err = insertAnnotationRow(q, documentID, annotation)
+		return err
+	}
+
+	attributes, err := marshalAttributes(annotation.Attributes)
+	if err != nil {
+		return err
+	}
+
+	var value sql.NullString
+	if annotation.Value != nil {
+		value = sql.NullString{String: string(annotation.Value), Valid: true}
+	}
+
+	_, err = q.Exec(`UPDATE annotations SET character_start = ?, character_end = ?, page_start = ?, page_end = ?, text = ?, top_px = ?, left_px = ?,
+								topic_id = ?, status = ?, source = ?, confidence = ?, model = ?, notes = ?, attributes = ?, value = ?, value_error = ?, user_id = ?, gold = ?,
+								source_annotation_id = ?
+								WHERE annotation_id = ?`,
+		annotation.CharacterStart, annotation.CharacterEnd, annotation.PageStart, annotation.PageEnd, annotation.Text,
+		annotation.Top, annotation.Left, annotation.TopicID, annotation.Status, annotation.Source, annotation.Confidence,
+		annotation.Model, annotation.Notes, attributes, value, annotation.ValueError, annotation.UserID, annotation.Gold, annotation.SourceAnnotationID,
+		annotation.AnnotationID)
+	if err != nil {
+		return fmt.Errorf("Unable to restore annotation %d: %w", annotation.AnnotationID, err)
+	}
+
+	return nil
+}
+
+// restoreTopic writes back a topic as an audit entry recorded it, inserting it again when it was deleted
+func restoreTopic(tx *sql.Tx, topic Topic, insert bool) error {
+	if topic.ParentID != nil {
+		err := checkTopicsProject(tx, topic.ProjectID, *topic.ParentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	enumValues, err := marshalEnumValues(topic.EnumValues)
+	if err != nil {
+		return err
+	}
+
+	if insert {
+		_, err = tx.Exec("INSERT INTO topics (topic_id, project_id, topic, parent_topic_id, color, description, shortcut, value_type, enum_values) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			topic.TopicID, topic.ProjectID, topic.Topic, topic.ParentID, topic.Color, topic.Description, nullString(topic.Shortcut), topic.ValueType, enumValues)
+	} else {
+		_, err = tx.Exec("UPDATE topics SET topic = ?, parent_topic_id = ?, color = ?, description = ?, shortcut = ?, value_type = ?, enum_values = ? WHERE topic_id = ?",
+			topic.Topic, topic.ParentID, topic.Color, topic.Description, nullString(topic.Shortcut), topic.ValueType, enumValues, topic.TopicID)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to restore topic %d: %w", topic.TopicID, err)
+	}
+
+	return nil
+}
+
+// causedEntries lists the entries recorded as caused by an entry, in the order they were recorded
+func causedEntries(q querier, auditID uint) (AuditEntries, error) {
+	rows, err := q.Query("SELECT "+auditColumns+" FROM audit_log WHERE cause_id = ? ORDER BY audit_id", auditID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := AuditEntries{}
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read audit entry: %w", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// undoAnnotationChange reverts a change of an annotation, when the annotation is still as the change left it, and
+// returns the entry recording the undo
+func undoAnnotationChange(q querier, entry AuditEntry) (AuditEntry, error) {
+	documentID := *entry.DocumentID
+	revert := AuditEntry{
+		ProjectID:  entry.ProjectID,
+		DocumentID: entry.DocumentID,
+		Entity:     EntityAnnotation,
+		EntityID:   entry.EntityID,
+		RevertsID:  &entry.AuditID,
+	}
+
+	current, err := getAnnotation(q, documentID, entry.EntityID)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return revert, err
+	}
+
+	if entry.Action == ActionDelete {
+		if exists {
+			return revert, fmt.Errorf("%w: annotation %d exists again", errInconsistentUndo, entry.EntityID)
+		}
+
+		var before Annotation
+		err = json.Unmarshal(entry.Before, &before)
+		if err != nil {
+			return revert, fmt.Errorf("Unable to read audit entry %d: %w", entry.AuditID, err)
+		}
+
+		err = restoreAnnotation(q, documentID, before, true)
+		if err != nil {
+			return revert, fmt.Errorf("%w: %v", errInconsistentUndo, err)
+		}
+	} else {
+		if !exists {
+			return revert, fmt.Errorf("%w: annotation %d has been deleted since", errInconsistentUndo, entry.EntityID)
+		}
+
+		if same, _ := sameAnnotation(current, entry.After); !same {
+			return revert, fmt.Errorf("%w: annotation %d has been changed since", errInconsistentUndo, entry.EntityID)
+		}
+
+		if entry.Action == ActionCreate {
+			// Deleting the annotation would delete its relations without recording it
+			var relations uint
+			err = q.QueryRow("SELECT COUNT(*) FROM relations WHERE from_annotation_id = ? OR to_annotation_id = ?", entry.EntityID, entry.EntityID).
+				Scan(&relations)
+			if err != nil {
+				return revert, fmt.Errorf("Unable to query relations: %w", err)
+			}
+			if relations > 0 {
+				return revert, fmt.Errorf("%w: annotation %d has relations since", errInconsistentUndo, entry.EntityID)
+			}
+
+			_, err = q.Exec("DELETE FROM annotations WHERE annotation_id = ?", entry.EntityID)
+		} else {
+			var before Annotation
+			err = json.Unmarshal(entry.Before, &before)
+			if err == nil {
+				err = restoreAnnotation(q, documentID, before, false)
+			}
+		}
+		if err != nil {
+			return revert, fmt.Errorf("%w: %v", errInconsistentUndo, err)
+		}
+	}
+
+	var after *Annotation
+	if entry.Action != ActionCreate {
+		annotation, err := getAnnotation(q, documentID, entry.EntityID)
+		if err != nil {
+			return revert, err
+		}
+		after = &annotation
+	}
+
+	revert.Before, _ = json.Marshal(&current)
+	if !exists {
+		revert.Before = json.RawMessage("null")
+	}
+	revert.After, _ = json.Marshal(after)
+
+	return revert, nil
+}
+
+// sameRelation tells if a relation is still as an audit entry recorded it, whatever the name of its type
+func sameRelation(current Relation, recorded json.RawMessage) (bool, Relation) {
+	var relation Relation
+	if json.Unmarshal(recorded, &relation) != nil {
+		return false, relation
+	}
+
+	current.RelationType, relation.RelationType = "", ""
+	a, _ := json.Marshal(current)
+	b, _ := json.Marshal(relation)
+
+	return bytes.Equal(a, b), relation
+}
+
+// restoreRelation writes back a relation as an audit entry recorded it, inserting it again when it was deleted
+func restoreRelation(q querier, documentID uint, relation Relation, insert bool) error {
+	err := checkRelationEnds(q, documentID, relation)
+	if err != nil {
+		return err
+	}
+
+	if insert {
+		_, err = q.Exec("INSERT INTO relations (relation_id, document_id, relation_type_id, from_annotation_id, to_annotation_id, user_id) VALUES (?, ?, ?, ?, ?, ?)",
+			relation.RelationID, documentID, relation.RelationTypeID, relation.FromAnnotationID, relation.ToAnnotationID, relation.UserID)
+	} else {
+		_, err = q.Exec("UPDATE relations SET relation_type_id = ?, from_annotation_id = ?, to_annotation_id = ?, user_id = ? WHERE relation_id = ?",
+			relation.RelationTypeID, relation.FromAnnotationID, relation.ToAnnotationID, relation.UserID, relation.RelationID)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to restore relation %d: %w", relation.RelationID, err)
+	}
+
+	return nil
+}
+
+// undoRelationChange reverts a change of a relation, when the relation is still as the change left it, and returns the
+// entry recording the undo
+func undoRelationChange(q querier, entry AuditEntry) (AuditEntry, error) {
+	documentID := *entry.DocumentID
+	revert := AuditEntry{
+		ProjectID:  entry.ProjectID,
+		DocumentID: entry.DocumentID,
+		Entity:     EntityRelation,
+		EntityID:   entry.EntityID,
+		RevertsID:  &entry.AuditID,
+	}
+
+	current, err := getRelation(q, documentID, entry.EntityID)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return revert, err
+	}
+
+	if entry.Action == ActionDelete {
+		if exists {
+			return revert, fmt.Errorf("%w: relation %d exists again", errInconsistentUndo, entry.EntityID)
+		}
+
+		var before Relation
+		err = json.Unmarshal(entry.Before, &before)
+		if err != nil {
+			return revert, fmt.Errorf("Unable to read audit entry %d: %w", entry.AuditID, err)
+		}
+
+		err = restoreRelation(q, documentID, before, true)
+	} else {
+		if !exists {
+			return revert, fmt.Errorf("%w: relation %d has been deleted since", errInconsistentUndo, entry.EntityID)
+		}
+
+		if same, _ := sameRelation(current, entry.After); !same {
+			return revert, fmt.Errorf("%w: relation %d has been changed since", errInconsistentUndo, entry.EntityID)
+		}
+
+		if entry.Action == ActionCreate {
+			_, err = q.Exec("DELETE FROM relations WHERE relation_id = ?", entry.EntityID)
+		} else {
+			var before Relation
+			err = json.Unmarshal(entry.Before, &before)
+			if err == nil {
+				err = restoreRelation(q, documentID, before, false)
+			}
+		}
+	}
+	if err != nil {
+		return revert, fmt.Errorf("%w: %v", errInconsistentUndo, err)
+	}
+
+	var after *Relation
+	if entry.Action != ActionCreate {
+		relation, err := getRelation(q, documentID, entry.EntityID)
+		if err != nil {
+			return revert, err
+		}
+		after = &relation
+	}
+
+	revert.Before, _ = json.Marshal(&current)
+	if !exists {
+		revert.Before = json.RawMessage("null")
+	}
+	revert.After, _ = json.Marshal(after)
+
+	return revert, nil
+}
+
+// undoTopicChange reverts a change of a topic, when the topic is still as the change left it, and returns the entry
+// recording the undo
+func undoTopicChange(tx *sql.Tx, entry AuditEntry) (AuditEntry, error) {
+	revert := AuditEntry{ProjectID: entry.ProjectID, Entity: EntityTopic, EntityID: entry.EntityID, RevertsID: &entry.AuditID}
+
+	current, err := getTopic(tx, entry.EntityID)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return revert, err
+	}
+
+	var before Topic
+	if entry.Action != ActionCreate {
+		err = json.Unmarshal(entry.Before, &before)
+		if err != nil {
+			return revert, fmt.Errorf("Unable to read audit entry %d: %w", entry.AuditID, err)
+		}
+	}
+
+	if entry.Action == ActionDelete {
+		if exists {
+			return revert, fmt.Errorf("%w: topic %d exists again", errInconsistentUndo, entry.EntityID)
+		}
+
+		err = restoreTopic(tx, before, true)
+		if err != nil {
+			return revert, fmt.Errorf("%w: %v", errInconsistentUndo, err)
+		}
+	} else {
+		if !exists {
+			return revert, fmt.Errorf("%w: topic %d has been deleted since", errInconsistentUndo, entry.EntityID)
+		}
+
+		currentJSON, _ := json.Marshal(current)
+		if !bytes.Equal(currentJSON, entry.After) {
+			return revert, fmt.Errorf("%w: topic %d has been changed since", errInconsistentUndo, entry.EntityID)
+		}
+
+		if entry.Action == ActionCreate {
+			documentIDs, err := topicDocuments(tx, entry.EntityID)
+			if err != nil {
+				return revert, err
+			}
+
+			children, err := childTopics(tx, entry.EntityID)
+			if err != nil {
+				return revert, err
+			}
+
+			if len(documentIDs) > 0 || len(children) > 0 {
+				return revert, fmt.Errorf("%w: topic %d is used since", errInconsistentUndo, entry.EntityID)
+			}
+
+			_, err = tx.Exec("DELETE FROM topics WHERE topic_id = ?", entry.EntityID)
+		} else {
+			err = restoreTopic(tx, before, false)
+		}
+		if err != nil {
+			return revert, fmt.Errorf("%w: %v", errInconsistentUndo, err)
+		}
+	}
+
+	var beforeUndo, afterUndo *Topic
+	if exists {
+		beforeUndo = &current
+	}
+	if entry.Action != ActionCreate {
+		topic, err := getTopic(tx, entry.EntityID)
+		if err != nil {
+			return revert, err
+		}
+		afterUndo = &topic
+	}
+
+	revert.Before, _ = json.Marshal(beforeUndo)
+	revert.After, _ = json.Marshal(afterUndo)
+
+	return revert, nil
+}
+
+// revert is the entry recording the undo of a change, along with the undos of the changes it caused
+type revert struct {
+	Entry  AuditEntry
+	Caused []revert
+}
+
+// undoChange reverts a change and the changes it caused, like the annotations deleted with a topic. A creation is
+// reverted after the changes it caused, which depend on it, other changes before them. Nothing is recorded yet.
+func undoChange(tx *sql.Tx, entry AuditEntry) (revert, error) {
+	var r revert
+
+	caused, err := causedEntries(tx, entry.AuditID)
+	if err != nil {
+		return r, err
+	}
+
+	// The undos are kept in the order of the changes they revert, so that undoing them again is done in the right order
+	if entry.Action == ActionCreate {
+		r.Caused = make([]revert, len(caused))
+		for i := len(caused) - 1; i >= 0; i-- {
+			r.Caused[i], err = undoChange(tx, caused[i])
+			if err != nil {
+				return r, err
+			}
+		}
+	}
+
+	switch entry.Entity {
+	case EntityAnnotation:
+		r.Entry, err = undoAnnotationChange(tx, entry)
+	case EntityRelation:
+		r.Entry, err = undoRelationChange(tx, entry)
+	case EntityTopic:
+		r.Entry, err = undoTopicChange(tx, entry)
+	default:
+		err = fmt.Errorf("%w: changes of %ss cannot be undone", errInconsistentUndo, entry.Entity)
+	}
+	if err != nil {
+		return r, err
+	}
+
+	if entry.Action != ActionCreate {
+		for _, c := range caused {
+			undone, err := undoChange(tx, c)
+			if err != nil {
+				return r, err
+			}
+			r.Caused = append(r.Caused, undone)
+		}
+	}
+
+	// The values of the annotations recorded as caused by the change are restored with them, those of the annotations
+	// created since follow the value type of the restored topic
+	if entry.Entity == EntityTopic && entry.Action != ActionCreate {
+		updated, err := normalizeTopicAnnotations(tx, entry.EntityID)
+		if err != nil {
+			return r, err
+		}
+
+		documentIDs := []uint{}
+		for documentID := range updated {
+			documentIDs = append(documentIDs, documentID)
+		}
+		sort.Slice(documentIDs, func(i, j int) bool { return documentIDs[i] < documentIDs[j] })
+
+		for _, documentID := range documentIDs {
+			for _, before := range updated[documentID] {
+				normalized, err := annotationUpdate(tx, documentID, before)
+				if err != nil {
+					return r, err
+				}
+				r.Caused = append(r.Caused, revert{Entry: normalized})
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// record records the undo of a change as caused by the entry causeID when it is not nil, then the undos of the
+// changes it caused as caused by it
+func (r revert) record(q querier, userID uint, causeID *uint) error {
+	r.Entry.CauseID = causeID
+
+	id, err := recordChange(q, r.Entry, userID)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		causeID = &id
+	}
+
+	for _, c := range r.Caused {
+		err = c.record(q, userID, causeID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// changed lists what an undo changed: whether topics did, and the documents whose annotations or relations did
+func (r revert) changed(topics *bool, annotations, relations map[uint]bool) {
+	switch r.Entry.Entity {
+	case EntityTopic:
+		*topics = true
+	case EntityAnnotation:
+		annotations[*r.Entry.DocumentID] = true
+	case EntityRelation:
+		relations[*r.Entry.DocumentID] = true
+	}
+
+	for _, c := range r.Caused {
+		c.changed(topics, annotations, relations)
+	}
+}
+
+// GetAuditLogHandler lists the changes made in a project, filtered by ?entity=
+func GetAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	entities := r.URL.Query()["entity"]
+	for _, entity := range entities {
+		if !validEntity(entity) {
+			http.Error(w, fmt.Sprintf("Invalid entity %q, expected annotation, relation, topic or document", entity), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := queryAuditLog(db, projectID(r), 0, entities)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GetDocumentAuditLogHandler lists the changes made to a document and its annotations
+func GetDocumentAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	projectID, err := documentProject(db, uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	entries, err := queryAuditLog(db, projectID, uint(documentID), r.URL.Query()["entity"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// UndoHandler reverts a change of an annotation, a relation or a topic, along with the changes it caused, when nothing
+// changed them since. The undo is itself recorded in the audit log, and can be undone the same way.
+func UndoHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	auditID, _ := strconv.Atoi(params["auditId"])
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	entry, err := getAuditEntry(tx, uint(auditID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Audit entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	undone, err := undoChange(tx, entry)
+	if err == nil {
+		err = undone.record(tx, currentUser(r).UserID, nil)
+	}
+	if errors.Is(err, errInconsistentUndo) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	topics, annotations, relations := false, map[uint]bool{}, map[uint]bool{}
+	undone.changed(&topics, annotations, relations)
+
+	if topics {
+		Broadcast(entry.ProjectID, `{"type":"topicsChanged"}`)
+	}
+	for documentID := range annotations {
+		BroadcastDocument(documentID, fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d}`, documentID))
+	}
+	for documentID := range relations {
+		BroadcastDocument(documentID, fmt.Sprintf(`{"type":"relationsChanged", "documentId":%d}`, documentID))
+	}
+}
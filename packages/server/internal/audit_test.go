@@ -0,0 +1,390 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// auditFixture creates a document with two annotations of a topic related to each other, and one of another topic
+func auditFixture(t *testing.T) (documentID, partyID, childID uint) {
+	t.Helper()
+
+	partyID = mustExec(t, "INSERT INTO topics (topic) VALUES ('Party')")
+	childID = mustExec(t, "INSERT INTO topics (topic, parent_topic_id) VALUES ('Buyer', ?)", partyID)
+	dateID := mustExec(t, "INSERT INTO topics (topic) VALUES ('Date')")
+	relationTypeID := mustExec(t, "INSERT INTO relation_types (relation_type) VALUES ('signedOn')")
+	documentID = insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "and", "Beta", "on", "May"})
+
+	for _, annotation := range []Annotation{
+		{CharacterStart: 0, CharacterEnd: 4, TopicID: partyID},
+		{CharacterStart: 9, CharacterEnd: 13, TopicID: partyID},
+		{CharacterStart: 17, CharacterEnd: 20, TopicID: dateID},
+	} {
+		annotation.Status, annotation.Source = StatusAccepted, SourceHuman
+		_, err := insertAnnotation(db, documentID, annotation, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, relation := range []Relation{{FromAnnotationID: 1, ToAnnotationID: 2}, {FromAnnotationID: 2, ToAnnotationID: 3}} {
+		relation.RelationTypeID = relationTypeID
+		_, err := insertRelation(db, documentID, relation, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return documentID, partyID, childID
+}
+
+// auditState describes the annotations, relations and topics of a document, to compare them before and after an undo
+func auditState(t *testing.T, documentID uint) string {
+	t.Helper()
+
+	annotations, err := queryAnnotations(db, AnnotationFilter{DocumentID: documentID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relations, err := queryRelations(db, documentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topics, err := queryTopics(db, DefaultProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := ""
+	for _, a := range annotations {
+		state += fmt.Sprintf("annotation %d %s %q; ", a.AnnotationID, a.Topic, a.Text)
+	}
+	for _, r := range relations {
+		state += fmt.Sprintf("relation %d %d-%d; ", r.RelationID, r.FromAnnotationID, r.ToAnnotationID)
+	}
+	for _, topic := range topics {
+		parent := uint(0)
+		if topic.ParentID != nil {
+			parent = *topic.ParentID
+		}
+		state += fmt.Sprintf("topic %d %s parent %d; ", topic.TopicID, topic.Topic, parent)
+	}
+
+	return state
+}
+
+// lastEntry returns the last entry of the audit log which is not caused by another one
+func lastEntry(t *testing.T) AuditEntry {
+	t.Helper()
+
+	var auditID uint
+	err := db.QueryRow("SELECT MAX(audit_id) FROM audit_log WHERE cause_id IS NULL").Scan(&auditID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := getAuditEntry(db, auditID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entry
+}
+
+func undoEntry(t *testing.T, entry AuditEntry) error {
+	t.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	undone, err := undoChange(tx, entry)
+	if err == nil {
+		err = undone.record(tx, 0, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func TestUndoTopicDeletion(t *testing.T) {
+	defer openTestDatabase(t)()
+	documentID, partyID, _ := auditFixture(t)
+	before := auditState(t, documentID)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteTopic(tx, partyID, 0)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := auditState(t, documentID)
+	if deleted == before {
+		t.Fatal("the topic was not deleted")
+	}
+
+	deletion := lastEntry(t)
+	caused, err := causedEntries(db, deletion.AuditID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities := []string{}
+	for _, entry := range caused {
+		entities = append(entities, fmt.Sprintf("%s %d %s", entry.Entity, entry.EntityID, entry.Action))
+	}
+	if want := []string{"topic 2 update", "annotation 1 delete", "annotation 2 delete"}; !reflect.DeepEqual(entities, want) {
+		t.Errorf("deletion caused %v, want %v", entities, want)
+	}
+
+	// Undoing the deletion restores the annotations, the relations deleted with them and the parent of the child topic,
+	// undoing the undo deletes them again, and so on
+	for i, want := range []string{before, deleted, before} {
+		err = undoEntry(t, lastEntry(t))
+		if err != nil {
+			t.Fatalf("undo %d: %v", i+1, err)
+		}
+
+		if state := auditState(t, documentID); state != want {
+			t.Errorf("after undo %d:\n%s\nwant:\n%s", i+1, state, want)
+		}
+	}
+}
+
+func TestUndoAnnotationDeletionRestoresRelations(t *testing.T) {
+	defer openTestDatabase(t)()
+	documentID, _, _ := auditFixture(t)
+	before := auditState(t, documentID)
+
+	annotation, err := getAnnotation(db, documentID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relations, err := queryRelations(db, documentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, "DELETE FROM annotations WHERE annotation_id = 2")
+	err = recordAnnotationDeletions(db, 0, documentID, []Annotation{annotation}, relations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = undoEntry(t, lastEntry(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state := auditState(t, documentID); state != before {
+		t.Errorf("after undo:\n%s\nwant:\n%s", state, before)
+	}
+}
+
+func TestUndoAnnotationCreationWithRelations(t *testing.T) {
+	defer openTestDatabase(t)()
+	documentID, _, _ := auditFixture(t)
+
+	var auditID uint
+	err := db.QueryRow("SELECT audit_id FROM audit_log WHERE entity = 'annotation' AND entity_id = 1 AND action = 'create'").Scan(&auditID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := getAuditEntry(db, auditID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = undoEntry(t, entry)
+	if !errors.Is(err, errInconsistentUndo) {
+		t.Errorf("undoing the creation of a related annotation returned %v, want a conflict", err)
+	}
+
+	if _, err = getAnnotation(db, documentID, 1); err != nil {
+		t.Errorf("the annotation was deleted: %v", err)
+	}
+}
+
+func TestDocumentDeletionRecordsAnnotations(t *testing.T) {
+	defer openTestDatabase(t)()
+	documentID, _, _ := auditFixture(t)
+
+	document, err := getDocumentSummary(db, documentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = recordDocumentDeletion(db, 0, document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, "DELETE FROM documents WHERE document_id = ?", documentID)
+
+	entries, err := queryAuditLog(db, DefaultProjectID, documentID, []string{EntityAnnotation, EntityRelation})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := 0
+	for _, entry := range entries {
+		if entry.Action == ActionDelete {
+			deleted++
+			if entry.CauseID == nil {
+				t.Errorf("%s %d deleted without a cause", entry.Entity, entry.EntityID)
+			}
+		}
+	}
+	if deleted != 5 {
+		t.Errorf("recorded %d deletions, want the 3 annotations and 2 relations", deleted)
+	}
+
+	err = undoEntry(t, lastEntry(t))
+	if !errors.Is(err, errInconsistentUndo) {
+		t.Errorf("undoing the deletion of a document returned %v, want a conflict", err)
+	}
+}
+
+func TestUndoTopicValueType(t *testing.T) {
+	defer openTestDatabase(t)()
+	documentID, partyID, _ := auditFixture(t)
+
+	annotationValue := func() string {
+		t.Helper()
+		annotation, err := getAnnotation(db, documentID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(annotation.Value)
+	}
+
+	w := serveAs(PatchAnnotationHandler, 0, http.MethodPatch, `{"notes": "main party"}`,
+		map[string]string{"documentId": fmt.Sprint(documentID), "annotationId": "1"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", w.Code, w.Body.String())
+	}
+	notes := lastEntry(t)
+
+	w = serveAs(PatchTopicHandler, 0, http.MethodPatch, `{"valueType": "enum", "enumValues": ["ACME"]}`,
+		map[string]string{"topicId": fmt.Sprint(partyID)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", w.Code, w.Body.String())
+	}
+	if value := annotationValue(); value != `"ACME"` {
+		t.Fatalf("annotation has value %s, want ACME", value)
+	}
+
+	// Parsing the annotations again is recorded as caused by the topic change
+	valueType := lastEntry(t)
+	caused, err := causedEntries(db, valueType.AuditID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities := []string{}
+	for _, entry := range caused {
+		entities = append(entities, fmt.Sprintf("%s %d %s", entry.Entity, entry.EntityID, entry.Action))
+	}
+	if want := []string{"annotation 1 update", "annotation 2 update"}; !reflect.DeepEqual(entities, want) {
+		t.Errorf("value type change caused %v, want %v", entities, want)
+	}
+
+	// The notes were changed before the value, they are undone once the value type change is
+	if err := undoEntry(t, notes); !errors.Is(err, errInconsistentUndo) {
+		t.Errorf("undid the notes before the value type change: %v", err)
+	}
+	if err := undoEntry(t, valueType); err != nil {
+		t.Fatal(err)
+	}
+	if value := annotationValue(); value != "" {
+		t.Errorf("annotation has value %s after the undo, want none", value)
+	}
+	valueTypeUndo := lastEntry(t)
+	if err := undoEntry(t, notes); err != nil {
+		t.Fatal(err)
+	}
+
+	// Undoing the undos in reverse order sets the notes and parses the annotations again
+	if err := undoEntry(t, lastEntry(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := undoEntry(t, valueTypeUndo); err != nil {
+		t.Fatal(err)
+	}
+	if value := annotationValue(); value != `"ACME"` {
+		t.Errorf("annotation has value %s after undoing the undo, want ACME", value)
+	}
+}
+
+func TestUndoTopicMergeValues(t *testing.T) {
+	defer openTestDatabase(t)()
+	documentID, partyID, _ := auditFixture(t)
+
+	// The annotation of the target was made before its value type changed
+	var dateID uint
+	err := db.QueryRow("SELECT topic_id FROM topics WHERE topic = 'Date'").Scan(&dateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, `UPDATE topics SET value_type = 'enum', enum_values = '["ACME", "Beta"]' WHERE topic_id = ?`, dateID)
+
+	values := func() string {
+		t.Helper()
+		annotations, err := queryAnnotations(db, AnnotationFilter{DocumentID: documentID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := ""
+		for _, a := range annotations {
+			state += fmt.Sprintf("annotation %d %s %s %q; ", a.AnnotationID, a.Topic, a.Value, a.ValueError)
+		}
+		return state
+	}
+	before := values()
+
+	w := serveAs(MergeTopicHandler, 0, http.MethodPost, fmt.Sprintf(`{"intoTopicId": %d}`, dateID),
+		map[string]string{"topicId": fmt.Sprint(partyID)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", w.Code, w.Body.String())
+	}
+	merged := values()
+	if merged == before {
+		t.Fatal("the merge changed no value")
+	}
+
+	// Parsing the moved annotations and the annotation of the target again is recorded as caused by the merge
+	merge := lastEntry(t)
+	caused, err := causedEntries(db, merge.AuditID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities := []string{}
+	for _, entry := range caused {
+		entities = append(entities, fmt.Sprintf("%s %d %s", entry.Entity, entry.EntityID, entry.Action))
+	}
+	want := []string{"topic 2 update", "annotation 1 update", "annotation 2 update", "annotation 3 update"}
+	if !reflect.DeepEqual(entities, want) {
+		t.Errorf("merge caused %v, want %v", entities, want)
+	}
+
+	for i, want := range []string{before, merged, before} {
+		err = undoEntry(t, lastEntry(t))
+		if err != nil {
+			t.Fatalf("undo %d: %v", i+1, err)
+		}
+
+		if state := values(); state != want {
+			t.Errorf("after undo %d:\n%s\nwant:\n%s", i+1, state, want)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const sessionCookie = "spectator_session"
+const sessionDuration = 30 * 24 * time.Hour
+const minPasswordLength = 8
+
+type contextKey int
+
+const userContextKey contextKey = iota
+
+// publicRoutes are the path templates reachable without being logged in: the login route and the web application
+var publicRoutes = map[string]bool{
+	"/login":      true,
+	"/index.html": true,
+	"/":           true,
+}
+
+// currentUser returns the user who sent the request, set by authMiddleware
+func currentUser(r *http.Request) User {
+	user, _ := r.Context().Value(userContextKey).(User)
+	return user
+}
+
+// hashToken hashes a session token, only the hash is stored so a leaked database does not leak sessions
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func newToken() (string, error) {
+	token := make([]byte, 32)
+
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", fmt.Errorf("Unable to generate token: %w", err)
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+func getUser(q querier, userID uint) (User, error) {
+	var user User
+
+	err := q.QueryRow("SELECT user_id, username FROM users WHERE user_id = ?", userID).Scan(&user.UserID, &user.Username)
+	return user, err
+}
+
+// sessionUser returns the user of an unexpired session
+func sessionUser(q querier, token string) (User, error) {
+	var user User
+
+	err := q.QueryRow(`SELECT u.user_id, u.username FROM sessions s
+								INNER JOIN users u ON u.user_id = s.user_id
+								WHERE s.token_hash = ? AND s.expires_at > ?`, hashToken(token), time.Now().UTC()).
+		Scan(&user.UserID, &user.Username)
+	return user, err
+}
+
+func countUsers(q querier) (int, error) {
+	var count int
+
+	err := q.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to count users: %w", err)
+	}
+
+	return count, nil
+}
+
+var errUnauthenticated = errors.New("Authentication required")
+
+// authenticate returns the user of the API token or of the session cookie of a request
+func authenticate(r *http.Request) (User, error) {
+	if token := bearerToken(r); token != "" {
+		return tokenUser(db, token)
+	}
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return User{}, errUnauthenticated
+	}
+
+	return sessionUser(db, cookie.Value)
+}
+
+// authMiddleware rejects the requests without a valid API token or session cookie, except for the public routes.
+// The first account can be created without being logged in, to bootstrap a new server.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			template, _ := route.GetPathTemplate()
+			if publicRoutes[template] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if template == "/users" && r.Method == http.MethodPost {
+				count, err := countUsers(db)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				if count == 0 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		user, err := authenticate(r)
+		if err == errUnauthenticated || err == sql.ErrNoRows {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if user.Scope == ScopeRead && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			forbidden(w, "read-only tokens can only be used for GET requests")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	})
+}
+
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var credentials Credentials
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	var passwordHash string
+	err = db.QueryRow("SELECT user_id, username, password_hash FROM users WHERE username = ?", credentials.Username).
+		Scan(&user.UserID, &user.Username, &passwordHash)
+
+	// The same error for an unknown user and a wrong password, not to disclose which accounts exist
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(credentials.Password))
+	}
+	if err != nil {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	expires := now.Add(sessionDuration)
+
+	_, err = db.Exec("DELETE FROM sessions WHERE expires_at <= ?", now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = db.Exec("INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)", hashToken(token), user.UserID, expires)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err == nil {
+		_, err = db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(cookie.Value))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetMeHandler returns the logged in user with its roles
+func GetMeHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	roles, err := queryRoleAssignments(db, user.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user.Roles = roles
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func queryUsers(q querier) (Users, error) {
+	rows, err := q.Query("SELECT user_id, username FROM users ORDER BY user_id")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := Users{}
+
+	for rows.Next() {
+		var user User
+
+		err = rows.Scan(&user.UserID, &user.Username)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read user: %w", err)
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func GetUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := queryUsers(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(users)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func PostUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var credentials Credentials
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if credentials.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	if len(credentials.Password) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("Password must be at least %d characters long", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(credentials.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	count, err := countUsers(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Only admins create accounts, once the first one exists
+	if count > 0 {
+		role, err := userRole(tx, currentUser(r), 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !hasRole(role, RoleAdmin) {
+			forbidden(w, "only admins can create accounts")
+			return
+		}
+	}
+
+	res, err := tx.Exec("INSERT INTO users (username, password_hash, created_at) VALUES (?, ?, ?)",
+		credentials.Username, string(passwordHash), time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, _ := res.LastInsertId()
+
+	// The first account administers the server
+	if count == 0 {
+		_, err = tx.Exec("INSERT INTO role_assignments (user_id, role) VALUES (?, ?)", id, RoleAdmin)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	user, err := getUser(tx, uint(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
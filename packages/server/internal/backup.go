@@ -0,0 +1,1014 @@
+package internal
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Version of the backup archive format, restores refuse other versions
+const backupVersion = 1
+
+// backupManifest is the file of the archive describing its content, the others being the binaries it refers to
+const backupManifest = "backup.json"
+
+// BackupUser is an account referred to by a backup, restored when no account has its username
+type BackupUser struct {
+	UserID       uint      `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Roles        []string  `json:"roles"` // roles in all projects
+}
+
+// BackupPage is a page of a backed up document, its image being a file of the archive
+type BackupPage struct {
+	Page        uint            `json:"page"`
+	Width       uint            `json:"width"`
+	Height      uint            `json:"height"`
+	Image       string          `json:"image"`
+	ImageFormat string          `json:"imageFormat"`
+	Tokens      json.RawMessage `json:"tokens"`
+}
+
+// BackupDocument is a document with everything attached to it, its IDs being those of the backed up server
+type BackupDocument struct {
+	DocumentID  uint                `json:"id"`
+	Name        string              `json:"name"`
+	Pages       *uint               `json:"pages"`
+	Text        *string             `json:"text"`
+	Processed   bool                `json:"processed"`
+	Status      string              `json:"status"`
+	Original    string              `json:"original"` // file of the archive with the uploaded file, empty when it was not kept
+	PageFiles   []BackupPage        `json:"pageFiles"`
+	Annotations []Annotation        `json:"annotations"`
+	Relations   []Relation          `json:"relations"`
+	Transitions DocumentTransitions `json:"transitions"`
+	Tasks       Tasks               `json:"tasks"`
+}
+
+// BackupProject is a project with its settings, roles, topics, relation types and documents
+type BackupProject struct {
+	ProjectID     uint             `json:"id"`
+	Name          string           `json:"name"`
+	Settings      Attributes       `json:"settings"`
+	Roles         RoleAssignments  `json:"roles"`
+	Topics        Topics           `json:"topics"`
+	RelationTypes RelationTypes    `json:"relationTypes"`
+	Documents     []BackupDocument `json:"documents"`
+}
+
+// BackupArchive is the content of backup.json
+type BackupArchive struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Users     []BackupUser    `json:"users"`
+	Projects  []BackupProject `json:"projects"`
+}
+
+// RestoredProject tells where a project of an archive was restored, with the new IDs of its documents
+type RestoredProject struct {
+	ProjectID     uint          `json:"projectId"`
+	Name          string        `json:"name"`
+	Created       bool          `json:"created"`
+	CreatedTopics []string      `json:"createdTopics"`
+	DocumentIDs   map[uint]uint `json:"documentIds"` // by ID in the archive
+	Annotations   int           `json:"annotations"`
+	Relations     int           `json:"relations"`
+}
+
+// RestoreReport is the result of a restore
+type RestoreReport struct {
+	CreatedUsers []string          `json:"createdUsers"`
+	Projects     []RestoredProject `json:"projects"`
+}
+
+// backupProjectIDs returns the project to back up, or all of them when projectID is 0
+func backupProjectIDs(q querier, projectID uint) ([]uint, error) {
+	if projectID != 0 {
+		_, err := getProject(q, projectID)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read project %d: %w", projectID, err)
+		}
+		return []uint{projectID}, nil
+	}
+
+	rows, err := q.Query("SELECT project_id FROM projects ORDER BY project_id")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query projects: %w", err)
+	}
+	defer rows.Close()
+
+	projectIDs := []uint{}
+	for rows.Next() {
+		var id uint
+
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read project: %w", err)
+		}
+
+		projectIDs = append(projectIDs, id)
+	}
+
+	return projectIDs, rows.Err()
+}
+
+// createZipFile adds a file to an archive
+func createZipFile(archive *zip.Writer, name string, content []byte) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(content)
+	return err
+}
+
+// backupPages writes the images of the pages of a document to the archive
+func backupPages(q querier, archive *zip.Writer, documentID uint) ([]BackupPage, error) {
+	rows, err := q.Query("SELECT page, width, height, image, image_format, tokens FROM document_pages WHERE document_id = ? ORDER BY page", documentID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query pages: %w", err)
+	}
+	defer rows.Close()
+
+	pages := []BackupPage{}
+	for rows.Next() {
+		var page BackupPage
+		var image, tokens []byte
+
+		err = rows.Scan(&page.Page, &page.Width, &page.Height, &image, &page.ImageFormat, &tokens)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read page: %w", err)
+		}
+
+		page.Image = fmt.Sprintf("documents/%d/page-%d.%s", documentID, page.Page, page.ImageFormat)
+		page.Tokens = tokens
+		err = createZipFile(archive, page.Image, image)
+		if err != nil {
+			return nil, err
+		}
+
+		pages = append(pages, page)
+	}
+
+	return pages, rows.Err()
+}
+
+// backupTasks returns the tasks of a document
+func backupTasks(q querier, documentID uint) (Tasks, error) {
+	rows, err := q.Query(`SELECT `+taskColumns+` FROM tasks t
+									INNER JOIN documents d ON d.document_id = t.document_id
+									WHERE t.document_id = ?
+									ORDER BY t.task_id`, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := Tasks{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read task: %w", err)
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, rows.Err()
+}
+
+// backupDocument writes the original file and the page images of a document to the archive
+func backupDocument(q querier, archive *zip.Writer, document BackupDocument) (BackupDocument, error) {
+	var pages sql.NullInt64
+	var text sql.NullString
+	var original []byte
+
+	err := q.QueryRow("SELECT pages, text, original FROM documents WHERE document_id = ?", document.DocumentID).Scan(&pages, &text, &original)
+	if err != nil {
+		return document, fmt.Errorf("Unable to read document %d: %w", document.DocumentID, err)
+	}
+
+	if pages.Valid {
+		count := uint(pages.Int64)
+		document.Pages = &count
+	}
+	if text.Valid {
+		document.Text = &text.String
+	}
+
+	if original != nil {
+		document.Original = fmt.Sprintf("documents/%d/original%s", document.DocumentID, path.Ext(document.Name))
+		err = createZipFile(archive, document.Original, original)
+		if err != nil {
+			return document, err
+		}
+	}
+
+	document.PageFiles, err = backupPages(q, archive, document.DocumentID)
+	if err != nil {
+		return document, err
+	}
+
+	document.Annotations, err = queryAnnotations(q, AnnotationFilter{DocumentID: document.DocumentID})
+	if err != nil {
+		return document, err
+	}
+
+	document.Relations, err = queryRelations(q, document.DocumentID)
+	if err != nil {
+		return document, err
+	}
+
+	document.Transitions, err = queryDocumentTransitions(q, document.DocumentID)
+	if err != nil {
+		return document, err
+	}
+
+	document.Tasks, err = backupTasks(q, document.DocumentID)
+	return document, err
+}
+
+// backupProject writes the files of the documents of a project to the archive
+func backupProject(q querier, archive *zip.Writer, projectID uint, assignments RoleAssignments) (BackupProject, error) {
+	project, err := getProject(q, projectID)
+	if err != nil {
+		return BackupProject{}, fmt.Errorf("Unable to read project %d: %w", projectID, err)
+	}
+
+	backup := BackupProject{ProjectID: project.ProjectID, Name: project.Name, Settings: project.Settings, Roles: RoleAssignments{},
+		Documents: []BackupDocument{}}
+	for _, assignment := range assignments {
+		if assignment.ProjectID != nil && *assignment.ProjectID == projectID {
+			backup.Roles = append(backup.Roles, assignment)
+		}
+	}
+
+	backup.Topics, err = queryTopics(q, projectID)
+	if err != nil {
+		return backup, err
+	}
+
+	backup.RelationTypes, err = queryRelationTypes(q, projectID)
+	if err != nil {
+		return backup, err
+	}
+
+	rows, err := q.Query("SELECT document_id, name, processed, status FROM documents WHERE project_id = ? ORDER BY document_id", projectID)
+	if err != nil {
+		return backup, fmt.Errorf("Unable to query documents: %w", err)
+	}
+
+	documents := []BackupDocument{}
+	for rows.Next() {
+		var document BackupDocument
+
+		err = rows.Scan(&document.DocumentID, &document.Name, &document.Processed, &document.Status)
+		if err != nil {
+			rows.Close()
+			return backup, fmt.Errorf("Unable to read document: %w", err)
+		}
+
+		documents = append(documents, document)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return backup, err
+	}
+
+	for _, document := range documents {
+		document, err = backupDocument(q, archive, document)
+		if err != nil {
+			return backup, err
+		}
+
+		backup.Documents = append(backup.Documents, document)
+	}
+
+	return backup, nil
+}
+
+// backupUsers returns the accounts referred to by the backed up projects, and those with a role in all projects
+func backupUsers(q querier, projects []BackupProject, assignments RoleAssignments) ([]BackupUser, error) {
+	referred := map[uint]bool{}
+	refer := func(userID *uint) {
+		if userID != nil {
+			referred[*userID] = true
+		}
+	}
+
+	global := map[uint][]string{}
+	for _, assignment := range assignments {
+		if assignment.ProjectID == nil {
+			referred[assignment.UserID] = true
+			global[assignment.UserID] = append(global[assignment.UserID], assignment.Role)
+		}
+	}
+
+	for _, project := range projects {
+		for _, assignment := range project.Roles {
+			referred[assignment.UserID] = true
+		}
+
+		for _, document := range project.Documents {
+			for _, annotation := range document.Annotations {
+				refer(annotation.UserID)
+			}
+			for _, transition := range document.Transitions {
+				refer(transition.UserID)
+			}
+			for _, task := range document.Tasks {
+				referred[task.UserID] = true
+				refer(task.AssignedBy)
+			}
+		}
+	}
+
+	rows, err := q.Query("SELECT user_id, username, password_hash, created_at FROM users ORDER BY user_id")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []BackupUser{}
+	for rows.Next() {
+		var user BackupUser
+
+		err = rows.Scan(&user.UserID, &user.Username, &user.PasswordHash, &user.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read user: %w", err)
+		}
+
+		if referred[user.UserID] {
+			user.Roles = global[user.UserID]
+			if user.Roles == nil {
+				user.Roles = []string{}
+			}
+			users = append(users, user)
+		}
+	}
+
+	return users, rows.Err()
+}
+
+// writeBackup writes an archive of a project, or of all projects when projectID is 0. The querier should be a
+// transaction, for the archive to be a consistent snapshot of the database.
+func writeBackup(q querier, archive *zip.Writer, projectID uint) error {
+	projectIDs, err := backupProjectIDs(q, projectID)
+	if err != nil {
+		return err
+	}
+
+	assignments, err := queryRoleAssignments(q, 0)
+	if err != nil {
+		return err
+	}
+
+	backup := BackupArchive{Version: backupVersion, CreatedAt: time.Now().UTC(), Projects: []BackupProject{}}
+	for _, id := range projectIDs {
+		project, err := backupProject(q, archive, id, assignments)
+		if err != nil {
+			return err
+		}
+
+		backup.Projects = append(backup.Projects, project)
+	}
+
+	backup.Users, err = backupUsers(q, backup.Projects, assignments)
+	if err != nil {
+		return err
+	}
+
+	file, err := archive.Create(backupManifest)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(file).Encode(backup)
+}
+
+// Backup writes an archive of a project, or of all projects when projectID is 0, to a file. It reads the database in
+// a transaction, which does not block the writes of a running server as the database is in WAL mode.
+func Backup(filePath string, projectID uint) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Cannot make transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("Unable to create the archive: %w", err)
+	}
+
+	archive := zip.NewWriter(file)
+	err = writeBackup(tx, archive, projectID)
+	if err == nil {
+		err = archive.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return fmt.Errorf("Unable to write the archive: %w", err)
+	}
+
+	return nil
+}
+
+// readZipFile returns the content of a file of an archive
+func readZipFile(files map[string]*zip.File, name string) ([]byte, error) {
+	file, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("The archive has no %s", name)
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+// restorer maps the IDs of an archive to those of the server it is restored to
+type restorer struct {
+	tx      *sql.Tx
+	files   map[string]*zip.File
+	userID  uint
+	users   map[uint]uint
+	report  RestoreReport
+	project *RestoredProject
+}
+
+// nullID stores an ID of 0 as NULL
+func nullID(id uint) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// user returns the ID on the server of a user of the archive, 0 for nobody
+func (r *restorer) user(userID *uint) (uint, error) {
+	if userID == nil {
+		return 0, nil
+	}
+
+	id, ok := r.users[*userID]
+	if !ok {
+		return 0, fmt.Errorf("Unknown user %d in the archive", *userID)
+	}
+
+	return id, nil
+}
+
+// restoreUsers maps the users of the archive to the accounts with the same username, creating the missing ones with
+// their password and roles in all projects
+func (r *restorer) restoreUsers(users []BackupUser) error {
+	existing, err := queryUsers(r.tx)
+	if err != nil {
+		return err
+	}
+
+	byName := map[string]uint{}
+	for _, user := range existing {
+		byName[user.Username] = user.UserID
+	}
+
+	for _, user := range users {
+		if id, ok := byName[user.Username]; ok {
+			r.users[user.UserID] = id
+			continue
+		}
+
+		res, err := r.tx.Exec("INSERT INTO users (username, password_hash, created_at) VALUES (?, ?, ?)", user.Username, user.PasswordHash, user.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("Unable to create user %q: %w", user.Username, err)
+		}
+
+		id, _ := res.LastInsertId()
+		r.users[user.UserID] = uint(id)
+		byName[user.Username] = uint(id)
+		r.report.CreatedUsers = append(r.report.CreatedUsers, user.Username)
+
+		for _, role := range user.Roles {
+			_, err = r.tx.Exec("INSERT INTO role_assignments (user_id, role) VALUES (?, ?)", id, role)
+			if err != nil {
+				return fmt.Errorf("Unable to assign role %q to user %q: %w", role, user.Username, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// restoreTopics maps the topics of the archive to those of the project with the same name, creating the missing ones
+// under their parent
+func (r *restorer) restoreTopics(projectID uint, topics Topics) (map[uint]uint, error) {
+	existing, err := queryTopics(r.tx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	byName, shortcuts := map[string]uint{}, map[string]bool{}
+	for _, topic := range existing {
+		byName[topic.Topic] = topic.TopicID
+		shortcuts[topic.Shortcut] = true
+	}
+
+	archived := map[uint]Topic{}
+	for _, topic := range topics {
+		archived[topic.TopicID] = topic
+	}
+
+	topicIDs, visiting := map[uint]uint{}, map[uint]bool{}
+	var restore func(topic Topic) (uint, error)
+	restore = func(topic Topic) (uint, error) {
+		if id, ok := topicIDs[topic.TopicID]; ok {
+			return id, nil
+		}
+		if id, ok := byName[topic.Topic]; ok {
+			topicIDs[topic.TopicID] = id
+			return id, nil
+		}
+		visiting[topic.TopicID] = true
+
+		topic.ProjectID, topic.Children = projectID, nil
+		parentID := topic.ParentID
+		topic.ParentID = nil
+		if parentID != nil {
+			if parent, ok := archived[*parentID]; ok && !visiting[parent.TopicID] {
+				id, err := restore(parent)
+				if err != nil {
+					return 0, err
+				}
+				topic.ParentID = &id
+			}
+		}
+
+		// Another topic of the project may already use the shortcut
+		if shortcuts[topic.Shortcut] {
+			topic.Shortcut = ""
+		}
+
+		created, err := insertTopic(r.tx, topic, r.userID)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to restore topic %q: %w", topic.Topic, err)
+		}
+
+		topicIDs[topic.TopicID], byName[topic.Topic] = created.TopicID, created.TopicID
+		if topic.Shortcut != "" {
+			shortcuts[topic.Shortcut] = true
+		}
+		r.project.CreatedTopics = append(r.project.CreatedTopics, created.Topic)
+		return created.TopicID, nil
+	}
+
+	for _, topic := range topics {
+		_, err = restore(topic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return topicIDs, nil
+}
+
+// restoreRelationTypes maps the relation types of the archive to those of the project with the same name, creating
+// the missing ones
+func (r *restorer) restoreRelationTypes(projectID uint, relationTypes RelationTypes) (map[uint]uint, error) {
+	existing, err := queryRelationTypes(r.tx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]uint{}
+	for _, relationType := range existing {
+		byName[relationType.RelationType] = relationType.RelationTypeID
+	}
+
+	relationTypeIDs := map[uint]uint{}
+	for _, relationType := range relationTypes {
+		id, ok := byName[relationType.RelationType]
+		if !ok {
+			res, err := r.tx.Exec("INSERT INTO relation_types (project_id, relation_type) VALUES (?, ?)", projectID, relationType.RelationType)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to restore relation type %q: %w", relationType.RelationType, err)
+			}
+
+			created, _ := res.LastInsertId()
+			id = uint(created)
+			byName[relationType.RelationType] = id
+		}
+
+		relationTypeIDs[relationType.RelationTypeID] = id
+	}
+
+	return relationTypeIDs, nil
+}
+
+// restoreDocument adds a document of the archive to a project, with new IDs for all it holds
+func (r *restorer) restoreDocument(projectID uint, document BackupDocument, topicIDs, relationTypeIDs map[uint]uint) (uint, error) {
+	var original []byte
+	if document.Original != "" {
+		var err error
+		original, err = readZipFile(r.files, document.Original)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := r.tx.Exec("INSERT INTO documents (project_id, name, pages, text, processed, status, original) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		projectID, document.Name, document.Pages, document.Text, document.Processed, document.Status, original)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to restore document %q: %w", document.Name, err)
+	}
+
+	id, _ := res.LastInsertId()
+	documentID := uint(id)
+
+	summary, err := getDocumentSummary(r.tx, documentID)
+	if err == nil {
+		err = recordDocumentChange(r.tx, r.userID, nil, &summary)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, page := range document.PageFiles {
+		image, err := readZipFile(r.files, page.Image)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = r.tx.Exec("INSERT INTO document_pages (document_id, page, height, width, image, image_format, tokens) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			documentID, page.Page, page.Height, page.Width, image, page.ImageFormat, []byte(page.Tokens))
+		if err != nil {
+			return 0, fmt.Errorf("Unable to restore page %d of document %q: %w", page.Page, document.Name, err)
+		}
+	}
+
+	// Gold annotations are restored after the older annotations they were copied from
+	annotations := append([]Annotation{}, document.Annotations...)
+	sort.Slice(annotations, func(i, j int) bool { return annotations[i].AnnotationID < annotations[j].AnnotationID })
+
+	annotationIDs := map[uint]uint{}
+	for _, annotation := range annotations {
+		topicID, ok := topicIDs[annotation.TopicID]
+		if !ok {
+			return 0, fmt.Errorf("Unknown topic %d of annotation %d in the archive", annotation.TopicID, annotation.AnnotationID)
+		}
+		annotation.TopicID = topicID
+
+		userID, err := r.user(annotation.UserID)
+		if err != nil {
+			return 0, err
+		}
+		annotation.UserID = nil
+		if userID != 0 {
+			annotation.UserID = &userID
+		}
+
+		if annotation.SourceAnnotationID != nil {
+			sourceID, ok := annotationIDs[*annotation.SourceAnnotationID]
+			annotation.SourceAnnotationID = nil
+			if ok {
+				annotation.SourceAnnotationID = &sourceID
+			}
+		}
+
+		// The archived rows are inserted as they were, their text and value are not computed again
+		archived := annotation.AnnotationID
+		annotation.AnnotationID = 0
+		id, err := insertAnnotationRow(r.tx, documentID, annotation)
+		if err != nil {
+			return 0, err
+		}
+		annotationIDs[archived] = id
+
+		created, err := getAnnotation(r.tx, documentID, id)
+		if err == nil {
+			err = recordAnnotationChange(r.tx, r.userID, documentID, nil, &created)
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.project.Annotations++
+	}
+
+	for _, relation := range document.Relations {
+		archived := relation.RelationID
+		relation.RelationTypeID = relationTypeIDs[relation.RelationTypeID]
+		relation.FromAnnotationID = annotationIDs[relation.FromAnnotationID]
+		relation.ToAnnotationID = annotationIDs[relation.ToAnnotationID]
+
+		userID, err := r.user(relation.UserID)
+		if err != nil {
+			return 0, err
+		}
+		relation.UserID = nil
+		if userID != 0 {
+			relation.UserID = &userID
+		}
+
+		err = checkRelationEnds(r.tx, documentID, relation)
+		if err == nil {
+			_, err = insertRelation(r.tx, documentID, relation, r.userID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Unable to restore relation %d: %w", archived, err)
+		}
+		r.project.Relations++
+	}
+
+	for _, transition := range document.Transitions {
+		userID, err := r.user(transition.UserID)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = r.tx.Exec("INSERT INTO document_transitions (document_id, from_status, to_status, user_id, comment, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			documentID, transition.FromStatus, transition.ToStatus, nullID(userID), transition.Comment, transition.CreatedAt)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to restore transition %d: %w", transition.DocumentTransitionID, err)
+		}
+	}
+
+	for _, task := range document.Tasks {
+		userID, err := r.user(&task.UserID)
+		if err != nil {
+			return 0, err
+		}
+
+		assignedBy, err := r.user(task.AssignedBy)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = r.tx.Exec(`INSERT INTO tasks (document_id, user_id, status, assigned_by, created_at, claimed_at, expires_at, completed_at)
+								VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			documentID, userID, task.Status, nullID(assignedBy), task.CreatedAt, task.ClaimedAt, task.ExpiresAt, task.CompletedAt)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to restore task %d: %w", task.TaskID, err)
+		}
+	}
+
+	return documentID, nil
+}
+
+// restoreProject restores a project of the archive into the given project, or into the project with the same name
+// when projectID is 0, creating it if needed
+func (r *restorer) restoreProject(project BackupProject, projectID uint) error {
+	restored := RestoredProject{ProjectID: projectID, CreatedTopics: []string{}, DocumentIDs: map[uint]uint{}}
+	r.project = &restored
+
+	if projectID == 0 {
+		err := r.tx.QueryRow("SELECT project_id FROM projects WHERE name = ?", project.Name).Scan(&restored.ProjectID)
+		if err == sql.ErrNoRows {
+			settings, err := json.Marshal(project.Settings)
+			if err != nil {
+				return err
+			}
+
+			res, err := r.tx.Exec("INSERT INTO projects (name, settings) VALUES (?, ?)", project.Name, settings)
+			if err != nil {
+				return fmt.Errorf("Unable to create project %q: %w", project.Name, err)
+			}
+
+			id, _ := res.LastInsertId()
+			restored.ProjectID, restored.Created = uint(id), true
+		} else if err != nil {
+			return fmt.Errorf("Unable to read project %q: %w", project.Name, err)
+		}
+	}
+
+	existing, err := getProject(r.tx, restored.ProjectID)
+	if err != nil {
+		return fmt.Errorf("Unable to read project %d: %w", restored.ProjectID, err)
+	}
+	restored.Name = existing.Name
+
+	for _, assignment := range project.Roles {
+		userID, err := r.user(&assignment.UserID)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.tx.Exec(`INSERT INTO role_assignments (user_id, project_id, role)
+								SELECT ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM role_assignments WHERE user_id = ? AND project_id = ? AND role = ?)`,
+			userID, restored.ProjectID, assignment.Role, userID, restored.ProjectID, assignment.Role)
+		if err != nil {
+			return fmt.Errorf("Unable to restore role %q: %w", assignment.Role, err)
+		}
+	}
+
+	topicIDs, err := r.restoreTopics(restored.ProjectID, project.Topics)
+	if err != nil {
+		return err
+	}
+
+	relationTypeIDs, err := r.restoreRelationTypes(restored.ProjectID, project.RelationTypes)
+	if err != nil {
+		return err
+	}
+
+	for _, document := range project.Documents {
+		restored.DocumentIDs[document.DocumentID], err = r.restoreDocument(restored.ProjectID, document, topicIDs, relationTypeIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.report.Projects = append(r.report.Projects, restored)
+	return nil
+}
+
+// restoreBackup adds the content of an archive to the server with new IDs: each project goes into the project with the
+// same name, created if needed, or into the given project for an archive of a single project. Users are matched by
+// username, topics and relation types by name.
+func restoreBackup(tx *sql.Tx, archive *zip.Reader, projectID, userID uint) (RestoreReport, error) {
+	r := restorer{tx: tx, files: map[string]*zip.File{}, userID: userID, users: map[uint]uint{},
+		report: RestoreReport{CreatedUsers: []string{}, Projects: []RestoredProject{}}}
+
+	for _, file := range archive.File {
+		r.files[file.Name] = file
+	}
+
+	manifest, err := readZipFile(r.files, backupManifest)
+	if err != nil {
+		return r.report, err
+	}
+
+	var backup BackupArchive
+	err = json.Unmarshal(manifest, &backup)
+	if err != nil {
+		return r.report, fmt.Errorf("Unable to read %s: %w", backupManifest, err)
+	}
+
+	if backup.Version != backupVersion {
+		return r.report, fmt.Errorf("Unsupported backup version %d, expected %d", backup.Version, backupVersion)
+	}
+
+	if projectID != 0 && len(backup.Projects) != 1 {
+		return r.report, fmt.Errorf("The archive holds %d projects, only an archive of a single project can be restored into a project", len(backup.Projects))
+	}
+
+	err = r.restoreUsers(backup.Users)
+	if err != nil {
+		return r.report, err
+	}
+
+	for _, project := range backup.Projects {
+		err = r.restoreProject(project, projectID)
+		if err != nil {
+			return r.report, err
+		}
+	}
+
+	return r.report, nil
+}
+
+// Restore adds the content of an archive file to the database, into the given project or into the projects with the
+// names of the archive when projectID is 0. Nothing is restored when it fails.
+func Restore(filePath string, projectID uint) (RestoreReport, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return RestoreReport{}, fmt.Errorf("Unable to open the archive: %w", err)
+	}
+	defer archive.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return RestoreReport{}, fmt.Errorf("Cannot make transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	report, err := restoreBackup(tx, &archive.Reader, projectID, 0)
+	if err != nil {
+		return report, err
+	}
+
+	return report, tx.Commit()
+}
+
+// GetBackupHandler sends an archive of a project, or of all projects from /backup, read in a transaction for the
+// archive to be consistent while the server keeps running, the database being in WAL mode
+func GetBackupHandler(w http.ResponseWriter, r *http.Request) {
+	var id uint
+	if _, ok := mux.Vars(r)["projectId"]; ok {
+		id = projectID(r)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = backupProjectIDs(tx, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	name := fmt.Sprintf("spectator-backup-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	if id != 0 {
+		name = fmt.Sprintf("project-%d-backup.zip", id)
+	}
+
+	err = zipAttachment(w, name, func(archive *zip.Writer) error {
+		return writeBackup(tx, archive, id)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// PostRestoreHandler restores the archive of the body, into the projects with the names of the archive from /restore,
+// or into the project of the route
+func PostRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	var id uint
+	if _, ok := mux.Vars(r)["projectId"]; ok {
+		id = projectID(r)
+
+		_, err := getProject(db, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The archive is read from the end, it has to be stored first
+	file, err := ioutil.TempFile("", "spectator-restore-*.zip")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	size, err := io.Copy(file, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid zip: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	report, err := restoreBackup(tx, archive, id, currentUser(r).UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, project := range report.Projects {
+		if len(project.CreatedTopics) > 0 {
+			Broadcast(project.ProjectID, `{"type":"topicsChanged"}`)
+		}
+		if len(project.DocumentIDs) > 0 {
+			Broadcast(project.ProjectID, `{"type":"documentsChanged"}`)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// backupFixture fills a database with a document holding every kind of data of a backup, including an annotation
+// whose text and position can no longer be computed from its span
+func backupFixture(t *testing.T) {
+	admin := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('admin', 'hash-admin', '2020-01-01')")
+	ann := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('ann', 'hash-ann', '2020-01-02')")
+	mustExec(t, "INSERT INTO role_assignments (user_id, role) VALUES (?, 'admin')", admin)
+	mustExec(t, "INSERT INTO role_assignments (user_id, project_id, role) VALUES (?, 1, 'annotator')", ann)
+
+	party := mustExec(t, "INSERT INTO topics (topic, color) VALUES ('Party', '#ff0000')")
+	buyer := mustExec(t, "INSERT INTO topics (topic, parent_topic_id, shortcut) VALUES ('Buyer', ?, 'b')", party)
+	amount := mustExec(t, "INSERT INTO topics (topic, value_type) VALUES ('Amount', 'money')")
+	signs := mustExec(t, "INSERT INTO relation_types (relation_type) VALUES ('pays')")
+
+	documentID := insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "Corp", "pays", "$1,200.50", "USD"})
+
+	insert := `INSERT INTO annotations (document_id, character_start, character_end, page_start, page_end, text, top_px, left_px, topic_id,
+						status, source, confidence, notes, attributes, value, value_error, user_id, gold)
+						VALUES (?, ?, ?, 1, 1, ?, 10, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	from := mustExec(t, insert, documentID, 0, 9, "Acme Corp", 10, party, "accepted", "human", nil, "main party", `{"verified":true}`, nil, "", ann, false)
+	gold := mustExec(t, insert, documentID, 0, 9, "Acme Corp", 10, buyer, "accepted", "human", nil, "", "{}", nil, "", admin, true)
+	mustExec(t, "UPDATE annotations SET source_annotation_id = ? WHERE annotation_id = ?", from, gold)
+	to := mustExec(t, insert, documentID, 15, 24, "$1,200.50", 160, amount, "suggested", "model", 0.75, "", "{}",
+		`{"amount":1200.5,"currency":"USD"}`, "", nil, false)
+	// The space after the last word is covered by no token, and the text was recorded before the document changed
+	mustExec(t, insert, documentID, 28, 29, "stale", 0, amount, "rejected", "rule", nil, "", "{}", nil, "Unable to parse", ann, false)
+
+	mustExec(t, "INSERT INTO relations (document_id, relation_type_id, from_annotation_id, to_annotation_id) VALUES (?, ?, ?, ?)", documentID, signs, from, to)
+	mustExec(t, "INSERT INTO document_transitions (document_id, from_status, to_status, user_id, comment, created_at) VALUES (?, 'new', 'inProgress', ?, 'start', '2020-02-01')",
+		documentID, ann)
+	mustExec(t, "INSERT INTO tasks (document_id, user_id, status, assigned_by, created_at) VALUES (?, ?, 'assigned', ?, '2020-02-01')", documentID, ann, admin)
+}
+
+// backupSnapshot describes the content of the default project without its IDs, to compare it across databases
+func backupSnapshot(t *testing.T) []string {
+	t.Helper()
+
+	users, err := queryUsers(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usernames := map[uint]string{}
+	for _, user := range users {
+		usernames[user.UserID] = user.Username
+	}
+	username := func(userID *uint) string {
+		if userID == nil {
+			return "-"
+		}
+		return usernames[*userID]
+	}
+
+	topics, err := queryTopics(db, DefaultProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topicNames := map[uint]string{}
+	for _, topic := range topics {
+		topicNames[topic.TopicID] = topic.Topic
+	}
+
+	snapshot := []string{}
+	for _, topic := range topics {
+		parent := "-"
+		if topic.ParentID != nil {
+			parent = topicNames[*topic.ParentID]
+		}
+		snapshot = append(snapshot, fmt.Sprintf("topic %s parent=%s color=%s shortcut=%s type=%s", topic.Topic, parent, topic.Color, topic.Shortcut, topic.ValueType))
+	}
+
+	rows, err := db.Query("SELECT document_id, name, text, original FROM documents WHERE project_id = ? ORDER BY document_id", DefaultProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type document struct {
+		id             uint
+		name, text     string
+		original       []byte
+		annotationText map[uint]string
+	}
+	documents := []document{}
+	for rows.Next() {
+		var d document
+		if err := rows.Scan(&d.id, &d.name, &d.text, &d.original); err != nil {
+			t.Fatal(err)
+		}
+		documents = append(documents, d)
+	}
+	rows.Close()
+
+	for _, d := range documents {
+		snapshot = append(snapshot, fmt.Sprintf("document %s text=%q original=%q", d.name, d.text, d.original))
+
+		var tokens string
+		err = db.QueryRow("SELECT tokens FROM document_pages WHERE document_id = ? AND page = 1", d.id).Scan(&tokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshot = append(snapshot, "tokens "+tokens)
+
+		annotations, err := queryAnnotations(db, AnnotationFilter{DocumentID: d.id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts := map[uint]string{}
+		for _, a := range annotations {
+			texts[a.AnnotationID] = a.Topic + ":" + a.Text
+		}
+		for _, a := range annotations {
+			source := "-"
+			if a.SourceAnnotationID != nil {
+				source = texts[*a.SourceAnnotationID]
+			}
+			confidence := "-"
+			if a.Confidence != nil {
+				confidence = fmt.Sprint(*a.Confidence)
+			}
+			snapshot = append(snapshot, fmt.Sprintf("annotation %d-%d pages=%d-%d at=%d,%d %s %q %s %s %s notes=%q attributes=%v value=%s error=%q user=%s gold=%v source=%s",
+				a.CharacterStart, a.CharacterEnd, a.PageStart, a.PageEnd, a.Top, a.Left, a.Topic, a.Text, a.Status, a.Source, confidence,
+				a.Notes, a.Attributes, a.Value, a.ValueError, username(a.UserID), a.Gold, source))
+		}
+
+		relations, err := queryRelations(db, d.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range relations {
+			snapshot = append(snapshot, fmt.Sprintf("relation %s %s -> %s", r.RelationType, texts[r.FromAnnotationID], texts[r.ToAnnotationID]))
+		}
+
+		transitions, err := queryDocumentTransitions(db, d.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tr := range transitions {
+			snapshot = append(snapshot, fmt.Sprintf("transition %s -> %s by %s %q", tr.FromStatus, tr.ToStatus, username(tr.UserID), tr.Comment))
+		}
+
+		tasks, err := backupTasks(db, d.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range tasks {
+			snapshot = append(snapshot, fmt.Sprintf("task %s for %s by %s", task.Status, username(&task.UserID), username(task.AssignedBy)))
+		}
+	}
+
+	return snapshot
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	closeSource := openTestDatabase(t)
+	backupFixture(t)
+	want := backupSnapshot(t)
+
+	var archive bytes.Buffer
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(&archive)
+	err = writeBackup(tx, writer, 0)
+	if err == nil {
+		err = writer.Close()
+	}
+	tx.Rollback()
+	closeSource()
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+
+	// The target server already has data, so that every ID of the archive has to be remapped
+	defer openTestDatabase(t)()
+	mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('other', 'hash-other', '2019-01-01')")
+	mustExec(t, "INSERT INTO topics (topic) VALUES ('Date')")
+	other := insertTestDocument(t, 1, "other.pdf", []string{"Other"})
+	mustExec(t, "DELETE FROM topics WHERE topic = 'Date'")
+	mustExec(t, "DELETE FROM documents WHERE document_id = ?", other)
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := restoreBackup(tx, reader, 0, 0)
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf("restore: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report.CreatedUsers, []string{"admin", "ann"}) {
+		t.Errorf("created users %v, want admin and ann", report.CreatedUsers)
+	}
+	if len(report.Projects) != 1 || report.Projects[0].Created || report.Projects[0].DocumentIDs[1] != 2 {
+		t.Errorf("restored projects %+v, want document 1 restored as 2 in the default project", report.Projects)
+	}
+
+	got := backupSnapshot(t)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restored content differs\ngot:\n%s\nwant:\n%s", lines(got), lines(want))
+	}
+
+	var password, role string
+	err = db.QueryRow("SELECT u.password_hash, r.role FROM users u INNER JOIN role_assignments r ON r.user_id = u.user_id WHERE u.username = 'admin' AND r.project_id IS NULL").
+		Scan(&password, &role)
+	if err != nil || password != "hash-admin" || role != RoleAdmin {
+		t.Errorf("admin restored with password %q and role %q (%v), want its hash and the admin role", password, role, err)
+	}
+}
+
+func TestRestoreRefusesUnknownVersion(t *testing.T) {
+	defer openTestDatabase(t)()
+
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	if err := createZipFile(writer, backupManifest, []byte(`{"version": 99, "users": [], "projects": []}`)); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	_, err = restoreBackup(tx, reader, 0, 0)
+	if err == nil {
+		t.Error("restored an archive of version 99")
+	}
+}
+
+func lines(values []string) string {
+	var b bytes.Buffer
+	for _, value := range values {
+		b.WriteString("  " + value + "\n")
+	}
+	return b.String()
+}
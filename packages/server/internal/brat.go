@@ -0,0 +1,382 @@
+package internal
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// bratText makes a text fit on a line of a .ann file
+func bratText(text string) string {
+	return strings.NewReplacer("\n", " ", "\r", " ", "\t", " ").Replace(text)
+}
+
+// bratAttributeValue formats the value of an attribute for an A line, empty for a true boolean, false when BRAT cannot
+// represent it
+func bratAttributeValue(name string, value interface{}) (string, bool) {
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return "", false
+	}
+
+	var formatted string
+	switch v := value.(type) {
+	case bool:
+		return "", v
+	case string:
+		formatted = v
+	case float64:
+		formatted = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return "", false
+	}
+
+	return formatted, formatted != "" && !strings.ContainsAny(formatted, " \t\n|")
+}
+
+// writeBratAnn writes the annotations of a document as a BRAT .ann file, with character offsets. declared collects the
+// attributes and their values for annotation.conf.
+func writeBratAnn(w io.Writer, q querier, documentID uint, text string, options ExportOptions, declared map[string]map[string]bool) error {
+	annotations, err := exportDocumentAnnotations(q, documentID, options)
+	if err != nil {
+		return err
+	}
+
+	relations, err := queryRelations(q, documentID)
+	if err != nil {
+		return err
+	}
+
+	// The fragments of a discontinuous span were imported as annotations of the same topic and bratId, they are
+	// written back as a single entity
+	groups := [][]Annotation{}
+	grouped := map[string]int{}
+	for _, annotation := range annotations {
+		bratID, ok := annotation.Attributes["bratId"].(string)
+		if !ok || bratID == "" {
+			groups = append(groups, []Annotation{annotation})
+			continue
+		}
+
+		key := annotation.Topic + "\t" + bratID
+		if index, ok := grouped[key]; ok {
+			groups[index] = append(groups[index], annotation)
+			continue
+		}
+		grouped[key] = len(groups)
+		groups = append(groups, []Annotation{annotation})
+	}
+
+	convert := offsetConverter(text, OffsetChar)
+	entities := map[uint]int{}
+	attributes, notes := 0, 0
+
+	for i, group := range groups {
+		fragments, texts := []string{}, []string{}
+		for _, annotation := range group {
+			entities[annotation.AnnotationID] = i + 1
+			fragments = append(fragments, fmt.Sprintf("%d %d", convert(annotation.CharacterStart), convert(annotation.CharacterEnd)))
+			texts = append(texts, bratText(text[annotation.CharacterStart:annotation.CharacterEnd]))
+		}
+
+		annotation := group[0]
+		_, err = fmt.Fprintf(w, "T%d\t%s %s\t%s\n", i+1, conllLabel(annotation.Topic), strings.Join(fragments, ";"), strings.Join(texts, " "))
+		if err != nil {
+			return err
+		}
+
+		names := []string{}
+		for name := range annotation.Attributes {
+			if name != "bratId" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value, ok := bratAttributeValue(name, annotation.Attributes[name])
+			if !ok {
+				continue
+			}
+
+			if declared[name] == nil {
+				declared[name] = map[string]bool{}
+			}
+
+			attributes++
+			if value == "" {
+				fmt.Fprintf(w, "A%d\t%s T%d\n", attributes, name, i+1)
+			} else {
+				declared[name][value] = true
+				fmt.Fprintf(w, "A%d\t%s T%d %s\n", attributes, name, i+1, value)
+			}
+		}
+
+		if annotation.Notes != "" {
+			notes++
+			fmt.Fprintf(w, "#%d\tAnnotatorNotes T%d\t%s\n", notes, i+1, bratText(annotation.Notes))
+		}
+	}
+
+	count := 0
+	for _, relation := range relations {
+		from, to := entities[relation.FromAnnotationID], entities[relation.ToAnnotationID]
+		if from == 0 || to == 0 {
+			continue
+		}
+
+		count++
+		_, err = fmt.Fprintf(w, "R%d\t%s Arg1:T%d Arg2:T%d\n", count, conllLabel(relation.RelationType), from, to)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeBratConf writes the annotation.conf declaring the topics, relation types and attributes of an export
+func writeBratConf(w io.Writer, topics Topics, relationTypes RelationTypes, declared map[string]map[string]bool) error {
+	fmt.Fprintln(w, "[entities]")
+	for _, topic := range topics {
+		fmt.Fprintln(w, conllLabel(topic.Topic))
+	}
+
+	fmt.Fprintln(w, "\n[relations]")
+	for _, relationType := range relationTypes {
+		fmt.Fprintf(w, "%s Arg1:<ENTITY>, Arg2:<ENTITY>\n", conllLabel(relationType.RelationType))
+	}
+
+	fmt.Fprintln(w, "\n[events]")
+
+	fmt.Fprintln(w, "\n[attributes]")
+	names := []string{}
+	for name := range declared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		values := []string{}
+		for value := range declared[name] {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+
+		if len(values) == 0 {
+			fmt.Fprintf(w, "%s Arg:<ENTITY>\n", name)
+		} else {
+			fmt.Fprintf(w, "%s Arg:<ENTITY>, Value:%s\n", name, strings.Join(values, "|"))
+		}
+	}
+
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// GetBratHandler exports the documents of a project in the BRAT standoff format, as a zip of a .txt and a .ann file
+// per document along with an annotation.conf
+func GetBratHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := parseExportOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	documents, err := exportDocuments(tx, projectID(r), options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	topics, err := queryTopics(tx, projectID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	relationTypes, err := queryRelationTypes(tx, projectID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = zipAttachment(w, fmt.Sprintf("project-%d-brat.zip", projectID(r)), func(archive *zip.Writer) error {
+		declared := map[string]map[string]bool{}
+
+		for _, document := range documents {
+			text, err := documentText(tx, document.ID)
+			if err != nil {
+				return err
+			}
+
+			file, err := archive.Create(exportFileName(document, ".txt"))
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(file, text)
+			if err != nil {
+				return err
+			}
+
+			file, err = archive.Create(exportFileName(document, ".ann"))
+			if err != nil {
+				return err
+			}
+			err = writeBratAnn(file, tx, document.ID, text, options, declared)
+			if err != nil {
+				return err
+			}
+		}
+
+		file, err := archive.Create("annotation.conf")
+		if err != nil {
+			return err
+		}
+		return writeBratConf(file, topics, relationTypes, declared)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// readBratRows reads a BRAT .ann file, its entities being its T IDs. A discontinuous span becomes an annotation per
+// fragment, marked with the ID of its T line in the bratId attribute. Lines of unsupported kinds, such as events, are
+// rejected.
+func readBratRows(body io.Reader) (importFile, error) {
+	file := newImportFile()
+
+	type entityExtra struct {
+		notes      []string
+		attributes Attributes
+	}
+	extras := map[string]*entityExtra{}
+	extra := func(id string) *entityExtra {
+		if extras[id] == nil {
+			extras[id] = &entityExtra{attributes: Attributes{}}
+		}
+		return extras[id]
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		// Files written on Windows end their lines with CRLF, the CR would end up in the text compared to the document
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(text, "\t", 3)
+		id := fields[0]
+		invalid := importRecord{Row: line, Err: fmt.Errorf("Invalid BRAT line %q", text)}
+		if len(fields) < 2 {
+			file.Records = append(file.Records, invalid)
+			continue
+		}
+		arguments := strings.Fields(fields[1])
+
+		switch {
+		case strings.HasPrefix(id, "T"):
+			if len(arguments) < 3 || len(fields) < 3 {
+				file.Records = append(file.Records, invalid)
+				continue
+			}
+
+			fragments := strings.Split(strings.Join(arguments[1:], " "), ";")
+			rows := []ImportRow{}
+			for _, fragment := range fragments {
+				offsets := strings.Fields(fragment)
+				if len(offsets) != 2 {
+					break
+				}
+				start, startErr := strconv.ParseUint(offsets[0], 10, 32)
+				end, endErr := strconv.ParseUint(offsets[1], 10, 32)
+				if startErr != nil || endErr != nil {
+					break
+				}
+
+				s, e := uint(start), uint(end)
+				rows = append(rows, ImportRow{Start: &s, End: &e, Label: arguments[0]})
+			}
+			if len(rows) != len(fragments) {
+				file.Records = append(file.Records, invalid)
+				continue
+			}
+
+			if len(rows) == 1 {
+				rows[0].Text = fields[2]
+			}
+			for _, row := range rows {
+				if len(rows) > 1 {
+					row.Attributes = Attributes{"bratId": id}
+				}
+				file.Entities[id] = append(file.Entities[id], len(file.Records))
+				file.Records = append(file.Records, importRecord{Row: line, Value: row})
+			}
+
+		case strings.HasPrefix(id, "R"):
+			if len(arguments) != 3 || !strings.HasPrefix(arguments[1], "Arg1:") || !strings.HasPrefix(arguments[2], "Arg2:") {
+				file.Records = append(file.Records, invalid)
+				continue
+			}
+			file.Relations = append(file.Relations, importRelation{Row: line, Type: arguments[0],
+				From: strings.TrimPrefix(arguments[1], "Arg1:"), To: strings.TrimPrefix(arguments[2], "Arg2:")})
+
+		case strings.HasPrefix(id, "A") || strings.HasPrefix(id, "M"):
+			if len(arguments) < 2 || len(arguments) > 3 {
+				file.Records = append(file.Records, invalid)
+				continue
+			}
+			var value interface{} = true
+			if len(arguments) == 3 {
+				value = arguments[2]
+			}
+			extra(arguments[1]).attributes[arguments[0]] = value
+
+		case strings.HasPrefix(id, "#"):
+			if len(arguments) != 2 || len(fields) < 3 {
+				file.Records = append(file.Records, invalid)
+				continue
+			}
+			extra(arguments[1]).notes = append(extra(arguments[1]).notes, fields[2])
+
+		default:
+			file.Records = append(file.Records, importRecord{Row: line, Err: fmt.Errorf("Unsupported BRAT annotation %s", id)})
+		}
+	}
+
+	for id, e := range extras {
+		indexes, ok := file.Entities[id]
+		if !ok {
+			continue
+		}
+
+		for _, index := range indexes {
+			row := &file.Records[index].Value
+			row.Notes = strings.Join(e.notes, "\n")
+			if row.Attributes == nil {
+				row.Attributes = Attributes{}
+			}
+			for name, value := range e.attributes {
+				row.Attributes[name] = value
+			}
+		}
+	}
+
+	return file, scanner.Err()
+}
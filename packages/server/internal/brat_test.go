@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBratRows(t *testing.T) {
+	// bratRow is what matters of a read row, Err being the error of an invalid line
+	type bratRow struct {
+		Row        int
+		Label      string
+		Start, End uint
+		Text       string
+		Notes      string
+		Attributes Attributes
+		Err        bool
+	}
+
+	tests := []struct {
+		name      string
+		ann       string
+		rows      []bratRow
+		relations []importRelation
+	}{
+		{"entity", "T1\tParty 0 4\tAcme\n",
+			[]bratRow{{Row: 1, Label: "Party", Start: 0, End: 4, Text: "Acme"}}, nil},
+		{"discontinuous", "T1\tParty 0 4;9 13\tAcme Corp\n",
+			[]bratRow{{Row: 1, Label: "Party", Start: 0, End: 4, Attributes: Attributes{"bratId": "T1"}},
+				{Row: 1, Label: "Party", Start: 9, End: 13, Attributes: Attributes{"bratId": "T1"}}}, nil},
+		{"attributes and notes", "T1\tParty 0 4\tAcme\nA1\tverified T1\nM2\trole T1 buyer\n#1\tAnnotatorNotes T1\tmain\n#2\tAnnotatorNotes T1\tparty\n",
+			[]bratRow{{Row: 1, Label: "Party", Start: 0, End: 4, Text: "Acme", Notes: "main\nparty",
+				Attributes: Attributes{"verified": true, "role": "buyer"}}}, nil},
+		{"relation", "T1\tParty 0 4\tAcme\nT2\tDate 5 9\tMay\nR1\tsignedOn Arg1:T1 Arg2:T2\n",
+			[]bratRow{{Row: 1, Label: "Party", Start: 0, End: 4, Text: "Acme"},
+				{Row: 2, Label: "Date", Start: 5, End: 9, Text: "May"}},
+			[]importRelation{{Row: 3, Type: "signedOn", From: "T1", To: "T2"}}},
+		{"blank lines", "\n  \nT1\tParty 0 4\tAcme\n",
+			[]bratRow{{Row: 3, Label: "Party", Start: 0, End: 4, Text: "Acme"}}, nil},
+		{"invalid offsets", "T1\tParty 0 x\tAcme\nT2\tParty 0 4;9\tAcme\nT3\tParty 0 4\n",
+			[]bratRow{{Row: 1, Err: true}, {Row: 2, Err: true}, {Row: 3, Err: true}}, nil},
+		{"invalid relation", "R1\tsignedOn T1 T2\n", []bratRow{{Row: 1, Err: true}}, nil},
+		{"event", "E1\tPayment:T1\n", []bratRow{{Row: 1, Err: true}}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := readBratRows(strings.NewReader(test.ann))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rows := []bratRow{}
+			for _, record := range file.Records {
+				row := bratRow{Row: record.Row, Err: record.Err != nil}
+				if record.Err == nil {
+					value := record.Value
+					row.Label, row.Start, row.End, row.Text, row.Notes, row.Attributes = value.Label, *value.Start, *value.End, value.Text, value.Notes, value.Attributes
+				}
+				rows = append(rows, row)
+			}
+			if !reflect.DeepEqual(rows, test.rows) {
+				t.Errorf("read %+v, want %+v", rows, test.rows)
+			}
+
+			if !reflect.DeepEqual(file.Relations, test.relations) {
+				t.Errorf("read relations %+v, want %+v", file.Relations, test.relations)
+			}
+		})
+	}
+}
+
+func TestReadBratRowsCRLF(t *testing.T) {
+	file, err := readBratRows(strings.NewReader("T1\tParty 0 4\tAcme\r\n#1\tAnnotatorNotes T1\tmain party\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(file.Records) != 1 || file.Records[0].Err != nil {
+		t.Fatalf("read %+v, want an entity", file.Records)
+	}
+	if row := file.Records[0].Value; row.Text != "Acme" || row.Notes != "main party" {
+		t.Errorf("read text %q and notes %q, want them without the CR", row.Text, row.Notes)
+	}
+}
+
+func TestWriteBratAnnDiscontinuous(t *testing.T) {
+	defer openTestDatabase(t)()
+	partyID := mustExec(t, "INSERT INTO topics (topic) VALUES ('Party')")
+	relationTypeID := mustExec(t, "INSERT INTO relation_types (relation_type) VALUES ('same')")
+	documentID := insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "and", "Corp", "Beta"})
+
+	for _, annotation := range []Annotation{
+		{CharacterStart: 0, CharacterEnd: 4, Attributes: Attributes{"bratId": "T7", "verified": true}},
+		{CharacterStart: 9, CharacterEnd: 13, Attributes: Attributes{"bratId": "T7", "verified": true}},
+		{CharacterStart: 14, CharacterEnd: 18},
+	} {
+		annotation.TopicID, annotation.Status, annotation.Source = partyID, StatusAccepted, SourceHuman
+		if _, err := insertAnnotation(db, documentID, annotation, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := insertRelation(db, documentID, Relation{RelationTypeID: relationTypeID, FromAnnotationID: 2, ToAnnotationID: 3}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := documentText(db, documentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ann bytes.Buffer
+	err = writeBratAnn(&ann, db, documentID, text, ExportOptions{}, map[string]map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "T1\tParty 0 4;9 13\tAcme Corp\n" +
+		"A1\tverified T1\n" +
+		"T2\tParty 14 18\tBeta\n" +
+		"R1\tsame Arg1:T1 Arg2:T2\n"
+	if ann.String() != want {
+		t.Errorf("wrote:\n%s\nwant:\n%s", ann.String(), want)
+	}
+
+	// Reading the file back gives the fragments with the same bratId
+	file, err := readBratRows(&ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexes := file.Entities["T1"]; len(indexes) != 2 {
+		t.Errorf("read T1 as %d annotations, want its 2 fragments", len(indexes))
+	}
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+)
+
+// COCOImage is a page of a document
+type COCOImage struct {
+	ID         uint   `json:"id"`
+	FileName   string `json:"file_name"`
+	Width      uint   `json:"width"`
+	Height     uint   `json:"height"`
+	DocumentID uint   `json:"document_id"`
+	Page       uint   `json:"page"`
+}
+
+// COCOCategory is a topic
+type COCOCategory struct {
+	ID            uint   `json:"id"`
+	Name          string `json:"name"`
+	Supercategory string `json:"supercategory"`
+}
+
+// COCOAnnotation is the box of the tokens of an annotation on a line of a page, in pixels as [x, y, width, height].
+// Every box of an annotation carries its notes and attributes.
+type COCOAnnotation struct {
+	ID           uint       `json:"id"`
+	ImageID      uint       `json:"image_id"`
+	CategoryID   uint       `json:"category_id"`
+	BBox         [4]uint    `json:"bbox"`
+	Area         uint       `json:"area"`
+	IsCrowd      uint       `json:"iscrowd"`
+	Segmentation [][]uint   `json:"segmentation"`
+	AnnotationID uint       `json:"annotation_id"`
+	Line         uint       `json:"line"`
+	Notes        string     `json:"notes,omitempty"`
+	Attributes   Attributes `json:"attributes,omitempty"`
+}
+
+// COCODataset is the annotations file of the COCO export
+type COCODataset struct {
+	Images      []COCOImage      `json:"images"`
+	Categories  []COCOCategory   `json:"categories"`
+	Annotations []COCOAnnotation `json:"annotations"`
+}
+
+// lineBoxes returns the union of the boxes of the tokens of an annotation on each line of a page, as [left, top,
+// right, bottom], in the order of the lines
+func lineBoxes(words Tokens, annotation Annotation) ([]uint, map[uint][4]uint) {
+	lines := []uint{}
+	boxes := map[uint][4]uint{}
+
+	for _, word := range words {
+		if word.CharacterEnd <= annotation.CharacterStart || word.CharacterStart >= annotation.CharacterEnd {
+			continue
+		}
+
+		box := [4]uint{word.BoundingBox.Left, word.BoundingBox.Top, word.BoundingBox.Right, word.BoundingBox.Bottom}
+		if lineBox, ok := boxes[word.Line]; ok {
+			boxes[word.Line] = unionBox(lineBox, box)
+		} else {
+			boxes[word.Line] = box
+			lines = append(lines, word.Line)
+		}
+	}
+
+	return lines, boxes
+}
+
+// cocoCategories returns the exported topics, a child topic having its parent as supercategory
+func cocoCategories(q querier, projectID uint, options ExportOptions) ([]COCOCategory, error) {
+	topics, err := queryTopics(q, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[uint]string{}
+	for _, topic := range topics {
+		names[topic.TopicID] = topic.Topic
+	}
+
+	exported := map[uint]bool{}
+	for _, topicID := range options.TopicIDs {
+		exported[topicID] = true
+	}
+
+	categories := []COCOCategory{}
+	for _, topic := range topics {
+		if len(exported) > 0 && !exported[topic.TopicID] {
+			continue
+		}
+
+		category := COCOCategory{ID: topic.TopicID, Name: topic.Topic}
+		if topic.ParentID != nil {
+			category.Supercategory = names[*topic.ParentID]
+		}
+		categories = append(categories, category)
+	}
+
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	return categories, nil
+}
+
+// GetCOCOHandler exports the boxes of the annotations of a project in the COCO format, as a zip of the page images
+// and of an annotations.json file
+func GetCOCOHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := parseExportOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	documents, err := exportDocuments(tx, projectID(r), options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dataset := COCODataset{Images: []COCOImage{}, Annotations: []COCOAnnotation{}}
+	dataset.Categories, err = cocoCategories(tx, projectID(r), options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = zipAttachment(w, fmt.Sprintf("project-%d-coco.zip", projectID(r)), func(archive *zip.Writer) error {
+		for _, document := range documents {
+			text, err := documentText(tx, document.ID)
+			if err != nil {
+				return err
+			}
+
+			pages, err := loadDocumentTokens(tx, document.ID)
+			if err != nil {
+				return err
+			}
+
+			annotations, err := exportDocumentAnnotations(tx, document.ID, options)
+			if err != nil {
+				return err
+			}
+
+			for _, page := range pages {
+				image, extension, err := pageImage(tx, document.ID, page.Page)
+				if err != nil {
+					return err
+				}
+
+				cocoImage := COCOImage{
+					ID:         uint(len(dataset.Images) + 1),
+					FileName:   exportFileName(document, fmt.Sprintf("-%d%s", page.Page, extension)),
+					Width:      page.OriginalWidth,
+					Height:     page.OriginalHeight,
+					DocumentID: document.ID,
+					Page:       page.Page,
+				}
+				dataset.Images = append(dataset.Images, cocoImage)
+
+				file, err := archive.Create(path.Join("images", cocoImage.FileName))
+				if err != nil {
+					return err
+				}
+				_, err = file.Write(image)
+				if err != nil {
+					return err
+				}
+
+				words := pageWords(text, page)
+				for _, annotation := range annotations {
+					lines, boxes := lineBoxes(words, annotation)
+					for _, line := range lines {
+						box := boxes[line]
+						width, height := box[2]-box[0], box[3]-box[1]
+
+						dataset.Annotations = append(dataset.Annotations, COCOAnnotation{
+							ID:           uint(len(dataset.Annotations) + 1),
+							ImageID:      cocoImage.ID,
+							CategoryID:   annotation.TopicID,
+							BBox:         [4]uint{box[0], box[1], width, height},
+							Area:         width * height,
+							Segmentation: [][]uint{{box[0], box[1], box[2], box[1], box[2], box[3], box[0], box[3]}},
+							AnnotationID: annotation.AnnotationID,
+							Line:         line,
+							Notes:        annotation.Notes,
+							Attributes:   annotation.Attributes,
+						})
+					}
+				}
+			}
+		}
+
+		file, err := archive.Create("annotations.json")
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(file).Encode(dataset)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
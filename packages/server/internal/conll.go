@@ -0,0 +1,366 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Policies for the annotations overlapping each other, as a token gets a single tag per column
+const (
+	OverlapLongest = "longest" // the longest annotation wins, then the first one
+	OverlapFirst   = "first"   // the annotation starting first wins, then the longest one
+	OverlapError   = "error"   // the export fails
+	OverlapColumns = "columns" // one tag column per topic, the longest annotation winning inside a topic
+)
+
+// Sentence boundaries, CoNLL files separate sentences with an empty line
+const (
+	SentenceLine = "line"
+	SentencePage = "page"
+)
+
+var errOverlap = errors.New("Overlapping annotations")
+
+// CoNLLOptions are the query parameters of the CoNLL export
+type CoNLLOptions struct {
+	ExportOptions
+	Overlap   string
+	Sentences string
+}
+
+func parseCoNLLOptions(r *http.Request) (CoNLLOptions, error) {
+	query := r.URL.Query()
+
+	exportOptions, err := parseExportOptions(query)
+	if err != nil {
+		return CoNLLOptions{}, err
+	}
+
+	options := CoNLLOptions{ExportOptions: exportOptions, Overlap: OverlapLongest, Sentences: SentenceLine}
+
+	if overlap := query.Get("overlap"); overlap != "" {
+		if overlap != OverlapLongest && overlap != OverlapFirst && overlap != OverlapError && overlap != OverlapColumns {
+			return options, fmt.Errorf("Invalid overlap %q, expected longest, first, error or columns", overlap)
+		}
+		options.Overlap = overlap
+	}
+
+	if sentences := query.Get("sentences"); sentences != "" {
+		if sentences != SentenceLine && sentences != SentencePage {
+			return options, fmt.Errorf("Invalid sentences %q, expected line or page", sentences)
+		}
+		options.Sentences = sentences
+	}
+
+	return options, nil
+}
+
+func overlapping(a, b Annotation) bool {
+	return a.CharacterStart < b.CharacterEnd && b.CharacterStart < a.CharacterEnd
+}
+
+// resolveOverlaps keeps annotations which do not overlap each other, following an overlap policy
+func resolveOverlaps(annotations []Annotation, policy string) ([]Annotation, error) {
+	sorted := append([]Annotation{}, annotations...)
+
+	length := func(a Annotation) uint { return a.CharacterEnd - a.CharacterStart }
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if policy == OverlapFirst && a.CharacterStart != b.CharacterStart {
+			return a.CharacterStart < b.CharacterStart
+		}
+		if length(a) != length(b) {
+			return length(a) > length(b)
+		}
+		return a.CharacterStart < b.CharacterStart
+	})
+
+	kept := []Annotation{}
+	for _, annotation := range sorted {
+		conflict := false
+		for _, other := range kept {
+			if !overlapping(annotation, other) {
+				continue
+			}
+
+			if policy == OverlapError {
+				return nil, fmt.Errorf("%w: %d (%d-%d) and %d (%d-%d)", errOverlap, other.AnnotationID, other.CharacterStart, other.CharacterEnd,
+					annotation.AnnotationID, annotation.CharacterStart, annotation.CharacterEnd)
+			}
+			conflict = true
+			break
+		}
+
+		if !conflict {
+			kept = append(kept, annotation)
+		}
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].CharacterStart < kept[j].CharacterStart })
+	return kept, nil
+}
+
+// conllLabel makes a topic name usable in a whitespace separated column
+func conllLabel(topic string) string {
+	return strings.Join(strings.Fields(topic), "_")
+}
+
+// iobTags tags each token with the annotation overlapping it: B- for the first token of the annotation in a sentence,
+// I- for the next ones, O outside of annotations. The annotations must not overlap each other.
+func iobTags(tokens []Token, sentenceStarts []bool, annotations []Annotation) []string {
+	tags := make([]string, len(tokens))
+
+	a := 0
+	previous := -1
+	for i, token := range tokens {
+		tags[i] = "O"
+
+		for a < len(annotations) && annotations[a].CharacterEnd <= token.CharacterStart {
+			a++
+		}
+
+		if a == len(annotations) || annotations[a].CharacterStart >= token.CharacterEnd {
+			previous = -1
+			continue
+		}
+
+		prefix := "I-"
+		if previous != a || sentenceStarts[i] {
+			prefix = "B-"
+		}
+		tags[i] = prefix + conllLabel(annotations[a].Topic)
+		previous = a
+	}
+
+	return tags
+}
+
+// writeCoNLL writes a document as CoNLL lines of a token followed by its tags, with an empty line between sentences
+func writeCoNLL(w io.Writer, q querier, documentID uint, options CoNLLOptions, topics Topics) error {
+	text, err := documentText(q, documentID)
+	if err != nil {
+		return err
+	}
+
+	pages, err := loadDocumentTokens(q, documentID)
+	if err != nil {
+		return err
+	}
+
+	annotations, err := exportDocumentAnnotations(q, documentID, options.ExportOptions)
+	if err != nil {
+		return err
+	}
+
+	tokens := []Token{}
+	sentenceStarts := []bool{}
+	for _, page := range pages {
+		pageTokens := append(Tokens{}, page.Tokens...)
+		sort.SliceStable(pageTokens, func(i, j int) bool { return pageTokens[i].CharacterStart < pageTokens[j].CharacterStart })
+
+		for i, token := range pageTokens {
+			if token.CharacterEnd <= token.CharacterStart || token.CharacterEnd > uint(len(text)) {
+				continue
+			}
+
+			start := i == 0 || (options.Sentences == SentenceLine && token.Line != pageTokens[i-1].Line)
+			tokens = append(tokens, token)
+			sentenceStarts = append(sentenceStarts, start)
+		}
+	}
+
+	columns := [][]string{}
+	if options.Overlap == OverlapColumns {
+		for _, topic := range topics {
+			topicAnnotations := []Annotation{}
+			for _, annotation := range annotations {
+				if annotation.TopicID == topic.TopicID {
+					topicAnnotations = append(topicAnnotations, annotation)
+				}
+			}
+
+			kept, _ := resolveOverlaps(topicAnnotations, OverlapLongest)
+			columns = append(columns, iobTags(tokens, sentenceStarts, kept))
+		}
+	} else {
+		kept, err := resolveOverlaps(annotations, options.Overlap)
+		if err != nil {
+			return fmt.Errorf("Document %d: %w", documentID, err)
+		}
+		columns = append(columns, iobTags(tokens, sentenceStarts, kept))
+	}
+
+	for i, token := range tokens {
+		if sentenceStarts[i] && i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		line := []string{text[token.CharacterStart:token.CharacterEnd]}
+		for _, column := range columns {
+			line = append(line, column[i])
+		}
+
+		_, err = fmt.Fprintln(w, strings.Join(line, " "))
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(w)
+
+	return nil
+}
+
+// conllTopics returns the topics of the tag columns with ?overlap=columns, sorted by name
+func conllTopics(q querier, projectID uint, options CoNLLOptions) (Topics, error) {
+	if options.Overlap != OverlapColumns {
+		return nil, nil
+	}
+
+	topics, err := queryTopics(q, projectID)
+	if err != nil || len(options.TopicIDs) == 0 {
+		return topics, err
+	}
+
+	exported := map[uint]bool{}
+	for _, topicID := range options.TopicIDs {
+		exported[topicID] = true
+	}
+
+	kept := Topics{}
+	for _, topic := range topics {
+		if exported[topic.TopicID] {
+			kept = append(kept, topic)
+		}
+	}
+
+	return kept, nil
+}
+
+func conllError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errOverlap) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// GetDocumentCoNLLHandler exports a document in the CoNLL format, with IOB2 tags
+func GetDocumentCoNLLHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	options, err := parseCoNLLOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	document, err := getDocumentSummary(db, uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	topics, err := conllTopics(db, document.ProjectID, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var b bytes.Buffer
+	err = writeCoNLL(&b, db, document.ID, options, topics)
+	if err != nil {
+		conllError(w, err)
+		return
+	}
+
+	attachment(w, exportFileName(document, ".conll"), "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	b.WriteTo(w)
+}
+
+// GetCoNLLHandler exports the documents of a project in the CoNLL format, concatenated with -DOCSTART- lines between
+// documents, or as a zip of one file per document with ?split=true
+func GetCoNLLHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := parseCoNLLOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	documents, err := exportDocuments(tx, projectID(r), options.ExportOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	topics, err := conllTopics(tx, projectID(r), options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var b bytes.Buffer
+
+	if r.URL.Query().Get("split") == "true" {
+		archive := zip.NewWriter(&b)
+		for _, document := range documents {
+			file, err := archive.Create(exportFileName(document, ".conll"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			err = writeCoNLL(file, tx, document.ID, options, topics)
+			if err != nil {
+				conllError(w, err)
+				return
+			}
+		}
+
+		err = archive.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		attachment(w, fmt.Sprintf("project-%d-conll.zip", projectID(r)), "application/zip")
+	} else {
+		columns := 1
+		if options.Overlap == OverlapColumns {
+			columns = len(topics)
+		}
+
+		for _, document := range documents {
+			fmt.Fprintf(&b, "-DOCSTART-%s\n\n", strings.Repeat(" O", columns))
+
+			err = writeCoNLL(&b, tx, document.ID, options, topics)
+			if err != nil {
+				conllError(w, err)
+				return
+			}
+		}
+
+		attachment(w, fmt.Sprintf("project-%d.conll", projectID(r)), "text/plain; charset=UTF-8")
+	}
+
+	w.WriteHeader(http.StatusOK)
+	b.WriteTo(w)
+}
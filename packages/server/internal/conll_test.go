@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestResolveOverlaps(t *testing.T) {
+	annotation := func(id, start, end uint) Annotation {
+		return Annotation{AnnotationID: id, CharacterStart: start, CharacterEnd: end}
+	}
+	overlapping := []Annotation{annotation(1, 0, 10), annotation(2, 5, 20), annotation(3, 20, 25), annotation(4, 22, 24)}
+
+	tests := []struct {
+		name        string
+		annotations []Annotation
+		policy      string
+		want        []uint
+		err         error
+	}{
+		{"none", nil, OverlapLongest, []uint{}, nil},
+		{"longest", overlapping, OverlapLongest, []uint{2, 3}, nil},
+		{"first", overlapping, OverlapFirst, []uint{1, 3}, nil},
+		{"longest ties", []Annotation{annotation(1, 5, 10), annotation(2, 0, 5), annotation(3, 3, 8)}, OverlapLongest, []uint{2, 1}, nil},
+		{"first ties", []Annotation{annotation(1, 0, 4), annotation(2, 0, 8)}, OverlapFirst, []uint{2}, nil},
+		{"error", overlapping, OverlapError, nil, errOverlap},
+		{"error without overlap", []Annotation{annotation(3, 20, 25), annotation(1, 0, 10)}, OverlapError, []uint{1, 3}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kept, err := resolveOverlaps(test.annotations, test.policy)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if test.err != nil {
+				return
+			}
+
+			ids := []uint{}
+			for _, annotation := range kept {
+				ids = append(ids, annotation.AnnotationID)
+			}
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("kept %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+func TestIOBTags(t *testing.T) {
+	// Acme Corp signs today
+	tokens := []Token{{CharacterStart: 0, CharacterEnd: 4}, {CharacterStart: 5, CharacterEnd: 9},
+		{CharacterStart: 10, CharacterEnd: 15}, {CharacterStart: 16, CharacterEnd: 21}}
+	annotation := func(topic string, start, end uint) Annotation {
+		return Annotation{Topic: topic, CharacterStart: start, CharacterEnd: end}
+	}
+
+	tests := []struct {
+		name           string
+		sentenceStarts []bool
+		annotations    []Annotation
+		want           []string
+	}{
+		{"none", []bool{true, false, false, false}, nil, []string{"O", "O", "O", "O"}},
+		{"spans", []bool{true, false, false, false}, []Annotation{annotation("Party", 0, 9), annotation("Due date", 16, 21)},
+			[]string{"B-Party", "I-Party", "O", "B-Due_date"}},
+		{"adjacent", []bool{true, false, false, false}, []Annotation{annotation("Party", 0, 4), annotation("Party", 5, 9)},
+			[]string{"B-Party", "B-Party", "O", "O"}},
+		{"sentence start", []bool{true, true, false, false}, []Annotation{annotation("Party", 0, 9)},
+			[]string{"B-Party", "B-Party", "O", "O"}},
+		{"partial token", []bool{true, false, false, false}, []Annotation{annotation("Party", 2, 7)},
+			[]string{"B-Party", "I-Party", "O", "O"}},
+		{"between tokens", []bool{true, false, false, false}, []Annotation{annotation("Party", 4, 5)},
+			[]string{"O", "O", "O", "O"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if tags := iobTags(tokens, test.sentenceStarts, test.annotations); !reflect.DeepEqual(tags, test.want) {
+				t.Errorf("tagged %v, want %v", tags, test.want)
+			}
+		})
+	}
+}
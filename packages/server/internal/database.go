@@ -14,7 +14,9 @@ func InitDatabase(filePath string) error {
 	log.Printf("Connecting to %v", filePath)
 
 	var err error
-	db, err = sql.Open("sqlite3", filePath)
+	// Foreign keys are off by default in SQLite, they are needed for the cascading deletes of the schema.
+	// In WAL mode, long reads such as backups do not block writes, and writes wait for each other instead of failing.
+	db, err = sql.Open("sqlite3", filePath+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=10000")
 
 	return err
 }
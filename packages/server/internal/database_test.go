@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// openTestDatabase replaces the connection pool with a new database created from the schema, removed by the returned
+// function
+func openTestDatabase(t *testing.T) func() {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "spectator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := ioutil.ReadFile("../schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = InitDatabase(filepath.Join(dir, "spectator.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(string(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// mustExec runs a statement of a test fixture and returns the ID of the inserted row
+func mustExec(t *testing.T, query string, args ...interface{}) uint {
+	t.Helper()
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+
+	id, _ := res.LastInsertId()
+	return uint(id)
+}
+
+// insertTestDocument adds a processed document of a page, with a token per word of the text
+func insertTestDocument(t *testing.T, projectID uint, name string, words []string) uint {
+	t.Helper()
+
+	text := ""
+	tokens := []Token{}
+	for i, word := range words {
+		start := uint(len(text))
+		text += word + " "
+		tokens = append(tokens, Token{CharacterStart: start, CharacterEnd: start + uint(len(word)),
+			BoundingBox: BoundingBox{Top: 10, Left: uint(10 + 50*i), Right: uint(50 + 50*i), Bottom: 30}})
+	}
+
+	pageTokens, err := json.Marshal(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documentID := mustExec(t, "INSERT INTO documents (project_id, name, pages, text, processed, original) VALUES (?, ?, 1, ?, TRUE, ?)",
+		projectID, name, text, []byte("%PDF-1.4"))
+	mustExec(t, "INSERT INTO document_pages (document_id, page, height, width, image, image_format, tokens) VALUES (?, 1, 100, 600, ?, 'png', ?)",
+		documentID, []byte("png"), pageTokens)
+
+	return documentID
+}
+
+// serveAs runs a handler for a request of a logged in user, with the route parameters of vars
+func serveAs(handler http.HandlerFunc, userID uint, method, body string, vars map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	r = mux.SetURLVars(r, vars)
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey, User{UserID: userID}))
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
@@ -0,0 +1,391 @@
+package internal
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DoccanoEntity is a span of a Doccano example, with character offsets. Notes and Attributes are not Doccano fields,
+// they are kept when the annotation has some.
+type DoccanoEntity struct {
+	ID          uint       `json:"id"`
+	Label       string     `json:"label"`
+	StartOffset uint       `json:"start_offset"`
+	EndOffset   uint       `json:"end_offset"`
+	Notes       string     `json:"notes,omitempty"`
+	Attributes  Attributes `json:"attributes,omitempty"`
+}
+
+// DoccanoRelation links two entities of a Doccano example
+type DoccanoRelation struct {
+	ID     uint   `json:"id"`
+	FromID uint   `json:"from_id"`
+	ToID   uint   `json:"to_id"`
+	Type   string `json:"type"`
+}
+
+// DoccanoExample is the layer of an annotator in a document. Label holds the same spans as Entities as [start, end,
+// label] for sequence labeling projects, User and Gold tell whose layer it is.
+type DoccanoExample struct {
+	ID         uint              `json:"id"`
+	Text       string            `json:"text"`
+	Entities   []DoccanoEntity   `json:"entities"`
+	Relations  []DoccanoRelation `json:"relations"`
+	Label      [][]interface{}   `json:"label"`
+	DocumentID uint              `json:"documentId,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Gold       bool              `json:"gold,omitempty"`
+}
+
+// DoccanoLabel is a label of the Doccano label config. Parent, ValueType, EnumValues and Description are not Doccano
+// fields, they keep the taxonomy of the topics when the export is imported back.
+type DoccanoLabel struct {
+	ID              uint     `json:"id"`
+	Text            string   `json:"text"`
+	BackgroundColor string   `json:"background_color"`
+	TextColor       string   `json:"text_color"`
+	Parent          string   `json:"parent,omitempty"`
+	ValueType       string   `json:"value_type,omitempty"`
+	EnumValues      []string `json:"enum_values,omitempty"`
+	Description     string   `json:"description,omitempty"`
+}
+
+// Files of the Doccano export which are not the layer of an annotator, whose files are prefixed with doccanoUser
+const (
+	doccanoGold        = "gold"
+	doccanoPredictions = "predictions"
+	doccanoAll         = "all"
+	doccanoUser        = "user-"
+	doccanoLabels      = "label_config.json"
+)
+
+// doccanoLabelConfig describes the topics of a project as a Doccano label config
+func doccanoLabelConfig(topics Topics) []DoccanoLabel {
+	names := map[uint]string{}
+	for _, topic := range topics {
+		names[topic.TopicID] = topic.Topic
+	}
+
+	labels := []DoccanoLabel{}
+	for _, topic := range topics {
+		label := DoccanoLabel{ID: topic.TopicID, Text: topic.Topic, BackgroundColor: topic.Color, TextColor: "#ffffff",
+			ValueType: topic.ValueType, EnumValues: topic.EnumValues, Description: topic.Description}
+		if topic.ParentID != nil {
+			label.Parent = names[*topic.ParentID]
+		}
+		labels = append(labels, label)
+	}
+
+	return labels
+}
+
+// doccanoExample converts a layer of a document to a Doccano example, with character offsets
+func doccanoExample(document DocumentSummary, text string, layer annotationLayer, relations []Relation, usernames map[uint]string) DoccanoExample {
+	example := DoccanoExample{
+		ID:         document.ID,
+		Text:       text,
+		Entities:   []DoccanoEntity{},
+		Relations:  []DoccanoRelation{},
+		Label:      [][]interface{}{},
+		DocumentID: document.ID,
+		Name:       document.Name,
+		Gold:       layer.Gold,
+	}
+	if layer.UserID != nil {
+		example.User = usernames[*layer.UserID]
+	}
+
+	convert := offsetConverter(text, OffsetChar)
+	for _, annotation := range layer.Annotations {
+		start, end := convert(annotation.CharacterStart), convert(annotation.CharacterEnd)
+		example.Entities = append(example.Entities, DoccanoEntity{ID: annotation.AnnotationID, Label: annotation.Topic, StartOffset: start, EndOffset: end,
+			Notes: annotation.Notes, Attributes: annotation.Attributes})
+		example.Label = append(example.Label, []interface{}{start, end, annotation.Topic})
+	}
+
+	for _, relation := range layerRelations(relations, layer) {
+		example.Relations = append(example.Relations, DoccanoRelation{ID: relation.RelationID, FromID: relation.FromAnnotationID,
+			ToID: relation.ToAnnotationID, Type: relation.RelationType})
+	}
+
+	return example
+}
+
+// GetDoccanoHandler exports the documents of a project as Doccano JSONL, in a zip of a file per annotator, as Doccano
+// does without collaborative annotation, and the label config. The gold layers and the annotations of nobody have their
+// own files.
+func GetDoccanoHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := parseExportOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	documents, err := exportDocuments(tx, projectID(r), options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, err := queryUsers(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	usernames := map[uint]string{}
+	for _, user := range users {
+		usernames[user.UserID] = user.Username
+	}
+
+	topics, err := queryTopics(tx, projectID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	files := map[string]*bytes.Buffer{}
+	names := []string{}
+	for _, document := range documents {
+		text, err := documentText(tx, document.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		layers, err := annotationLayers(tx, document.ID, options)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		relations, err := queryRelations(tx, document.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, layer := range layers {
+			name := doccanoPredictions
+			if layer.Gold {
+				name = doccanoGold
+			} else if layer.UserID != nil {
+				name = doccanoUser + usernames[*layer.UserID]
+			}
+
+			if files[name] == nil {
+				files[name] = &bytes.Buffer{}
+				names = append(names, name)
+			}
+			err = json.NewEncoder(files[name]).Encode(doccanoExample(document, text, layer, relations, usernames))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	err = zipAttachment(w, fmt.Sprintf("project-%d-doccano.zip", projectID(r)), func(archive *zip.Writer) error {
+		file, err := archive.Create(doccanoLabels)
+		if err != nil {
+			return err
+		}
+		err = json.NewEncoder(file).Encode(doccanoLabelConfig(topics))
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			file, err := archive.Create(strings.NewReplacer("/", "_", "\\", "_").Replace(name) + ".jsonl")
+			if err != nil {
+				return err
+			}
+			_, err = files[name].WriteTo(file)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// readDoccanoExamples reads the examples of a Doccano JSONL file. The annotator of an example is its user, or the name
+// of its file in a zip without the user- prefix.
+func readDoccanoExamples(file *importFile, name string, body io.Reader) error {
+	layer := ""
+	if name != "" {
+		layer = strings.TrimSuffix(path.Base(name), path.Ext(name))
+	}
+	nobody := ""
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var example DoccanoExample
+		err := json.Unmarshal(scanner.Bytes(), &example)
+		if err != nil {
+			file.Records = append(file.Records, importRecord{File: name, Row: line, Err: fmt.Errorf("Invalid JSON: %w", err)})
+			continue
+		}
+
+		template := ImportRow{DocumentID: example.DocumentID, DocumentText: example.Text, Gold: example.Gold}
+		switch {
+		case example.User != "":
+			template.Annotator = &example.User
+		case layer == doccanoPredictions:
+			template.Annotator = &nobody
+			template.Source = SourceModel
+		case layer == doccanoGold:
+			template.Gold = true
+		case strings.HasPrefix(layer, doccanoUser):
+			username := strings.TrimPrefix(layer, doccanoUser)
+			template.Annotator = &username
+		case layer != "" && layer != doccanoAll:
+			username := layer
+			template.Annotator = &username
+		}
+
+		key := func(id uint) string { return fmt.Sprintf("%s/%d/%d", name, line, id) }
+
+		if example.Entities == nil {
+			for _, label := range example.Label {
+				start, startOK := jsonNumber(label, 0)
+				end, endOK := jsonNumber(label, 1)
+				topic, topicOK := "", len(label) == 3
+				if topicOK {
+					topic, topicOK = label[2].(string)
+				}
+				if !startOK || !endOK || !topicOK {
+					file.Records = append(file.Records, importRecord{File: name, Row: line, Err: fmt.Errorf("Invalid label %v, expected [start, end, label]", label)})
+					continue
+				}
+
+				example.Entities = append(example.Entities, DoccanoEntity{Label: topic, StartOffset: start, EndOffset: end})
+			}
+		}
+
+		for _, entity := range example.Entities {
+			row := template
+			start, end := entity.StartOffset, entity.EndOffset
+			row.Start, row.End, row.Label = &start, &end, entity.Label
+			row.Notes, row.Attributes = entity.Notes, entity.Attributes
+
+			file.Entities[key(entity.ID)] = append(file.Entities[key(entity.ID)], len(file.Records))
+			file.Records = append(file.Records, importRecord{File: name, Row: line, Value: row})
+		}
+
+		for _, relation := range example.Relations {
+			file.Relations = append(file.Relations, importRelation{File: name, Row: line, Type: relation.Type,
+				From: key(relation.FromID), To: key(relation.ToID)})
+		}
+	}
+
+	return scanner.Err()
+}
+
+// jsonNumber returns the index-th value of a decoded JSON array as an offset
+func jsonNumber(values []interface{}, index int) (uint, bool) {
+	if index >= len(values) {
+		return 0, false
+	}
+
+	number, ok := values[index].(float64)
+	if !ok || number < 0 || number != float64(uint(number)) {
+		return 0, false
+	}
+
+	return uint(number), true
+}
+
+// readDoccanoLabels reads the label config of a Doccano zip, describing the topics the import creates
+func readDoccanoLabels(file *importFile, body io.Reader) error {
+	var labels []DoccanoLabel
+	err := json.NewDecoder(body).Decode(&labels)
+	if err != nil {
+		return fmt.Errorf("Invalid JSON: %w", err)
+	}
+
+	for _, label := range labels {
+		// Colors Spectator cannot display are left to the default, rather than rejecting the rows of the label
+		if !colorPattern.MatchString(label.BackgroundColor) {
+			label.BackgroundColor = ""
+		}
+
+		file.Topics[label.Text] = importTopic{
+			Topic: Topic{Topic: label.Text, Color: label.BackgroundColor, Description: label.Description,
+				ValueType: label.ValueType, EnumValues: label.EnumValues},
+			Parent: label.Parent,
+		}
+	}
+
+	return nil
+}
+
+// readDoccanoRows reads a Doccano JSONL file, or a zip of JSONL files named after their annotator and its label config
+func readDoccanoRows(body io.Reader) (importFile, error) {
+	file := newImportFile()
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return file, err
+	}
+
+	if !bytes.HasPrefix(content, []byte("PK\x03\x04")) {
+		return file, readDoccanoExamples(&file, "", bytes.NewReader(content))
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return file, fmt.Errorf("Invalid zip: %w", err)
+	}
+
+	for _, entry := range archive.File {
+		labels := path.Base(entry.Name) == doccanoLabels
+		if entry.FileInfo().IsDir() || path.Ext(entry.Name) != ".jsonl" && !labels {
+			continue
+		}
+
+		reader, err := entry.Open()
+		if err != nil {
+			return file, fmt.Errorf("Unable to read %s: %w", entry.Name, err)
+		}
+
+		if labels {
+			err = readDoccanoLabels(&file, reader)
+		} else {
+			err = readDoccanoExamples(&file, entry.Name, reader)
+		}
+		reader.Close()
+		if err != nil {
+			return file, fmt.Errorf("Unable to read %s: %w", entry.Name, err)
+		}
+	}
+
+	return file, nil
+}
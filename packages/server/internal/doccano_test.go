@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestReadDoccanoZip(t *testing.T) {
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"gold.jsonl":        `{"text": "Acme pays", "label": [[0, 4, "Party"]]}`,
+		"user-gold.jsonl":   `{"text": "Acme pays", "label": [[5, 9, "Payment"]]}`,
+		"label_config.json": `[{"id": 1, "text": "Party", "background_color": "#ff0000"}, {"id": 2, "text": "Buyer", "parent": "Party", "value_type": "money"}]`,
+	} {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := readDoccanoRows(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(file.Records) != 2 {
+		t.Fatalf("read %d rows, want 2", len(file.Records))
+	}
+	for _, record := range file.Records {
+		row := record.Value
+		switch row.Label {
+		case "Party":
+			if !row.Gold || row.Annotator != nil {
+				t.Errorf("gold.jsonl read as gold %v by %v, want the gold layer", row.Gold, row.Annotator)
+			}
+		case "Payment":
+			if row.Gold || row.Annotator == nil || *row.Annotator != "gold" {
+				t.Errorf("user-gold.jsonl read as gold %v by %v, want the layer of user gold", row.Gold, row.Annotator)
+			}
+		}
+	}
+
+	if party := file.Topics["Party"]; party.Color != "#ff0000" || party.Parent != "" {
+		t.Errorf("Party read as %+v, want a red root topic", party)
+	}
+	if buyer := file.Topics["Buyer"]; buyer.Parent != "Party" || buyer.ValueType != ValueMoney {
+		t.Errorf("Buyer read as %+v, want a money child of Party", buyer)
+	}
+}
+
+func TestImportDoccanoLabels(t *testing.T) {
+	defer openTestDatabase(t)()
+
+	userID := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('rev', 'hash', '2020-01-01')")
+	documentID := insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "Corp"})
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	i, err := newImporter(tx, DefaultProjectID, documentID, ImportOptions{Format: "doccano", Offsets: OffsetByte, CreateTopics: true}, userID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.labels = map[string]importTopic{
+		"Party": {Topic: Topic{Color: "#ff0000"}},
+		"Buyer": {Topic: Topic{Color: "#00ff00", ValueType: ValueMoney}, Parent: "Party"},
+	}
+
+	err = i.importRecords([]importRecord{{Row: 1, Value: ImportRow{Text: "Acme", Label: "Buyer"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(i.report.CreatedTopics) != 2 {
+		t.Fatalf("created %v, want Party and Buyer", i.report.CreatedTopics)
+	}
+
+	topics, err := queryTopics(tx, DefaultProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := map[string]Topic{}
+	for _, topic := range topics {
+		created[topic.Topic] = topic
+	}
+	party, buyer := created["Party"], created["Buyer"]
+	if party.Color != "#ff0000" || party.ParentID != nil {
+		t.Errorf("created Party as %+v, want a red root topic", party)
+	}
+	if buyer.Color != "#00ff00" || buyer.ValueType != ValueMoney || buyer.ParentID == nil || *buyer.ParentID != party.TopicID {
+		t.Errorf("created Buyer as %+v, want a green money child of Party", buyer)
+	}
+}
+
+func TestDoccanoNotesAndAttributes(t *testing.T) {
+	layer := annotationLayer{Annotations: []Annotation{{AnnotationID: 12, Topic: "Party", CharacterStart: 0, CharacterEnd: 4,
+		Notes: "main party", Attributes: Attributes{"verified": true}}}}
+	example := doccanoExample(DocumentSummary{ID: 1}, "Acme pays", layer, nil, map[uint]string{})
+
+	line, err := json.Marshal(example)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := newImportFile()
+	if err := readDoccanoExamples(&file, "", bytes.NewReader(line)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(file.Records) != 1 || file.Records[0].Err != nil {
+		t.Fatalf("read %+v, want an entity", file.Records)
+	}
+	if row := file.Records[0].Value; row.Notes != "main party" || !reflect.DeepEqual(row.Attributes, Attributes{"verified": true}) {
+		t.Errorf("read notes %q and attributes %v, want them as exported", row.Notes, row.Attributes)
+	}
+}
@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportOptions are the query parameters shared by the exports
+type ExportOptions struct {
+	Layer    string   // "accepted" ignores the gold layers, see exportAnnotations
+	Statuses []string // only the documents in these statuses, all of them when empty
+	TopicIDs []uint   // only the annotations of these topics, all of them when empty
+	// the annotations of every annotator in these statuses instead of the layer, see exportDocumentAnnotations
+	AnnotationStatuses []string
+}
+
+// parseExportOptions reads the layer, status, annotationStatus and topicId query parameters, all of them but layer can
+// be repeated
+func parseExportOptions(query url.Values) (ExportOptions, error) {
+	options := ExportOptions{Layer: query.Get("layer")}
+
+	if options.Layer != "" && options.Layer != "gold" && options.Layer != "accepted" {
+		return options, fmt.Errorf("Invalid layer %q, expected gold or accepted", options.Layer)
+	}
+
+	for _, status := range query["status"] {
+		if !validDocumentStatus(status) {
+			return options, fmt.Errorf("Invalid status %q", status)
+		}
+		options.Statuses = append(options.Statuses, status)
+	}
+
+	for _, status := range query["annotationStatus"] {
+		if !validStatus(status) {
+			return options, fmt.Errorf("Invalid annotationStatus %q", status)
+		}
+		options.AnnotationStatuses = append(options.AnnotationStatuses, status)
+	}
+
+	topicIDs, err := parseIDs(query["topicId"])
+	if err != nil {
+		return options, fmt.Errorf("Invalid topicId: %w", err)
+	}
+	options.TopicIDs = topicIDs
+
+	return options, nil
+}
+
+// exportDocuments lists the processed documents of a project to export
+func exportDocuments(q querier, projectID uint, options ExportOptions) (DocumentSummaries, error) {
+	query := "SELECT document_id, project_id, name, COALESCE(pages, 0), processed, status FROM documents WHERE project_id = ? AND processed"
+	args := []interface{}{projectID}
+
+	if len(options.Statuses) > 0 {
+		var clause string
+		clause, args = inClause("status", options.Statuses, args)
+		query += clause
+	}
+
+	rows, err := q.Query(query+" ORDER BY document_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query documents: %w", err)
+	}
+	defer rows.Close()
+
+	documents := DocumentSummaries{}
+
+	for rows.Next() {
+		var document DocumentSummary
+
+		err = rows.Scan(&document.ID, &document.ProjectID, &document.Name, &document.Pages, &document.Processed, &document.Status)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read document: %w", err)
+		}
+
+		documents = append(documents, document)
+	}
+
+	return documents, rows.Err()
+}
+
+// exportDocumentAnnotations returns the exported annotations of a document, restricted to the topics of the options.
+// With annotation statuses, these are the annotations of every annotator in the statuses, out of the gold layer.
+func exportDocumentAnnotations(q querier, documentID uint, options ExportOptions) ([]Annotation, error) {
+	var annotations []Annotation
+	var err error
+	if len(options.AnnotationStatuses) > 0 {
+		gold := false
+		annotations, err = queryAnnotations(q, AnnotationFilter{DocumentID: documentID, Statuses: options.AnnotationStatuses, Gold: &gold})
+	} else {
+		annotations, err = exportAnnotations(q, documentID, options.Layer)
+	}
+	if err != nil || len(options.TopicIDs) == 0 {
+		return annotations, err
+	}
+
+	topics := map[uint]bool{}
+	for _, topicID := range options.TopicIDs {
+		topics[topicID] = true
+	}
+
+	kept := []Annotation{}
+	for _, annotation := range annotations {
+		if topics[annotation.TopicID] {
+			kept = append(kept, annotation)
+		}
+	}
+
+	return kept, nil
+}
+
+// documentText returns the text of a document, its annotations offsets are byte offsets in it
+func documentText(q querier, documentID uint) (string, error) {
+	var text string
+
+	err := q.QueryRow("SELECT COALESCE(text, '') FROM documents WHERE document_id = ?", documentID).Scan(&text)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read document: %w", err)
+	}
+
+	return text, nil
+}
+
+// exportFileName names the file of a document in an export, prefixed by its ID as names are not unique
+func exportFileName(document DocumentSummary, extension string) string {
+	name := strings.TrimSuffix(document.Name, filepath.Ext(document.Name))
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	return fmt.Sprintf("%d-%s%s", document.ID, name, extension)
+}
+
+// attachment makes the browser download the response as a file
+func attachment(w http.ResponseWriter, fileName, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+}
+
+// zipAttachment writes a zip archive to a temporary file, as page images do not fit in memory, then sends it. Nothing is
+// sent when write fails, the caller reports the error.
+func zipAttachment(w http.ResponseWriter, fileName string, write func(archive *zip.Writer) error) error {
+	file, err := ioutil.TempFile("", "export-*.zip")
+	if err != nil {
+		return fmt.Errorf("Unable to create archive: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	err = write(archive)
+	if err != nil {
+		return err
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return fmt.Errorf("Unable to write archive: %w", err)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("Unable to read archive: %w", err)
+	}
+
+	attachment(w, fileName, "application/zip")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, file)
+
+	return nil
+}
+
+// pageImage returns the image of a page and its file extension
+func pageImage(q querier, documentID, page uint) ([]byte, string, error) {
+	var image []byte
+	var format string
+
+	err := q.QueryRow("SELECT image, image_format FROM document_pages WHERE document_id = ? AND page = ?", documentID, page).Scan(&image, &format)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to read image of page %d: %w", page, err)
+	}
+
+	if format == "" {
+		format = "png"
+	}
+
+	return image, "." + strings.ToLower(format), nil
+}
@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FUNSDWord is a word of a page with its box normalized to 0-1000, as expected by LayoutLM
+type FUNSDWord struct {
+	Text  string  `json:"text"`
+	Box   [4]uint `json:"box"`
+	Label string  `json:"label,omitempty"`
+}
+
+// FUNSDEntity is an annotation on a page, with the words it covers. Linking lists the [from, to] relations of the
+// annotation, by ID. Notes and Attributes are not FUNSD fields, they are kept when the annotation has some.
+type FUNSDEntity struct {
+	ID         uint        `json:"id"`
+	Text       string      `json:"text"`
+	Box        [4]uint     `json:"box"`
+	Label      string      `json:"label"`
+	Words      []FUNSDWord `json:"words"`
+	Linking    [][2]uint   `json:"linking"`
+	Notes      string      `json:"notes,omitempty"`
+	Attributes Attributes  `json:"attributes,omitempty"`
+}
+
+// FUNSDPage is the annotation file of a page
+type FUNSDPage struct {
+	Image      string        `json:"image"`
+	DocumentID uint          `json:"documentId"`
+	Page       uint          `json:"page"`
+	Width      uint          `json:"width"`
+	Height     uint          `json:"height"`
+	Words      []FUNSDWord   `json:"words"`
+	Form       []FUNSDEntity `json:"form"`
+}
+
+// normalizeBox scales a box of a page to 0-1000 as [left, top, right, bottom]
+func normalizeBox(box BoundingBox, width, height uint) [4]uint {
+	scale := func(value, size uint) uint {
+		if size == 0 {
+			return 0
+		}
+		if value > size {
+			value = size
+		}
+		return value * 1000 / size
+	}
+
+	return [4]uint{scale(box.Left, width), scale(box.Top, height), scale(box.Right, width), scale(box.Bottom, height)}
+}
+
+// unionBox returns the smallest box containing both boxes
+func unionBox(a, b [4]uint) [4]uint {
+	min := func(x, y uint) uint {
+		if x < y {
+			return x
+		}
+		return y
+	}
+	max := func(x, y uint) uint {
+		if x > y {
+			return x
+		}
+		return y
+	}
+
+	return [4]uint{min(a[0], b[0]), min(a[1], b[1]), max(a[2], b[2]), max(a[3], b[3])}
+}
+
+// pageWords returns the tokens of a page covering text, in reading order
+func pageWords(text string, page PageTokens) Tokens {
+	words := Tokens{}
+	for _, token := range page.Tokens {
+		if token.CharacterEnd > token.CharacterStart && token.CharacterEnd <= uint(len(text)) {
+			words = append(words, token)
+		}
+	}
+
+	sort.SliceStable(words, func(i, j int) bool { return words[i].CharacterStart < words[j].CharacterStart })
+	return words
+}
+
+// funsdPages builds the annotation files of the pages of a document, the words being labeled with IOB2 tags of the
+// annotations kept by the overlap policy
+func funsdPages(q querier, document DocumentSummary, options CoNLLOptions) ([]FUNSDPage, error) {
+	text, err := documentText(q, document.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := loadDocumentTokens(q, document.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := exportDocumentAnnotations(q, document.ID, options.ExportOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	kept, err := resolveOverlaps(annotations, options.Overlap)
+	if err != nil {
+		return nil, fmt.Errorf("Document %d: %w", document.ID, err)
+	}
+
+	relations, err := queryRelations(q, document.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := map[uint]bool{}
+	for _, annotation := range kept {
+		exported[annotation.AnnotationID] = true
+	}
+
+	linking := map[uint][][2]uint{}
+	for _, relation := range relations {
+		if exported[relation.FromAnnotationID] && exported[relation.ToAnnotationID] {
+			link := [2]uint{relation.FromAnnotationID, relation.ToAnnotationID}
+			linking[relation.FromAnnotationID] = append(linking[relation.FromAnnotationID], link)
+			linking[relation.ToAnnotationID] = append(linking[relation.ToAnnotationID], link)
+		}
+	}
+
+	funsd := []FUNSDPage{}
+	for _, page := range pages {
+		words := pageWords(text, page)
+
+		sentenceStarts := make([]bool, len(words))
+		if len(words) > 0 {
+			sentenceStarts[0] = true
+		}
+		tags := iobTags(words, sentenceStarts, kept)
+
+		funsdPage := FUNSDPage{
+			DocumentID: document.ID,
+			Page:       page.Page,
+			Width:      page.OriginalWidth,
+			Height:     page.OriginalHeight,
+			Words:      []FUNSDWord{},
+			Form:       []FUNSDEntity{},
+		}
+
+		for i, word := range words {
+			funsdPage.Words = append(funsdPage.Words, FUNSDWord{
+				Text:  text[word.CharacterStart:word.CharacterEnd],
+				Box:   normalizeBox(word.BoundingBox, page.OriginalWidth, page.OriginalHeight),
+				Label: tags[i],
+			})
+		}
+
+		for _, annotation := range kept {
+			entity := FUNSDEntity{ID: annotation.AnnotationID, Label: annotation.Topic, Words: []FUNSDWord{}, Linking: [][2]uint{},
+				Notes: annotation.Notes, Attributes: annotation.Attributes}
+			if linking[annotation.AnnotationID] != nil {
+				entity.Linking = linking[annotation.AnnotationID]
+			}
+
+			texts := []string{}
+			for i, word := range words {
+				if word.CharacterEnd <= annotation.CharacterStart || word.CharacterStart >= annotation.CharacterEnd {
+					continue
+				}
+
+				entityWord := FUNSDWord{Text: funsdPage.Words[i].Text, Box: funsdPage.Words[i].Box}
+				if len(entity.Words) == 0 {
+					entity.Box = entityWord.Box
+				} else {
+					entity.Box = unionBox(entity.Box, entityWord.Box)
+				}
+				entity.Words = append(entity.Words, entityWord)
+				texts = append(texts, entityWord.Text)
+			}
+
+			if len(entity.Words) > 0 {
+				entity.Text = strings.Join(texts, " ")
+				funsdPage.Form = append(funsdPage.Form, entity)
+			}
+		}
+
+		funsd = append(funsd, funsdPage)
+	}
+
+	return funsd, nil
+}
+
+// GetFUNSDHandler exports the pages of the documents of a project for layout models, as a zip of the page images and
+// of one FUNSD-like annotation file per page
+func GetFUNSDHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := parseCoNLLOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if options.Overlap == OverlapColumns {
+		http.Error(w, "Invalid overlap \"columns\", expected longest, first or error", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	documents, err := exportDocuments(tx, projectID(r), options.ExportOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = zipAttachment(w, fmt.Sprintf("project-%d-funsd.zip", projectID(r)), func(archive *zip.Writer) error {
+		for _, document := range documents {
+			pages, err := funsdPages(tx, document, options)
+			if err != nil {
+				return err
+			}
+
+			for _, page := range pages {
+				name := exportFileName(document, fmt.Sprintf("-%d", page.Page))
+
+				image, extension, err := pageImage(tx, document.ID, page.Page)
+				if err != nil {
+					return err
+				}
+
+				page.Image = path.Join("images", name+extension)
+				file, err := archive.Create(page.Image)
+				if err != nil {
+					return err
+				}
+				_, err = file.Write(image)
+				if err != nil {
+					return err
+				}
+
+				file, err = archive.Create(path.Join("annotations", name+".json"))
+				if err != nil {
+					return err
+				}
+				err = json.NewEncoder(file).Encode(page)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		conllError(w, err)
+	}
+}
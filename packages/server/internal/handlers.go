@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,8 +10,25 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// GetDocumentsHandler lists the documents of a project, filtered by ?status=
 func GetDocumentsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT document_id, name, COALESCE(pages, 0) AS pages, processed FROM documents")
+	query := "SELECT document_id, project_id, name, COALESCE(pages, 0) AS pages, processed, status FROM documents WHERE project_id = ?"
+	args := []interface{}{projectID(r)}
+
+	if statuses := r.URL.Query()["status"]; len(statuses) > 0 {
+		for _, status := range statuses {
+			if !validDocumentStatus(status) {
+				http.Error(w, fmt.Sprintf("Invalid status %q", status), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var clause string
+		clause, args = inClause("status", statuses, args)
+		query += clause
+	}
+
+	rows, err := db.Query(query+" ORDER BY document_id", args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -22,7 +40,7 @@ func GetDocumentsHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var documentSummary DocumentSummary
 
-		err = rows.Scan(&documentSummary.ID, &documentSummary.Name, &documentSummary.Pages, &documentSummary.Processed)
+		err = rows.Scan(&documentSummary.ID, &documentSummary.ProjectID, &documentSummary.Name, &documentSummary.Pages, &documentSummary.Processed, &documentSummary.Status)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -47,7 +65,8 @@ func GetDocumentHandler(w http.ResponseWriter, r *http.Request) {
 
 	var document Document
 
-	err := db.QueryRow("SELECT document_id, name FROM documents WHERE document_id = ?", documentID).Scan(&document.ID, &document.Name)
+	err := db.QueryRow("SELECT document_id, project_id, name, status FROM documents WHERE document_id = ?", documentID).
+		Scan(&document.ID, &document.ProjectID, &document.Name, &document.Status)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -93,16 +112,41 @@ func DeleteDocumentHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	documentID, _ := strconv.Atoi(params["documentId"])
 
-	_, err := db.Exec("DELETE FROM documents WHERE document_id = ?", documentID)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	document, err := getDocumentSummary(tx, uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Recorded first, the annotations and relations deleted with the document need it to be recorded
+	err = recordDocumentDeletion(tx, currentUser(r).UserID, document)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	_, err = tx.Exec("DELETE FROM documents WHERE document_id = ?", documentID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 
-	Broadcast(`{"type":"documentsChanged"}`)
+	Broadcast(document.ProjectID, `{"type":"documentsChanged"}`)
 }
 
 func PostAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
@@ -116,65 +160,276 @@ func PostAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var text string
-	err = db.QueryRow("SELECT substr(text, ?, ?) FROM documents WHERE document_id = ?",
-		annotation.CharacterStart, annotation.CharacterEnd-annotation.CharacterStart+1, documentID).Scan(&text)
+	if annotation.Status == "" {
+		annotation.Status = StatusAccepted
+	}
+	if annotation.Source == "" {
+		annotation.Source = SourceHuman
+	}
+	if !validStatus(annotation.Status) || !validSource(annotation.Source) {
+		http.Error(w, "Invalid annotation status or source", http.StatusBadRequest)
+		return
+	}
+
+	// Gold annotations only come from adjudication
+	annotation.Gold = false
+	annotation.SourceAnnotationID = nil
+	if annotation.Source != SourceModel {
+		annotation.Model = ""
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	annotationID, err := insertAnnotation(tx, uint(documentID), annotation, currentUser(r).UserID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	_, err = db.Exec(`INSERT INTO annotations (document_id, character_start, character_end, page_start, page_end, text, top_px, left_px, topic_id)
-								    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		documentID, annotation.CharacterStart, annotation.CharacterEnd, annotation.PageStart, annotation.PageEnd,
-		text, annotation.Top, annotation.Left, annotation.TopicID)
 
+	// The created annotation is returned so the annotator sees right away if its value could not be parsed
+	annotation, err = getAnnotation(tx, uint(documentID), annotationID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(annotation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	Broadcast(fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d}`, documentID))
+	BroadcastDocument(uint(documentID), fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d}`, documentID))
 }
 
 func GetAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	documentID, _ := strconv.Atoi(params["documentId"])
 
-	rows, err := db.Query(`SELECT a.annotation_id, a.character_start, a.character_end, a.page_start, a.page_end, a.top_px, a.left_px, t.topic_id, t.topic, a.text
-									       FROM annotations a
-									       INNER JOIN topics t ON t.topic_id = a.topic_id
-									       WHERE a.document_id = ?
-									       ORDER BY a.character_start, a.character_end`, documentID)
+	filter, err := parseAnnotationFilter(uint(documentID), r.URL.Query())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
 
-	annotations := []Annotation{}
+	annotations, err := queryAnnotations(db, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	for rows.Next() {
-		var annotation Annotation
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(annotations)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func PatchAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+	annotationID, _ := strconv.Atoi(params["annotationId"])
+
+	var patch AnnotationPatch
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	annotation, err := getAnnotation(tx, uint(documentID), uint(annotationID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Annotation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The attributes are changed in place, the audit log needs the annotation as it was
+	before, err := getAnnotation(tx, uint(documentID), uint(annotationID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	changes := []string{}
+
+	if patch.TopicID != nil && *patch.TopicID != annotation.TopicID {
+		err = checkDocumentTopic(tx, uint(documentID), *patch.TopicID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		annotation.TopicID = *patch.TopicID
+		changes = append(changes, "topicId")
+	}
+
+	if patch.CharacterStart != nil && *patch.CharacterStart != annotation.CharacterStart {
+		annotation.CharacterStart = *patch.CharacterStart
+		changes = append(changes, "characterStart")
+	}
+
+	if patch.CharacterEnd != nil && *patch.CharacterEnd != annotation.CharacterEnd {
+		annotation.CharacterEnd = *patch.CharacterEnd
+		changes = append(changes, "characterEnd")
+	}
+
+	if patch.Status != nil && *patch.Status != annotation.Status {
+		if !validStatus(*patch.Status) {
+			http.Error(w, "Invalid annotation status", http.StatusBadRequest)
+			return
+		}
 
-		err = rows.Scan(&annotation.AnnotationID, &annotation.CharacterStart, &annotation.CharacterEnd, &annotation.PageStart,
-			&annotation.PageEnd, &annotation.Top, &annotation.Left, &annotation.TopicID, &annotation.Topic, &annotation.Text)
+		// Annotators may change their own annotations, but reviewing them is up to reviewers
+		projectID, err := documentProject(tx, uint(documentID))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		role, err := userRole(tx, currentUser(r), projectID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !hasRole(role, RoleReviewer) {
+			forbidden(w, "the reviewer role is required to accept or reject annotations")
+			return
+		}
+		annotation.Status = *patch.Status
+		changes = append(changes, "status")
+	}
+
+	if patch.Source != nil && *patch.Source != annotation.Source {
+		if !validSource(*patch.Source) {
+			http.Error(w, "Invalid annotation source", http.StatusBadRequest)
+			return
+		}
+		annotation.Source = *patch.Source
+		changes = append(changes, "source")
+	}
 
-		annotations = append(annotations, annotation)
+	if patch.Confidence != nil && (annotation.Confidence == nil || *patch.Confidence != *annotation.Confidence) {
+		annotation.Confidence = patch.Confidence
+		changes = append(changes, "confidence")
+	}
+
+	if patch.Notes != nil && *patch.Notes != annotation.Notes {
+		annotation.Notes = *patch.Notes
+		changes = append(changes, "notes")
+	}
+
+	if len(patch.Attributes) > 0 {
+		for key, value := range patch.Attributes {
+			if value == nil {
+				delete(annotation.Attributes, key)
+			} else {
+				annotation.Attributes[key] = value
+			}
+		}
+		changes = append(changes, "attributes")
+	}
+
+	attributes, err := marshalAttributes(annotation.Attributes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Text and position follow the boundaries, a span covering no token has no position
+	if annotation.CharacterStart != before.CharacterStart || annotation.CharacterEnd != before.CharacterEnd {
+		annotation.Text, err = spanText(tx, uint(documentID), annotation.CharacterStart, annotation.CharacterEnd)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		location, err := spanPosition(tx, uint(documentID), annotation.CharacterStart, annotation.CharacterEnd)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		annotation.PageStart = location.PageStart
+		annotation.PageEnd = location.PageEnd
+		annotation.Top = location.Top
+		annotation.Left = location.Left
+	}
+
+	value, valueError, err := normalizeAnnotation(tx, annotation.TopicID, annotation.Text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE annotations SET character_start = ?, character_end = ?, page_start = ?, page_end = ?, text = ?, top_px = ?, left_px = ?,
+								topic_id = ?, status = ?, source = ?, confidence = ?, notes = ?, attributes = ?, value = ?, value_error = ?
+								WHERE annotation_id = ?`,
+		annotation.CharacterStart, annotation.CharacterEnd, annotation.PageStart, annotation.PageEnd, annotation.Text,
+		annotation.Top, annotation.Left, annotation.TopicID, annotation.Status, annotation.Source, annotation.Confidence,
+		annotation.Notes, attributes, value, valueError, annotation.AnnotationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Reload to get the name of the new topic
+	annotation, err = getAnnotation(tx, uint(documentID), uint(annotationID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = recordAnnotationChange(tx, currentUser(r).UserID, uint(documentID), &before, &annotation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(annotations)
+	err = json.NewEncoder(w).Encode(annotation)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	message, _ := json.Marshal(struct {
+		Type       string     `json:"type"`
+		DocumentID uint       `json:"documentId"`
+		Changes    []string   `json:"changes"`
+		Annotation Annotation `json:"annotation"`
+	}{"annotationsChanged", uint(documentID), changes, annotation})
+	BroadcastDocument(uint(documentID), string(message))
 }
 
 func DeleteAnnotationHandler(w http.ResponseWriter, r *http.Request) {
@@ -182,16 +437,201 @@ func DeleteAnnotationHandler(w http.ResponseWriter, r *http.Request) {
 	documentID, _ := strconv.Atoi(params["documentId"])
 	annotationID, _ := strconv.Atoi(params["annotationId"])
 
-	_, err := db.Exec("DELETE FROM annotations WHERE document_id = ? AND annotation_id = ?", documentID, annotationID)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
+	annotation, err := getAnnotation(tx, uint(documentID), uint(annotationID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Annotation not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	relations, err := queryRelations(tx, uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec("DELETE FROM annotations WHERE document_id = ? AND annotation_id = ?", documentID, annotationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Kept in the audit log with the relations deleted along with it, the deletion can be undone
+	err = recordAnnotationDeletions(tx, currentUser(r).UserID, uint(documentID), []Annotation{annotation}, relations, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 
-	Broadcast(fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d}`, documentID))
+	BroadcastDocument(uint(documentID), fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d}`, documentID))
+}
+
+func broadcastReview(documentID uint, status string, annotationIDs []uint) {
+	ids, _ := json.Marshal(annotationIDs)
+	BroadcastDocument(documentID, fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d, "status":%q, "annotationIds":%s}`, documentID, status, ids))
+}
+
+// ReviewAnnotationHandler sets the status of a single annotation
+func ReviewAnnotationHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		documentID, _ := strconv.Atoi(params["documentId"])
+		annotationID, _ := strconv.Atoi(params["annotationId"])
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		before, err := getAnnotation(tx, uint(documentID), uint(annotationID))
+		if err == sql.ErrNoRows {
+			http.Error(w, "Annotation not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, err = tx.Exec("UPDATE annotations SET status = ? WHERE document_id = ? AND annotation_id = ?", status, documentID, annotationID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		after := before
+		after.Status = status
+		err = recordAnnotationChange(tx, currentUser(r).UserID, uint(documentID), &before, &after)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		broadcastReview(uint(documentID), status, []uint{uint(annotationID)})
+	}
+}
+
+// ReviewAnnotationsHandler sets the status of several annotations of a document at once
+func ReviewAnnotationsHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		documentID, _ := strconv.Atoi(params["documentId"])
+
+		var review AnnotationReview
+		err := json.NewDecoder(r.Body).Decode(&review)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		filter := AnnotationFilter{DocumentID: uint(documentID), MinConfidence: review.MinConfidence}
+		if len(review.AnnotationIDs) == 0 {
+			filter.Statuses = []string{StatusSuggested}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		annotations, err := queryAnnotations(tx, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		requested := map[uint]bool{}
+		for _, annotationID := range review.AnnotationIDs {
+			requested[annotationID] = true
+		}
+
+		reviewed := []uint{}
+		for _, annotation := range annotations {
+			if len(requested) > 0 && !requested[annotation.AnnotationID] {
+				continue
+			}
+
+			_, err = tx.Exec("UPDATE annotations SET status = ? WHERE annotation_id = ?", status, annotation.AnnotationID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			after := annotation
+			after.Status = status
+			err = recordAnnotationChange(tx, currentUser(r).UserID, uint(documentID), &annotation, &after)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			reviewed = append(reviewed, annotation.AnnotationID)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(AnnotationReview{AnnotationIDs: reviewed})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		broadcastReview(uint(documentID), status, reviewed)
+	}
+}
+
+func PostPredictHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	if predictor == nil {
+		http.Error(w, "No prediction backend configured", http.StatusNotImplemented)
+		return
+	}
+
+	err := PredictDocument(uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
 func GetTokensHandler(w http.ResponseWriter, r *http.Request) {
@@ -236,71 +676,313 @@ func GetImageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetTopicsHandler returns the topics nested under their parent, or as a flat list with ?flat=true
 func GetTopicsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT topic_id, topic FROM topics")
+	topics, err := queryTopics(db, projectID(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
 
-	topics := Topics{}
+	if r.URL.Query().Get("flat") != "true" {
+		topics = topicTree(topics)
+	}
 
-	for rows.Next() {
-		var topic Topic
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(topics)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func PostTopicsHandler(w http.ResponseWriter, r *http.Request) {
+	var topic Topic
+	err := json.NewDecoder(r.Body).Decode(&topic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		err = rows.Scan(&topic.TopicID, &topic.Topic)
+	err = validateTopic(topic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	topic.ProjectID = projectID(r)
+
+	if topic.ParentID != nil {
+		err = checkTopicsProject(db, topic.ProjectID, *topic.ParentID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+	}
 
-		topics = append(topics, topic)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = insertTopic(tx, topic, currentUser(r).UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(topics)
+
+	Broadcast(topic.ProjectID, `{"type":"topicsChanged"}`)
+}
+
+func PatchTopicHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	topicID, _ := strconv.Atoi(params["topicId"])
+
+	var patch TopicPatch
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	topic, err := getTopic(tx, uint(topicID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before := topic
+
+	if patch.Topic != nil {
+		topic.Topic = *patch.Topic
+	}
+	if patch.Color != nil {
+		topic.Color = *patch.Color
+	}
+	if patch.Description != nil {
+		topic.Description = *patch.Description
+	}
+	if patch.Shortcut != nil {
+		topic.Shortcut = *patch.Shortcut
+	}
+	if patch.ValueType != nil {
+		topic.ValueType = *patch.ValueType
+	}
+	if patch.EnumValues != nil {
+		topic.EnumValues = *patch.EnumValues
+	}
+	if patch.ParentID != nil {
+		topic.ParentID = nil
+		if *patch.ParentID != 0 {
+			err = checkTopicsProject(tx, topic.ProjectID, *patch.ParentID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = checkTopicParent(tx, topic.TopicID, *patch.ParentID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			topic.ParentID = patch.ParentID
+		}
+	}
+
+	err = validateTopic(topic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	enumValues, err := marshalEnumValues(topic.EnumValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec("UPDATE topics SET topic = ?, parent_topic_id = ?, color = ?, description = ?, shortcut = ?, value_type = ?, enum_values = ? WHERE topic_id = ?",
+		topic.Topic, topic.ParentID, topic.Color, topic.Description, nullString(topic.Shortcut), topic.ValueType, enumValues, topic.TopicID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	causeID, err := recordChange(tx, topicEntry(&before, &topic), currentUser(r).UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The annotations parsed again are recorded as changed by the topic, so that undoing it restores their values
+	if causeID != 0 && (patch.ValueType != nil || patch.EnumValues != nil) {
+		updated, err := normalizeTopicAnnotations(tx, topic.TopicID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = recordAnnotationUpdates(tx, currentUser(r).UserID, updated, &causeID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Annotations show the topic name, documents using it need to refresh after a rename
+	documentIDs, err := topicDocuments(tx, topic.TopicID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	broadcastTopicChange(topic.ProjectID, documentIDs)
 }
 
-func PostTopicsHandler(w http.ResponseWriter, r *http.Request) {
-	var topic Topic
-	err := json.NewDecoder(r.Body).Decode(&topic)
+func MergeTopicHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	topicID, _ := strconv.Atoi(params["topicId"])
+
+	var merge TopicMerge
+	err := json.NewDecoder(r.Body).Decode(&merge)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	_, err = db.Exec("INSERT INTO topics (topic) VALUES (?)", topic.Topic)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	projectID, err := topicProject(tx, uint(topicID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	documentIDs, err := topicDocuments(tx, uint(topicID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	err = mergeTopic(tx, uint(topicID), merge.IntoTopicID, currentUser(r).UserID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 
-	Broadcast(`{"type":"topicsChanged"}`)
+	broadcastTopicChange(projectID, documentIDs)
 }
 
+// DeleteTopicHandler refuses to delete a topic still used by annotations, unless they are
+// moved to another topic with ?reassign={topicId} or deleted along with it with ?force=true
 func DeleteTopicHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	topicID, _ := strconv.Atoi(params["topicId"])
+	query := r.URL.Query()
 
-	_, err := db.Exec("DELETE FROM topics WHERE topic_id = ?", topicID)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
+	projectID, err := topicProject(tx, uint(topicID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	documentIDs, err := topicDocuments(tx, uint(topicID))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if reassign := query.Get("reassign"); reassign != "" {
+		intoTopicID, err := strconv.Atoi(reassign)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = mergeTopic(tx, uint(topicID), uint(intoTopicID), currentUser(r).UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if len(documentIDs) > 0 && query.Get("force") != "true" {
+			var annotations uint
+			err = tx.QueryRow("SELECT COUNT(*) FROM annotations WHERE topic_id = ?", topicID).Scan(&annotations)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			http.Error(w, fmt.Sprintf("Topic is used by %d annotations in %d documents, reassign them or force the deletion", annotations, len(documentIDs)), http.StatusConflict)
+			return
+		}
+
+		err = deleteTopic(tx, uint(topicID), currentUser(r).UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 
-	Broadcast(`{"type":"topicsChanged"}`)
+	broadcastTopicChange(projectID, documentIDs)
 }
 
 func IndexHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,803 @@
+package internal
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Outcomes of the rows of an import
+const (
+	ImportAccepted = "accepted"
+	ImportRejected = "rejected"
+)
+
+// ImportRow is a span to import, located by its offsets or by its text. A span found by its text is its occurrence-th
+// match in the document, the first one by default. Its topic is given by ID or by name.
+type ImportRow struct {
+	DocumentID   uint       `json:"documentId"`
+	Document     string     `json:"document"` // name of the document, when its ID is unknown
+	Start        *uint      `json:"start"`
+	End          *uint      `json:"end"`
+	Text         string     `json:"text"`
+	Occurrence   uint       `json:"occurrence"`
+	TopicID      uint       `json:"topicId"`
+	Label        string     `json:"label"`
+	Status       string     `json:"status"`
+	Source       string     `json:"source"`
+	Notes        string     `json:"notes"`
+	Attributes   Attributes `json:"attributes"`
+	Confidence   *float64   `json:"confidence"`
+	Model        string     `json:"model"`
+	Annotator    *string    `json:"-"` // username of the annotator, the importing user when nil, nobody when empty
+	Gold         bool       `json:"-"` // only imported from the formats exporting the gold layer
+	DocumentText string     `json:"-"` // text of the document, when neither its ID nor its name are known
+}
+
+// ImportResult tells whether a row was imported, Row being its line in JSONL and BRAT and its record after the header
+// in CSV
+type ImportResult struct {
+	File         string `json:"file,omitempty"` // file of the row in a zip
+	Row          int    `json:"row"`
+	Status       string `json:"status"`
+	DocumentID   uint   `json:"documentId,omitempty"`
+	AnnotationID uint   `json:"annotationId,omitempty"`
+	RelationID   uint   `json:"relationId,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// ImportReport is the outcome of an import
+type ImportReport struct {
+	Accepted      int            `json:"accepted"`
+	Rejected      int            `json:"rejected"`
+	CreatedTopics []string       `json:"createdTopics"`
+	Rows          []ImportResult `json:"rows"`
+}
+
+// ImportOptions are the query parameters of the imports
+type ImportOptions struct {
+	Format       string // csv, jsonl, brat, labelstudio or doccano
+	Offsets      string // unit of the start and end of the rows, as in the JSONL export, defaulting to the unit of the format
+	CreateTopics bool   // create the topics missing from the project instead of rejecting their rows
+}
+
+// importRecord is a row as read, or why it could not be read
+type importRecord struct {
+	File  string
+	Row   int
+	Value ImportRow
+	Err   error
+}
+
+// importRelation links two annotations of an import by their IDs in the imported file
+type importRelation struct {
+	File string
+	Row  int
+	Type string
+	From string
+	To   string
+}
+
+// importTopic describes a topic of an import file, applied when the import creates it. Parent is the name of its
+// parent topic.
+type importTopic struct {
+	Topic
+	Parent string
+}
+
+// importFile holds the rows of an import, and the relations between them for the formats which have some. Entities
+// maps the IDs of the annotations in the file to the indexes of their rows, Topics describes its labels by name for the
+// formats which export the taxonomy.
+type importFile struct {
+	Records   []importRecord
+	Entities  map[string][]int
+	Relations []importRelation
+	Topics    map[string]importTopic
+}
+
+func newImportFile() importFile {
+	return importFile{Records: []importRecord{}, Entities: map[string][]int{}, Topics: map[string]importTopic{}}
+}
+
+// attributesOthers tells whether rows of the file are gold or attributed to someone else than the importing user
+func (f importFile) attributesOthers(username string) bool {
+	for _, record := range f.Records {
+		if record.Err == nil && (record.Value.Gold || record.Value.Annotator != nil && *record.Value.Annotator != username) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseImportOptions(r *http.Request) (ImportOptions, error) {
+	query := r.URL.Query()
+	options := ImportOptions{Format: query.Get("format"), Offsets: query.Get("offsets"), CreateTopics: query.Get("createTopics") == "true"}
+
+	if options.Format == "" {
+		options.Format = "jsonl"
+		if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+			options.Format = "csv"
+		}
+	}
+	if options.Format != "csv" && options.Format != "jsonl" && options.Format != "brat" && options.Format != "labelstudio" && options.Format != "doccano" {
+		return options, fmt.Errorf("Invalid format %q, expected csv, jsonl, brat, labelstudio or doccano", options.Format)
+	}
+
+	if options.Offsets == "" {
+		switch options.Format {
+		case "brat", "doccano":
+			options.Offsets = OffsetChar
+		case "labelstudio":
+			options.Offsets = OffsetUTF16
+		default:
+			options.Offsets = OffsetByte
+		}
+	}
+	if options.Offsets != OffsetByte && options.Offsets != OffsetChar && options.Offsets != OffsetUTF16 {
+		return options, fmt.Errorf("Invalid offsets %q, expected byte, char or utf16", options.Offsets)
+	}
+
+	return options, nil
+}
+
+// readJSONLRows reads a JSON object per line, skipping empty lines
+func readJSONLRows(body io.Reader) ([]importRecord, error) {
+	records := []importRecord{}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		record := importRecord{Row: line}
+		err := json.Unmarshal(scanner.Bytes(), &record.Value)
+		if err != nil {
+			record.Err = fmt.Errorf("Invalid JSON: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// readCSVRows reads CSV records named by a header of ImportRow fields, attributes being unsupported
+func readCSVRows(body io.Reader) ([]importRecord, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read CSV header: %w", err)
+	}
+
+	// Spreadsheets save UTF-8 CSV files with a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	known := map[string]bool{"documentId": true, "document": true, "start": true, "end": true, "text": true, "occurrence": true,
+		"topicId": true, "label": true, "status": true, "source": true, "notes": true}
+	for _, column := range header {
+		if !known[column] {
+			return nil, fmt.Errorf("Unknown CSV column %q", column)
+		}
+	}
+
+	records := []importRecord{}
+	for row := 1; ; row++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		record := importRecord{Row: row}
+		if err != nil {
+			var parseError *csv.ParseError
+			if !errors.As(err, &parseError) {
+				return nil, err
+			}
+			record.Err = err
+			records = append(records, record)
+			continue
+		}
+
+		record.Value, record.Err = csvRow(header, fields)
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func csvRow(header, fields []string) (ImportRow, error) {
+	var row ImportRow
+
+	number := func(column, field string) (uint, error) {
+		n, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid %s %q", column, field)
+		}
+		return uint(n), nil
+	}
+
+	for i, field := range fields {
+		if i >= len(header) {
+			return row, fmt.Errorf("Too many fields, expected %d", len(header))
+		}
+		if field == "" {
+			continue
+		}
+
+		var err error
+		var n uint
+		switch header[i] {
+		case "documentId":
+			row.DocumentID, err = number(header[i], field)
+		case "document":
+			row.Document = field
+		case "start":
+			n, err = number(header[i], field)
+			row.Start = &n
+		case "end":
+			n, err = number(header[i], field)
+			row.End = &n
+		case "text":
+			row.Text = field
+		case "occurrence":
+			row.Occurrence, err = number(header[i], field)
+		case "topicId":
+			row.TopicID, err = number(header[i], field)
+		case "label":
+			row.Label = field
+		case "status":
+			row.Status = field
+		case "source":
+			row.Source = field
+		case "notes":
+			row.Notes = field
+		}
+		if err != nil {
+			return row, err
+		}
+	}
+
+	return row, nil
+}
+
+// importer resolves the documents and topics of the rows of an import into a project
+type importer struct {
+	tx         *sql.Tx
+	projectID  uint
+	documentID uint // the document of every row, 0 for an import into the whole project
+	options    ImportOptions
+	userID     uint
+	reviewer   bool // whether the importing user may set the status and source of the rows
+	texts      map[uint]string
+	documents  map[string]uint // documents by text
+	users      map[string]uint // users by username
+	topics     map[string]uint
+	topicIDs   map[uint]bool
+	labels     map[string]importTopic // topics described by the import file
+	created    []Topic                // topics created by the current row, kept once the row is imported
+	report     ImportReport
+}
+
+func newImporter(tx *sql.Tx, projectID, documentID uint, options ImportOptions, userID uint, reviewer bool) (*importer, error) {
+	topics, err := queryTopics(tx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := queryUsers(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &importer{
+		tx:         tx,
+		projectID:  projectID,
+		documentID: documentID,
+		options:    options,
+		userID:     userID,
+		reviewer:   reviewer,
+		texts:      map[uint]string{},
+		documents:  map[string]uint{},
+		users:      map[string]uint{},
+		topics:     map[string]uint{},
+		topicIDs:   map[uint]bool{},
+		labels:     map[string]importTopic{},
+		report:     ImportReport{CreatedTopics: []string{}, Rows: []ImportResult{}},
+	}
+
+	// BRAT labels cannot contain spaces, the export replaces them with underscores
+	if options.Format == "brat" {
+		for _, topic := range topics {
+			i.topics[conllLabel(topic.Topic)] = topic.TopicID
+		}
+	}
+
+	for _, topic := range topics {
+		i.topics[topic.Topic] = topic.TopicID
+		i.topicIDs[topic.TopicID] = true
+	}
+
+	for _, user := range users {
+		i.users[user.Username] = user.UserID
+	}
+
+	return i, nil
+}
+
+// rowDocument returns the document of a row and its text
+func (i *importer) rowDocument(row ImportRow) (uint, string, error) {
+	documentID := row.DocumentID
+
+	if i.documentID != 0 {
+		if documentID != 0 && documentID != i.documentID {
+			return 0, "", fmt.Errorf("Row of document %d imported into document %d", documentID, i.documentID)
+		}
+		documentID = i.documentID
+	} else if documentID == 0 && row.Document == "" && row.DocumentText != "" {
+		var ok bool
+		documentID, ok = i.documents[row.DocumentText]
+		if !ok {
+			err := i.tx.QueryRow("SELECT COALESCE(MIN(document_id), 0) FROM documents WHERE project_id = ? AND text = ?", i.projectID, row.DocumentText).
+				Scan(&documentID)
+			if err != nil {
+				return 0, "", fmt.Errorf("Unable to find document: %w", err)
+			}
+			i.documents[row.DocumentText] = documentID
+		}
+		if documentID == 0 {
+			return 0, "", fmt.Errorf("No document has the text of the row")
+		}
+	} else if documentID == 0 {
+		if row.Document == "" {
+			return 0, "", fmt.Errorf("A documentId or document name is required")
+		}
+
+		var count uint
+		err := i.tx.QueryRow("SELECT COUNT(*), COALESCE(MIN(document_id), 0) FROM documents WHERE project_id = ? AND name = ?", i.projectID, row.Document).
+			Scan(&count, &documentID)
+		if err != nil {
+			return 0, "", fmt.Errorf("Unable to find document %q: %w", row.Document, err)
+		}
+		if count == 0 {
+			return 0, "", fmt.Errorf("No document named %q", row.Document)
+		}
+		if count > 1 {
+			return 0, "", fmt.Errorf("%d documents are named %q, use documentId", count, row.Document)
+		}
+	}
+
+	if text, ok := i.texts[documentID]; ok {
+		return documentID, text, nil
+	}
+
+	projectID, err := documentProject(i.tx, documentID)
+	if err != nil {
+		return 0, "", err
+	}
+	if projectID != i.projectID {
+		return 0, "", fmt.Errorf("Document %d does not belong to project %d", documentID, i.projectID)
+	}
+
+	text, err := documentText(i.tx, documentID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	i.texts[documentID] = text
+	return documentID, text, nil
+}
+
+// rowTopic returns the topic of a row, creating it when allowed
+func (i *importer) rowTopic(row ImportRow) (uint, error) {
+	if row.TopicID != 0 {
+		if !i.topicIDs[row.TopicID] {
+			return 0, fmt.Errorf("Topic %d does not belong to project %d", row.TopicID, i.projectID)
+		}
+		return row.TopicID, nil
+	}
+
+	if row.Label == "" {
+		return 0, fmt.Errorf("A topicId or label is required")
+	}
+
+	return i.labelTopic(row.Label)
+}
+
+// labelTopic returns the topic named label, creating it and its parents as the import file describes them when allowed
+func (i *importer) labelTopic(label string) (uint, error) {
+	if topicID, ok := i.topics[label]; ok {
+		return topicID, nil
+	}
+
+	for _, topic := range i.created {
+		if topic.Topic == label {
+			return topic.TopicID, nil
+		}
+	}
+
+	if !i.options.CreateTopics {
+		return 0, fmt.Errorf("Unknown topic %q", label)
+	}
+
+	described := i.labels[label]
+	topic := Topic{ProjectID: i.projectID, Topic: label, Color: described.Color, Description: described.Description,
+		ValueType: described.ValueType, EnumValues: described.EnumValues}
+	if described.Parent != "" {
+		if described.Parent == label {
+			return 0, fmt.Errorf("Topic %q cannot be its own parent", label)
+		}
+
+		parentID, err := i.labelTopic(described.Parent)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to create the parent of %q: %w", label, err)
+		}
+		topic.ParentID = &parentID
+	}
+
+	err := validateTopic(topic)
+	if err != nil {
+		return 0, err
+	}
+
+	topic, err = insertTopic(i.tx, topic, i.userID)
+	if err != nil {
+		return 0, err
+	}
+
+	i.created = append(i.created, topic)
+	return topic.TopicID, nil
+}
+
+// rowSpan returns the byte offsets of the span of a row in the text of its document
+func (i *importer) rowSpan(row ImportRow, text string) (uint, uint, error) {
+	if row.Start != nil && row.End != nil {
+		toBytes := byteOffsetConverter(text, i.options.Offsets)
+		start, startOK := toBytes(*row.Start)
+		end, endOK := toBytes(*row.End)
+		if !startOK || !endOK || start >= end {
+			return 0, 0, fmt.Errorf("Invalid span %d-%d for the document text", *row.Start, *row.End)
+		}
+
+		if row.Text != "" && text[start:end] != row.Text {
+			return 0, 0, fmt.Errorf("Text %q does not match %q at %d-%d", row.Text, text[start:end], *row.Start, *row.End)
+		}
+
+		return start, end, nil
+	}
+
+	if row.Start != nil || row.End != nil {
+		return 0, 0, fmt.Errorf("Both start and end are required")
+	}
+
+	if row.Text == "" {
+		return 0, 0, fmt.Errorf("Either start and end or text is required")
+	}
+
+	occurrence := row.Occurrence
+	if occurrence == 0 {
+		occurrence = 1
+	}
+
+	offset := 0
+	for n := uint(1); ; n++ {
+		index := strings.Index(text[offset:], row.Text)
+		if index < 0 {
+			return 0, 0, fmt.Errorf("Occurrence %d of %q not found", occurrence, row.Text)
+		}
+
+		start := offset + index
+		if n == occurrence {
+			return uint(start), uint(start + len(row.Text)), nil
+		}
+		offset = start + 1
+	}
+}
+
+// importRow validates a row and stores its annotation
+func (i *importer) importRow(row ImportRow) (uint, uint, error) {
+	documentID, text, err := i.rowDocument(row)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start, end, err := i.rowSpan(row, text)
+	if err != nil {
+		return documentID, 0, err
+	}
+
+	// Imported annotations are suggestions to review, unless a reviewer imports them with their status. Gold
+	// annotations are accepted, as when adjudicating.
+	annotation := Annotation{CharacterStart: start, CharacterEnd: end, Status: StatusSuggested, Source: SourceHuman, Notes: row.Notes,
+		Attributes: row.Attributes, Confidence: row.Confidence, Gold: row.Gold}
+	if row.Gold {
+		annotation.Status = StatusAccepted
+	}
+	if i.reviewer && row.Status != "" {
+		annotation.Status = row.Status
+	}
+	if i.reviewer && row.Source != "" {
+		annotation.Source = row.Source
+	}
+	if annotation.Source == SourceModel {
+		annotation.Model = row.Model
+	}
+	if !validStatus(annotation.Status) || !validSource(annotation.Source) {
+		return documentID, 0, fmt.Errorf("Invalid annotation status or source")
+	}
+
+	annotation.TopicID, err = i.rowTopic(row)
+	if err != nil {
+		return documentID, 0, err
+	}
+
+	userID := i.userID
+	if row.Annotator != nil {
+		userID = 0
+		if *row.Annotator != "" {
+			var ok bool
+			userID, ok = i.users[*row.Annotator]
+			if !ok {
+				return documentID, 0, fmt.Errorf("Unknown annotator %q", *row.Annotator)
+			}
+		}
+	}
+
+	annotationID, err := insertAnnotation(i.tx, documentID, annotation, userID)
+	return documentID, annotationID, err
+}
+
+// importRecords imports each row in a savepoint, so a rejected row leaves nothing behind
+func (i *importer) importRecords(records []importRecord) error {
+	for _, record := range records {
+		result := ImportResult{File: record.File, Row: record.Row, Status: ImportRejected}
+
+		err := record.Err
+		if err == nil {
+			_, err = i.tx.Exec("SAVEPOINT import_row")
+			if err != nil {
+				return fmt.Errorf("Unable to create savepoint: %w", err)
+			}
+
+			i.created = []Topic{}
+			result.DocumentID, result.AnnotationID, err = i.importRow(record.Value)
+			if err != nil {
+				_, rollbackErr := i.tx.Exec("ROLLBACK TO import_row")
+				if rollbackErr != nil {
+					return fmt.Errorf("Unable to rollback row %d: %w", record.Row, rollbackErr)
+				}
+			} else {
+				for _, topic := range i.created {
+					i.topics[topic.Topic] = topic.TopicID
+					i.topicIDs[topic.TopicID] = true
+					i.report.CreatedTopics = append(i.report.CreatedTopics, topic.Topic)
+				}
+			}
+
+			_, releaseErr := i.tx.Exec("RELEASE import_row")
+			if releaseErr != nil {
+				return fmt.Errorf("Unable to release savepoint: %w", releaseErr)
+			}
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+			i.report.Rejected++
+		} else {
+			result.Status = ImportAccepted
+			i.report.Accepted++
+		}
+		i.report.Rows = append(i.report.Rows, result)
+	}
+
+	return nil
+}
+
+// importRelations links the imported annotations, an entity imported as several annotations being represented by its
+// first one
+func (i *importer) importRelations(file importFile) error {
+	if len(file.Relations) == 0 {
+		return nil
+	}
+
+	relationTypes, err := queryRelationTypes(i.tx, i.projectID)
+	if err != nil {
+		return err
+	}
+
+	// Labels of formats which cannot contain spaces have underscores instead
+	typeIDs := map[string]uint{}
+	for _, relationType := range relationTypes {
+		typeIDs[conllLabel(relationType.RelationType)] = relationType.RelationTypeID
+	}
+	for _, relationType := range relationTypes {
+		typeIDs[relationType.RelationType] = relationType.RelationTypeID
+	}
+
+	// the results of the records, in the same order
+	results := append([]ImportResult{}, i.report.Rows...)
+
+	annotation := func(id string) (ImportResult, error) {
+		for _, index := range file.Entities[id] {
+			if results[index].Status == ImportAccepted {
+				return results[index], nil
+			}
+		}
+		return ImportResult{}, fmt.Errorf("Annotation %s was not imported", id)
+	}
+
+	for _, importRelation := range file.Relations {
+		result := ImportResult{File: importRelation.File, Row: importRelation.Row, Status: ImportRejected}
+
+		var from, to ImportResult
+		relation := Relation{RelationTypeID: typeIDs[importRelation.Type]}
+		from, err = annotation(importRelation.From)
+		if err == nil {
+			to, err = annotation(importRelation.To)
+		}
+		if err == nil && relation.RelationTypeID == 0 {
+			err = fmt.Errorf("Unknown relation type %q", importRelation.Type)
+		}
+		if err == nil {
+			result.DocumentID = from.DocumentID
+			relation.FromAnnotationID, relation.ToAnnotationID = from.AnnotationID, to.AnnotationID
+			err = checkRelationEnds(i.tx, from.DocumentID, relation)
+		}
+		if err == nil {
+			var id int64
+			id, err = insertRelation(i.tx, from.DocumentID, relation, i.userID)
+			result.RelationID = uint(id)
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+			i.report.Rejected++
+		} else {
+			result.Status = ImportAccepted
+			i.report.Accepted++
+		}
+		i.report.Rows = append(i.report.Rows, result)
+	}
+
+	sort.SliceStable(i.report.Rows, func(a, b int) bool {
+		if i.report.Rows[a].File != i.report.Rows[b].File {
+			return i.report.Rows[a].File < i.report.Rows[b].File
+		}
+		return i.report.Rows[a].Row < i.report.Rows[b].Row
+	})
+	return nil
+}
+
+func importAnnotations(w http.ResponseWriter, r *http.Request, projectID, documentID uint) {
+	options, err := parseImportOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	role, err := userRole(db, currentUser(r), projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if options.CreateTopics && !hasRole(role, RoleReviewer) {
+		forbidden(w, "the reviewer role is required to create topics")
+		return
+	}
+
+	if options.Format == "brat" && documentID == 0 {
+		http.Error(w, "BRAT files are imported into a document", http.StatusBadRequest)
+		return
+	}
+
+	file := newImportFile()
+	switch options.Format {
+	case "csv":
+		file.Records, err = readCSVRows(r.Body)
+	case "brat":
+		file, err = readBratRows(r.Body)
+	case "labelstudio":
+		file, err = readLabelStudioRows(r.Body)
+	case "doccano":
+		file, err = readDoccanoRows(r.Body)
+	default:
+		file.Records, err = readJSONLRows(r.Body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if file.attributesOthers(currentUser(r).Username) && !hasRole(role, RoleReviewer) {
+		forbidden(w, "the reviewer role is required to import gold annotations or the annotations of other annotators")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	i, err := newImporter(tx, projectID, documentID, options, currentUser(r).UserID, hasRole(role, RoleReviewer))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	i.labels = file.Topics
+
+	err = i.importRecords(file.Records)
+	if err == nil {
+		err = i.importRelations(file)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(i.report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(i.report.CreatedTopics) > 0 {
+		Broadcast(projectID, `{"type":"topicsChanged"}`)
+	}
+
+	changed := map[uint]bool{}
+	for _, result := range i.report.Rows {
+		if result.Status == ImportAccepted && !changed[result.DocumentID] {
+			changed[result.DocumentID] = true
+			BroadcastDocument(result.DocumentID, fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d}`, result.DocumentID))
+		}
+		if result.RelationID != 0 {
+			BroadcastDocument(result.DocumentID, fmt.Sprintf(`{"type":"relationsChanged", "documentId":%d}`, result.DocumentID))
+		}
+	}
+}
+
+// PostDocumentImportHandler imports annotations into a document from JSONL or CSV rows, reporting which rows were
+// accepted or rejected
+func PostDocumentImportHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	projectID, err := documentProject(db, uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	importAnnotations(w, r, projectID, uint(documentID))
+}
+
+// PostImportHandler imports annotations into the documents of a project, the rows naming their document
+func PostImportHandler(w http.ResponseWriter, r *http.Request) {
+	importAnnotations(w, r, projectID(r), 0)
+}
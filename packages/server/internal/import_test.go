@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadCSVRowsByteOrderMark(t *testing.T) {
+	records, err := readCSVRows(strings.NewReader("\ufeffdocumentId,text,label\r\n1,Acme,Party\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 1 || records[0].Err != nil {
+		t.Fatalf("read %+v, want a row", records)
+	}
+	if row := records[0].Value; row.DocumentID != 1 || row.Text != "Acme" || row.Label != "Party" {
+		t.Errorf("read %+v, want the row of document 1", row)
+	}
+}
+
+func TestImportRowStatus(t *testing.T) {
+	defer openTestDatabase(t)()
+
+	userID := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('ann', 'hash', '2020-01-01')")
+	mustExec(t, "INSERT INTO topics (topic) VALUES ('Party')")
+	documentID := insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "Corp"})
+
+	tests := []struct {
+		name     string
+		reviewer bool
+		row      ImportRow
+		status   string
+		source   string
+	}{
+		{"annotator default", false, ImportRow{}, StatusSuggested, SourceHuman},
+		{"annotator status ignored", false, ImportRow{Status: StatusAccepted, Source: SourceModel}, StatusSuggested, SourceHuman},
+		{"reviewer default", true, ImportRow{}, StatusSuggested, SourceHuman},
+		{"reviewer status", true, ImportRow{Status: StatusRejected, Source: SourceRule}, StatusRejected, SourceRule},
+		{"gold", true, ImportRow{Gold: true}, StatusAccepted, SourceHuman},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			i, err := newImporter(tx, DefaultProjectID, documentID, ImportOptions{Format: "jsonl", Offsets: OffsetByte}, userID, test.reviewer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			row := test.row
+			row.Text, row.Label = "Acme", "Party"
+			_, annotationID, err := i.importRow(row)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			annotation, err := getAnnotation(tx, documentID, annotationID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if annotation.Status != test.status || annotation.Source != test.source {
+				t.Errorf("imported as %s and %s, want %s and %s", annotation.Status, annotation.Source, test.status, test.source)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"unicode/utf8"
+)
+
+// Units of the offsets of the exported spans
+const (
+	OffsetByte  = "byte"  // bytes of the UTF-8 text, as stored
+	OffsetChar  = "char"  // Unicode code points, the indices of Python strings
+	OffsetUTF16 = "utf16" // UTF-16 code units, the indices of JavaScript strings
+)
+
+// JSONLSpan is an annotation of an exported document
+type JSONLSpan struct {
+	ID         uint       `json:"id"`
+	Start      uint       `json:"start"`
+	End        uint       `json:"end"`
+	Label      string     `json:"label"`
+	Text       string     `json:"text"`
+	Notes      string     `json:"notes,omitempty"`
+	Attributes Attributes `json:"attributes,omitempty"`
+	Status     string     `json:"status,omitempty"` // only with annotationStatus, which can select several
+}
+
+// JSONLRelation links two exported spans by their IDs
+type JSONLRelation struct {
+	From  uint   `json:"from"`
+	To    uint   `json:"to"`
+	Label string `json:"label"`
+}
+
+// JSONLMeta identifies the exported document
+type JSONLMeta struct {
+	DocumentID uint   `json:"documentId"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+}
+
+// JSONLDocument is a line of the JSONL export
+type JSONLDocument struct {
+	Text      string          `json:"text"`
+	Spans     []JSONLSpan     `json:"spans"`
+	Relations []JSONLRelation `json:"relations"`
+	Meta      JSONLMeta       `json:"meta"`
+}
+
+// JSONLError is the last line of an export which failed to read a document
+type JSONLError struct {
+	Error      string `json:"error"`
+	DocumentID uint   `json:"documentId"`
+}
+
+// offsetConverter converts byte offsets of a text to another unit
+func offsetConverter(text, unit string) func(uint) uint {
+	if unit == OffsetByte {
+		return func(offset uint) uint { return offset }
+	}
+
+	// converted[i] is the offset in the unit of the byte i, the offset of the text end included
+	converted := make([]uint, len(text)+1)
+	position := uint(0)
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		for j := i; j < i+size; j++ {
+			converted[j] = position
+		}
+		i += size
+
+		if unit == OffsetUTF16 && r > 0xFFFF {
+			position += 2
+		} else {
+			position++
+		}
+	}
+	converted[len(text)] = position
+
+	return func(offset uint) uint {
+		if offset > uint(len(text)) {
+			offset = uint(len(text))
+		}
+		return converted[offset]
+	}
+}
+
+// byteOffsetConverter converts offsets of a text in a unit to byte offsets, false when an offset is beyond the text or
+// in the middle of a character
+func byteOffsetConverter(text, unit string) func(uint) (uint, bool) {
+	if unit == OffsetByte {
+		return func(offset uint) (uint, bool) {
+			return offset, offset <= uint(len(text)) && (offset == uint(len(text)) || utf8.RuneStart(text[offset]))
+		}
+	}
+
+	// offsets[i] is the byte offset of the position i in the unit, -1 inside of a character
+	offsets := []int{}
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		offsets = append(offsets, i)
+		if unit == OffsetUTF16 && r > 0xFFFF {
+			offsets = append(offsets, -1)
+		}
+		i += size
+	}
+	offsets = append(offsets, len(text))
+
+	return func(offset uint) (uint, bool) {
+		if offset >= uint(len(offsets)) || offsets[offset] < 0 {
+			return 0, false
+		}
+		return uint(offsets[offset]), true
+	}
+}
+
+// jsonlDocument builds the JSONL line of a document
+func jsonlDocument(q querier, document DocumentSummary, options ExportOptions, unit string) (JSONLDocument, error) {
+	line := JSONLDocument{
+		Spans:     []JSONLSpan{},
+		Relations: []JSONLRelation{},
+		Meta:      JSONLMeta{DocumentID: document.ID, Name: document.Name, Status: document.Status},
+	}
+
+	var err error
+	line.Text, err = documentText(q, document.ID)
+	if err != nil {
+		return line, err
+	}
+
+	annotations, err := exportDocumentAnnotations(q, document.ID, options)
+	if err != nil {
+		return line, err
+	}
+
+	convert := offsetConverter(line.Text, unit)
+	exported := map[uint]bool{}
+	for _, annotation := range annotations {
+		line.Spans = append(line.Spans, JSONLSpan{
+			ID:         annotation.AnnotationID,
+			Start:      convert(annotation.CharacterStart),
+			End:        convert(annotation.CharacterEnd),
+			Label:      annotation.Topic,
+			Text:       annotation.Text,
+			Notes:      annotation.Notes,
+			Attributes: annotation.Attributes,
+		})
+		if len(options.AnnotationStatuses) > 0 {
+			line.Spans[len(line.Spans)-1].Status = annotation.Status
+		}
+		exported[annotation.AnnotationID] = true
+	}
+
+	relations, err := queryRelations(q, document.ID)
+	if err != nil {
+		return line, err
+	}
+
+	for _, relation := range relations {
+		if exported[relation.FromAnnotationID] && exported[relation.ToAnnotationID] {
+			line.Relations = append(line.Relations, JSONLRelation{From: relation.FromAnnotationID, To: relation.ToAnnotationID, Label: relation.RelationType})
+		}
+	}
+
+	return line, nil
+}
+
+// GetJSONLHandler streams the documents of a project as JSON lines of their text and spans, with ?offsets=byte|char|utf16
+func GetJSONLHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := parseExportOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unit := r.URL.Query().Get("offsets")
+	if unit == "" {
+		unit = OffsetByte
+	}
+	if unit != OffsetByte && unit != OffsetChar && unit != OffsetUTF16 {
+		http.Error(w, fmt.Sprintf("Invalid offsets %q, expected byte, char or utf16", unit), http.StatusBadRequest)
+		return
+	}
+
+	// A single transaction so the lines are consistent with each other, even if documents change during the export
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	documents, err := exportDocuments(tx, projectID(r), options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	attachment(w, fmt.Sprintf("project-%d.jsonl", projectID(r)), "application/x-ndjson; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Documents are sent as they are read. The status cannot change anymore when one fails, a last line tells the
+	// export is incomplete instead.
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	for _, document := range documents {
+		line, err := jsonlDocument(tx, document, options, unit)
+		if err != nil {
+			log.Printf("Unable to export document %d: %v\n", document.ID, err)
+			encoder.Encode(JSONLError{Error: fmt.Sprintf("Unable to export document %d: %v", document.ID, err), DocumentID: document.ID})
+			return
+		}
+
+		err = encoder.Encode(line)
+		if err != nil {
+			log.Printf("Unable to send document %d: %v\n", document.ID, err)
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestJSONLDocumentAnnotationStatus(t *testing.T) {
+	defer openTestDatabase(t)()
+	topicID := mustExec(t, "INSERT INTO topics (topic) VALUES ('Party')")
+	documentID := insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "and", "Beta"})
+
+	for _, annotation := range []Annotation{
+		{CharacterStart: 0, CharacterEnd: 4, Status: StatusAccepted},
+		{CharacterStart: 9, CharacterEnd: 13, Status: StatusSuggested},
+	} {
+		annotation.TopicID, annotation.Source = topicID, SourceHuman
+		if _, err := insertAnnotation(db, documentID, annotation, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	document, err := getDocumentSummary(db, documentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		spans []string
+	}{
+		{"", []string{"Acme "}},
+		{"annotationStatus=suggested", []string{"Beta suggested"}},
+		{"annotationStatus=accepted&annotationStatus=suggested", []string{"Acme accepted", "Beta suggested"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, _ := url.ParseQuery(test.query)
+			options, err := parseExportOptions(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			line, err := jsonlDocument(db, document, options, OffsetByte)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			spans := []string{}
+			for _, span := range line.Spans {
+				spans = append(spans, span.Text+" "+span.Status)
+			}
+			if len(spans) != len(test.spans) {
+				t.Fatalf("exported %v, want %v", spans, test.spans)
+			}
+			for i := range spans {
+				if spans[i] != test.spans[i] {
+					t.Errorf("exported %v, want %v", spans, test.spans)
+				}
+			}
+		})
+	}
+
+	if _, err := parseExportOptions(url.Values{"annotationStatus": {"pending"}}); err == nil {
+		t.Error("parsed an unknown annotationStatus")
+	}
+}
+
+func TestOffsetConverters(t *testing.T) {
+	// a is 1 byte, é 2 bytes and 😀 4 bytes, outside of the Basic Multilingual Plane
+	text := "aé😀b"
+
+	tests := []struct {
+		unit    string
+		bytes   []uint // byte offsets of the characters and of the text end
+		offsets []uint // their offsets in the unit
+		invalid []uint // offsets of the unit which are not a character boundary or beyond the text
+	}{
+		{OffsetByte, []uint{0, 1, 3, 7, 8}, []uint{0, 1, 3, 7, 8}, []uint{2, 4, 9}},
+		{OffsetChar, []uint{0, 1, 3, 7, 8}, []uint{0, 1, 2, 3, 4}, []uint{5}},
+		{OffsetUTF16, []uint{0, 1, 3, 7, 8}, []uint{0, 1, 2, 4, 5}, []uint{3, 6}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.unit, func(t *testing.T) {
+			convert, toBytes := offsetConverter(text, test.unit), byteOffsetConverter(text, test.unit)
+
+			for i, offset := range test.offsets {
+				if got := convert(test.bytes[i]); got != offset {
+					t.Errorf("converted byte %d to %d, want %d", test.bytes[i], got, offset)
+				}
+				if got, ok := toBytes(offset); !ok || got != test.bytes[i] {
+					t.Errorf("converted %d to byte %d (%v), want %d", offset, got, ok, test.bytes[i])
+				}
+			}
+
+			for _, offset := range test.invalid {
+				if got, ok := toBytes(offset); ok {
+					t.Errorf("converted %d to byte %d, want it rejected", offset, got)
+				}
+			}
+		})
+	}
+
+	// Bytes inside of a character and beyond the text get the offset of the character and of the end
+	convert := offsetConverter(text, OffsetChar)
+	for b, want := range map[uint]uint{2: 1, 5: 2, 100: 4} {
+		if got := convert(b); got != want {
+			t.Errorf("converted byte %d to %d, want %d", b, got, want)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// LabelStudioValue is the span of a region of a text task
+type LabelStudioValue struct {
+	Start  uint     `json:"start"`
+	End    uint     `json:"end"`
+	Text   string   `json:"text"`
+	Labels []string `json:"labels"`
+}
+
+// LabelStudioMeta holds the notes of a region, its attributes, and its status and source when they are not the defaults
+type LabelStudioMeta struct {
+	Text       []string   `json:"text,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	Attributes Attributes `json:"attributes,omitempty"`
+}
+
+// LabelStudioResult is a labeled region, or a relation between two regions
+type LabelStudioResult struct {
+	ID        string            `json:"id,omitempty"`
+	Type      string            `json:"type"`
+	FromName  string            `json:"from_name,omitempty"`
+	ToName    string            `json:"to_name,omitempty"`
+	Origin    string            `json:"origin,omitempty"`
+	Value     *LabelStudioValue `json:"value,omitempty"`
+	Score     *float64          `json:"score,omitempty"`
+	Meta      *LabelStudioMeta  `json:"meta,omitempty"`
+	FromID    string            `json:"from_id,omitempty"`
+	ToID      string            `json:"to_id,omitempty"`
+	Direction string            `json:"direction,omitempty"`
+	Labels    []string          `json:"labels,omitempty"`
+}
+
+// LabelStudioUser identifies an annotator, Label Studio naming users by email
+type LabelStudioUser struct {
+	ID    uint   `json:"id"`
+	Email string `json:"email"`
+}
+
+// LabelStudioAnnotation is the layer of an annotator, or the gold layer as ground truth
+type LabelStudioAnnotation struct {
+	ID              uint                `json:"id"`
+	CompletedBy     json.RawMessage     `json:"completed_by"` // a user, or only its ID in Label Studio exports
+	CreatedUsername string              `json:"created_username,omitempty"`
+	GroundTruth     bool                `json:"ground_truth"`
+	Result          []LabelStudioResult `json:"result"`
+}
+
+// LabelStudioPrediction holds the annotations which are not attributed to a user
+type LabelStudioPrediction struct {
+	ModelVersion string              `json:"model_version"`
+	Score        *float64            `json:"score,omitempty"`
+	Result       []LabelStudioResult `json:"result"`
+}
+
+// LabelStudioData is the text of a task and the document it comes from
+type LabelStudioData struct {
+	Text       string `json:"text"`
+	DocumentID uint   `json:"documentId,omitempty"`
+	Name       string `json:"name,omitempty"`
+}
+
+// LabelStudioTask is a document
+type LabelStudioTask struct {
+	ID          uint                    `json:"id"`
+	Data        LabelStudioData         `json:"data"`
+	Annotations []LabelStudioAnnotation `json:"annotations"`
+	Predictions []LabelStudioPrediction `json:"predictions"`
+}
+
+// annotationLayer is the annotations of a user in a document, of the gold layer or not
+type annotationLayer struct {
+	UserID      *uint
+	Gold        bool
+	Annotations []Annotation
+}
+
+// annotationLayers groups the annotations of a document to export by annotator, the rejected ones being left out unless
+// the options ask for them. The annotations of nobody come first, then the layers by user, the gold layer after the
+// other layers of its user.
+func annotationLayers(q querier, documentID uint, options ExportOptions) ([]annotationLayer, error) {
+	statuses := options.AnnotationStatuses
+	if len(statuses) == 0 {
+		statuses = []string{StatusSuggested, StatusAccepted}
+	}
+
+	annotations, err := queryAnnotations(q, AnnotationFilter{DocumentID: documentID, Statuses: statuses})
+	if err != nil {
+		return nil, err
+	}
+
+	topics := map[uint]bool{}
+	for _, topicID := range options.TopicIDs {
+		topics[topicID] = true
+	}
+
+	layers := []annotationLayer{}
+	indexes := map[string]int{}
+	for _, annotation := range annotations {
+		if len(topics) > 0 && !topics[annotation.TopicID] {
+			continue
+		}
+
+		key := fmt.Sprintf("-/%t", annotation.Gold)
+		if annotation.UserID != nil {
+			key = fmt.Sprintf("%d/%t", *annotation.UserID, annotation.Gold)
+		}
+
+		index, ok := indexes[key]
+		if !ok {
+			index = len(layers)
+			indexes[key] = index
+			layers = append(layers, annotationLayer{UserID: annotation.UserID, Gold: annotation.Gold})
+		}
+		layers[index].Annotations = append(layers[index].Annotations, annotation)
+	}
+
+	sort.SliceStable(layers, func(i, j int) bool {
+		a, b := layers[i], layers[j]
+		if (a.UserID == nil) != (b.UserID == nil) {
+			return a.UserID == nil
+		}
+		if a.UserID != nil && *a.UserID != *b.UserID {
+			return *a.UserID < *b.UserID
+		}
+		return !a.Gold && b.Gold
+	})
+
+	return layers, nil
+}
+
+// layerRelations returns the relations between annotations of a layer
+func layerRelations(relations []Relation, layer annotationLayer) []Relation {
+	inLayer := map[uint]bool{}
+	for _, annotation := range layer.Annotations {
+		inLayer[annotation.AnnotationID] = true
+	}
+
+	kept := []Relation{}
+	for _, relation := range relations {
+		if inLayer[relation.FromAnnotationID] && inLayer[relation.ToAnnotationID] {
+			kept = append(kept, relation)
+		}
+	}
+
+	return kept
+}
+
+// labelStudioResults converts a layer to Label Studio regions, with UTF-16 offsets as Label Studio counts characters
+// in JavaScript
+func labelStudioResults(layer annotationLayer, relations []Relation, text string) []LabelStudioResult {
+	convert := offsetConverter(text, OffsetUTF16)
+	results := []LabelStudioResult{}
+
+	origin := "manual"
+	if layer.UserID == nil {
+		origin = "prediction"
+	}
+
+	for _, annotation := range layer.Annotations {
+		result := LabelStudioResult{
+			ID:       fmt.Sprintf("a%d", annotation.AnnotationID),
+			Type:     "labels",
+			FromName: "label",
+			ToName:   "text",
+			Origin:   origin,
+			Value: &LabelStudioValue{
+				Start:  convert(annotation.CharacterStart),
+				End:    convert(annotation.CharacterEnd),
+				Text:   text[annotation.CharacterStart:annotation.CharacterEnd],
+				Labels: []string{annotation.Topic},
+			},
+			Score: annotation.Confidence,
+		}
+		if annotation.Notes != "" || len(annotation.Attributes) > 0 || annotation.Status != StatusAccepted || annotation.Source != SourceHuman {
+			result.Meta = &LabelStudioMeta{Attributes: annotation.Attributes}
+			if annotation.Notes != "" {
+				result.Meta.Text = []string{annotation.Notes}
+			}
+			if annotation.Status != StatusAccepted {
+				result.Meta.Status = annotation.Status
+			}
+			if annotation.Source != SourceHuman {
+				result.Meta.Source = annotation.Source
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	for _, relation := range layerRelations(relations, layer) {
+		results = append(results, LabelStudioResult{
+			Type:      "relation",
+			FromID:    fmt.Sprintf("a%d", relation.FromAnnotationID),
+			ToID:      fmt.Sprintf("a%d", relation.ToAnnotationID),
+			Direction: "right",
+			Labels:    []string{relation.RelationType},
+		})
+	}
+
+	return results
+}
+
+// labelStudioTask converts a document to a Label Studio task, each layer becoming an annotation, and the annotations of
+// nobody a prediction
+func labelStudioTask(q querier, document DocumentSummary, options ExportOptions, usernames map[uint]string) (LabelStudioTask, error) {
+	task := LabelStudioTask{ID: document.ID, Annotations: []LabelStudioAnnotation{}, Predictions: []LabelStudioPrediction{}}
+
+	text, err := documentText(q, document.ID)
+	if err != nil {
+		return task, err
+	}
+	task.Data = LabelStudioData{Text: text, DocumentID: document.ID, Name: document.Name}
+
+	layers, err := annotationLayers(q, document.ID, options)
+	if err != nil {
+		return task, err
+	}
+
+	relations, err := queryRelations(q, document.ID)
+	if err != nil {
+		return task, err
+	}
+
+	for _, layer := range layers {
+		results := labelStudioResults(layer, relations, text)
+
+		if layer.UserID == nil {
+			task.Predictions = append(task.Predictions, LabelStudioPrediction{ModelVersion: "spectator", Result: results})
+			continue
+		}
+
+		completedBy, err := json.Marshal(LabelStudioUser{ID: *layer.UserID, Email: usernames[*layer.UserID]})
+		if err != nil {
+			return task, err
+		}
+
+		task.Annotations = append(task.Annotations, LabelStudioAnnotation{
+			ID:          uint(len(task.Annotations) + 1),
+			CompletedBy: completedBy,
+			GroundTruth: layer.Gold,
+			Result:      results,
+		})
+	}
+
+	return task, nil
+}
+
+// GetLabelStudioHandler exports the documents of a project as Label Studio tasks, with the layer of each annotator
+func GetLabelStudioHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := parseExportOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	documents, err := exportDocuments(tx, projectID(r), options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, err := queryUsers(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	usernames := map[uint]string{}
+	for _, user := range users {
+		usernames[user.UserID] = user.Username
+	}
+
+	tasks := []LabelStudioTask{}
+	for _, document := range documents {
+		task, err := labelStudioTask(tx, document, options, usernames)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tasks = append(tasks, task)
+	}
+
+	attachment(w, fmt.Sprintf("project-%d-labelstudio.json", projectID(r)), "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(tasks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// labelStudioAnnotator returns the username of the annotator of a Label Studio annotation, nil when it is unknown
+func labelStudioAnnotator(annotation LabelStudioAnnotation) *string {
+	var user LabelStudioUser
+	if json.Unmarshal(annotation.CompletedBy, &user) == nil && user.Email != "" {
+		return &user.Email
+	}
+
+	// Label Studio exports only have the ID of the user, with its email in created_username as "email, id"
+	if annotation.CreatedUsername != "" {
+		username := strings.TrimSpace(strings.Split(annotation.CreatedUsername, ",")[0])
+		return &username
+	}
+
+	return nil
+}
+
+// readLabelStudioRows reads the regions of Label Studio tasks, each task being a row. The documents are found by the
+// documentId of the task data, or by their text.
+func readLabelStudioRows(body io.Reader) (importFile, error) {
+	file := newImportFile()
+
+	var tasks []LabelStudioTask
+	err := json.NewDecoder(body).Decode(&tasks)
+	if err != nil {
+		return file, fmt.Errorf("Invalid Label Studio tasks: %w", err)
+	}
+
+	nobody := ""
+	for t, task := range tasks {
+		row := t + 1
+		base := ImportRow{DocumentID: task.Data.DocumentID, DocumentText: task.Data.Text}
+
+		addResults := func(layer string, results []LabelStudioResult, template ImportRow) {
+			for _, result := range results {
+				switch result.Type {
+				case "labels":
+					if result.Value == nil || len(result.Value.Labels) == 0 {
+						file.Records = append(file.Records, importRecord{Row: row, Err: fmt.Errorf("Region %s has no label", result.ID)})
+						continue
+					}
+
+					for _, label := range result.Value.Labels {
+						importRow := template
+						start, end := result.Value.Start, result.Value.End
+						importRow.Start, importRow.End, importRow.Text, importRow.Label = &start, &end, result.Value.Text, label
+						if result.Score != nil {
+							importRow.Confidence = result.Score
+						}
+						if result.Meta != nil {
+							importRow.Notes = strings.Join(result.Meta.Text, "\n")
+							importRow.Attributes = result.Meta.Attributes
+							if result.Meta.Status != "" {
+								importRow.Status = result.Meta.Status
+							}
+							if result.Meta.Source != "" {
+								importRow.Source = result.Meta.Source
+							}
+						}
+
+						id := fmt.Sprintf("%d/%s/%s", row, layer, result.ID)
+						file.Entities[id] = append(file.Entities[id], len(file.Records))
+						file.Records = append(file.Records, importRecord{Row: row, Value: importRow})
+					}
+
+				case "relation":
+					label := ""
+					if len(result.Labels) > 0 {
+						label = result.Labels[0]
+					}
+					from, to := result.FromID, result.ToID
+					if result.Direction == "left" {
+						from, to = to, from
+					}
+					file.Relations = append(file.Relations, importRelation{Row: row, Type: label,
+						From: fmt.Sprintf("%d/%s/%s", row, layer, from), To: fmt.Sprintf("%d/%s/%s", row, layer, to)})
+
+				default:
+					file.Records = append(file.Records, importRecord{Row: row, Err: fmt.Errorf("Unsupported Label Studio result type %q", result.Type)})
+				}
+			}
+		}
+
+		for a, annotation := range task.Annotations {
+			template := base
+			template.Annotator = labelStudioAnnotator(annotation)
+			template.Gold = annotation.GroundTruth
+			addResults(fmt.Sprintf("a%d", a), annotation.Result, template)
+		}
+
+		for p, prediction := range task.Predictions {
+			template := base
+			template.Annotator = &nobody
+			template.Source = SourceModel
+			template.Status = StatusSuggested
+			template.Confidence = prediction.Score
+			template.Model = prediction.ModelVersion
+			addResults(fmt.Sprintf("p%d", p), prediction.Result, template)
+		}
+	}
+
+	return file, nil
+}
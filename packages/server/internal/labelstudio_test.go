@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadLabelStudioPredictions(t *testing.T) {
+	file, err := readLabelStudioRows(strings.NewReader(`[{"data": {"text": "Acme Corp pays"}, "annotations": [],
+		"predictions": [{"model_version": "v1", "score": 0.5, "result": [
+			{"id": "a", "type": "labels", "value": {"start": 0, "end": 4, "text": "Acme", "labels": ["Party"]}},
+			{"id": "b", "type": "labels", "score": 0.9, "value": {"start": 10, "end": 14, "text": "pays", "labels": ["Payment"]}}]}]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(file.Records) != 2 {
+		t.Fatalf("read %d rows, want 2", len(file.Records))
+	}
+
+	for i, confidence := range []float64{0.5, 0.9} {
+		row := file.Records[i].Value
+		if row.Status != StatusSuggested || row.Source != SourceModel || row.Annotator == nil || *row.Annotator != "" {
+			t.Errorf("row %d read as %s from %s by %v, want a suggestion of a model by nobody", i, row.Status, row.Source, row.Annotator)
+		}
+		if row.Model != "v1" {
+			t.Errorf("row %d predicted by %q, want v1", i, row.Model)
+		}
+		if row.Confidence == nil || *row.Confidence != confidence {
+			t.Errorf("row %d has confidence %v, want %v", i, row.Confidence, confidence)
+		}
+	}
+}
+
+func TestLabelStudioNotesAndAttributes(t *testing.T) {
+	layer := annotationLayer{Annotations: []Annotation{{AnnotationID: 12, Topic: "Party", CharacterStart: 0, CharacterEnd: 4,
+		Status: StatusAccepted, Source: SourceHuman, Notes: "main party", Attributes: Attributes{"verified": true}}}}
+	tasks := []LabelStudioTask{{ID: 1, Data: LabelStudioData{Text: "Acme pays"},
+		Annotations: []LabelStudioAnnotation{{CompletedBy: json.RawMessage(`{"id": 1, "email": "ann"}`), Result: labelStudioResults(layer, nil, "Acme pays")}}}}
+
+	content, err := json.Marshal(tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := readLabelStudioRows(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(file.Records) != 1 || file.Records[0].Err != nil {
+		t.Fatalf("read %+v, want a region", file.Records)
+	}
+	if row := file.Records[0].Value; row.Notes != "main party" || !reflect.DeepEqual(row.Attributes, Attributes{"verified": true}) {
+		t.Errorf("read notes %q and attributes %v, want them as exported", row.Notes, row.Attributes)
+	}
+}
@@ -1,17 +1,56 @@
 package internal
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // Annotation struct holds the minimal set of data we need to describe an annotation/highlight
 type Annotation struct {
-	AnnotationID   uint   `json:"annotationId"`
-	CharacterStart uint   `json:"characterStart"`
-	CharacterEnd   uint   `json:"characterEnd"`
-	PageStart      uint   `json:"pageStart"`
-	PageEnd        uint   `json:"pageEnd"`
-	Top            uint   `json:"top"`
-	Left           uint   `json:"left"`
-	TopicID        uint   `json:"topicId"`
-	Topic          string `json:"topic"`
-	Text           string `json:"text"`
+	AnnotationID   uint            `json:"annotationId"`
+	CharacterStart uint            `json:"characterStart"`
+	CharacterEnd   uint            `json:"characterEnd"`
+	PageStart      uint            `json:"pageStart"`
+	PageEnd        uint            `json:"pageEnd"`
+	Top            uint            `json:"top"`
+	Left           uint            `json:"left"`
+	TopicID        uint            `json:"topicId"`
+	Topic          string          `json:"topic"`
+	Text           string          `json:"text"`
+	Status         string          `json:"status"`
+	Source         string          `json:"source"`
+	Confidence     *float64        `json:"confidence"`
+	Model          string          `json:"model"` // model which predicted the annotation
+	Notes          string          `json:"notes"`
+	Attributes     Attributes      `json:"attributes"`
+	Value          json.RawMessage `json:"value"`      // text parsed according to the value type of the topic
+	ValueError     string          `json:"valueError"` // why the text could not be parsed
+	UserID         *uint           `json:"userId"`     // who created the annotation, nil for predictions
+	Gold           bool            `json:"gold"`       // part of the adjudicated layer of the document
+	// the annotation a gold annotation was copied from, the reviewer who picked it is the user of the copy
+	SourceAnnotationID *uint `json:"sourceAnnotationId"`
+}
+
+// Attributes holds free-form key/value pairs attached to an annotation
+type Attributes map[string]interface{}
+
+// AnnotationPatch holds the fields of an annotation which can be updated, nil fields are left unchanged
+type AnnotationPatch struct {
+	TopicID        *uint      `json:"topicId"`
+	CharacterStart *uint      `json:"characterStart"`
+	CharacterEnd   *uint      `json:"characterEnd"`
+	Status         *string    `json:"status"`
+	Source         *string    `json:"source"`
+	Confidence     *float64   `json:"confidence"`
+	Notes          *string    `json:"notes"`
+	Attributes     Attributes `json:"attributes"` // merged into the existing attributes, null values remove the key
+}
+
+// AnnotationReview is the body of the bulk accept/reject routes.
+// Without AnnotationIDs, every suggested annotation of the document is reviewed.
+type AnnotationReview struct {
+	AnnotationIDs []uint   `json:"annotationIds"`
+	MinConfidence *float64 `json:"minConfidence,omitempty"`
 }
 
 // Page struct holds the minimal set of data we need to describe a page in a document
@@ -23,24 +62,78 @@ type Page struct {
 	TokensURL      string `json:"tokensURL"`
 }
 
+// Project struct represents a labeling project, owning its documents, topics and settings
+type Project struct {
+	ProjectID uint       `json:"id"`
+	Name      string     `json:"name"`
+	Settings  Attributes `json:"settings"`
+}
+
+// Projects represents a collection of Project
+type Projects []Project
+
 // Document struct holds the minimal set of data we need to describe a document
 type Document struct {
-	ID    uint   `json:"id"`
-	Name  string `json:"name"`
-	Pages []Page `json:"pages"`
+	ID        uint   `json:"id"`
+	ProjectID uint   `json:"projectId"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Pages     []Page `json:"pages"`
 }
 
 // DocumentSummary will be used for the /documents route
 type DocumentSummary struct {
 	ID        uint   `json:"id"`
+	ProjectID uint   `json:"projectId"`
 	Name      string `json:"name"`
 	Pages     uint   `json:"pages"`
 	Processed bool   `json:"processed"`
+	Status    string `json:"status"`
 }
 
 // DocumentSummaries represents a collection of DocumentSummary
 type DocumentSummaries []DocumentSummary
 
+// DocumentTransition records a document moving from a status of its lifecycle to another
+type DocumentTransition struct {
+	DocumentTransitionID uint      `json:"id"`
+	DocumentID           uint      `json:"documentId"`
+	FromStatus           string    `json:"fromStatus"`
+	ToStatus             string    `json:"toStatus"`
+	UserID               *uint     `json:"userId"` // nil when the server moved the document
+	Comment              string    `json:"comment"`
+	CreatedAt            time.Time `json:"createdAt"`
+}
+
+// DocumentTransitions represents a collection of DocumentTransition
+type DocumentTransitions []DocumentTransition
+
+// StatusChange is the body of the route moving a document through its lifecycle
+type StatusChange struct {
+	Status  string `json:"status"`
+	Comment string `json:"comment"`
+}
+
+// AuditEntry records a change of an annotation, a topic or a document.
+// Before is null for a creation, After is null for a deletion.
+type AuditEntry struct {
+	AuditID    uint            `json:"id"`
+	ProjectID  uint            `json:"projectId"`
+	DocumentID *uint           `json:"documentId"`
+	Entity     string          `json:"entity"`
+	EntityID   uint            `json:"entityId"`
+	Action     string          `json:"action"`
+	UserID     *uint           `json:"userId"` // nil for changes made by the server, like predictions
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	RevertsID  *uint           `json:"revertsId"` // the entry this one undid
+	CauseID    *uint           `json:"causeId"`   // the entry whose change made this one, like a topic deleted with its annotations
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// AuditEntries represents a collection of AuditEntry
+type AuditEntries []AuditEntry
+
 // BoundingBox struct represents where the token is on the page with (top/left/right/bottom)
 type BoundingBox struct {
 	Top    uint `json:"top"`
@@ -63,9 +156,116 @@ type Tokens []Token
 
 // Topic struct represents a type of annotation
 type Topic struct {
-	TopicID uint   `json:"id"`
-	Topic   string `json:"topic"`
+	TopicID     uint     `json:"id"`
+	ProjectID   uint     `json:"projectId"`
+	Topic       string   `json:"topic"`
+	ParentID    *uint    `json:"parentId"`
+	Color       string   `json:"color"`
+	Description string   `json:"description"`
+	Shortcut    string   `json:"shortcut"`
+	ValueType   string   `json:"valueType"`
+	EnumValues  []string `json:"enumValues"`
+	Children    Topics   `json:"children,omitempty"`
+}
+
+// TopicPatch holds the fields of a topic which can be updated, nil fields are left unchanged
+type TopicPatch struct {
+	Topic       *string   `json:"topic"`
+	ParentID    *uint     `json:"parentId"` // 0 moves the topic to the top level
+	Color       *string   `json:"color"`
+	Description *string   `json:"description"`
+	Shortcut    *string   `json:"shortcut"`
+	ValueType   *string   `json:"valueType"`
+	EnumValues  *[]string `json:"enumValues"`
+}
+
+// TopicMerge is the body of the topic merge route
+type TopicMerge struct {
+	IntoTopicID uint `json:"intoTopicId"`
 }
 
 // Topics represents a collection of Topic
 type Topics []Topic
+
+// Relation struct represents a directed link between two annotations of a document
+type Relation struct {
+	RelationID       uint   `json:"relationId"`
+	RelationTypeID   uint   `json:"relationTypeId"`
+	RelationType     string `json:"relationType"`
+	FromAnnotationID uint   `json:"fromAnnotationId"`
+	ToAnnotationID   uint   `json:"toAnnotationId"`
+	UserID           *uint  `json:"userId"` // who created the relation
+}
+
+// RelationType struct represents a type of relation
+type RelationType struct {
+	RelationTypeID uint   `json:"id"`
+	ProjectID      uint   `json:"projectId"`
+	RelationType   string `json:"relationType"`
+}
+
+// RelationTypes represents a collection of RelationType
+type RelationTypes []RelationType
+
+// User is an account, the password hash never leaves the database
+type User struct {
+	UserID   uint            `json:"id"`
+	Username string          `json:"username"`
+	Roles    RoleAssignments `json:"roles,omitempty"`
+	Scope    string          `json:"-"` // scope of the API token the user authenticated with, empty for sessions
+}
+
+type Users []User
+
+// Credentials is the body of the login and user creation routes
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RoleAssignment gives a role to a user in a project, or in all projects when ProjectID is nil
+type RoleAssignment struct {
+	RoleAssignmentID uint   `json:"id"`
+	UserID           uint   `json:"userId"`
+	ProjectID        *uint  `json:"projectId"`
+	Role             string `json:"role"`
+}
+
+type RoleAssignments []RoleAssignment
+
+// APIToken authenticates scripts, the token itself is only returned when it is created
+type APIToken struct {
+	APITokenID uint       `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	Token      string     `json:"token,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+type APITokens []APIToken
+
+// Task is a document to annotate by a user, assigned to the user or claimed from the queue of the project
+type Task struct {
+	TaskID       uint       `json:"id"`
+	DocumentID   uint       `json:"documentId"`
+	DocumentName string     `json:"documentName"`
+	ProjectID    uint       `json:"projectId"`
+	UserID       uint       `json:"userId"`
+	Status       string     `json:"status"`
+	AssignedBy   *uint      `json:"assignedBy"` // nil when claimed from the queue
+	CreatedAt    time.Time  `json:"createdAt"`
+	ClaimedAt    *time.Time `json:"claimedAt"`
+	ExpiresAt    *time.Time `json:"expiresAt"`
+	CompletedAt  *time.Time `json:"completedAt"`
+}
+
+type Tasks []Task
+
+// TaskAssignment assigns documents to users
+type TaskAssignment struct {
+	DocumentIDs []uint `json:"documentIds"`
+	UserIDs     []uint `json:"userIds"`
+	Strategy    string `json:"strategy"`   // manual by default
+	Annotators  int    `json:"annotators"` // users per document with the roundRobin and balanced strategies, 1 by default
+}
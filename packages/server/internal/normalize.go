@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Topic value types
+const (
+	ValueNone       = ""
+	ValueDate       = "date"
+	ValueMoney      = "money"
+	ValueNumber     = "number"
+	ValuePercentage = "percentage"
+	ValueEnum       = "enum"
+)
+
+func validValueType(valueType string) bool {
+	switch valueType {
+	case ValueNone, ValueDate, ValueMoney, ValueNumber, ValuePercentage, ValueEnum:
+		return true
+	}
+	return false
+}
+
+// Money is the normalized value of a money annotation, the amount is kept as a decimal string to stay exact
+type Money struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"January 2, 2006",
+	"January 2 2006",
+	"Jan 2, 2006",
+	"Jan 2 2006",
+	"Jan. 2, 2006",
+	"2 January 2006",
+	"2 January, 2006",
+	"2 Jan 2006",
+	"01/02/2006",
+	"1/2/2006",
+	"02.01.2006",
+	"2.1.2006",
+}
+
+var ordinalSuffix = regexp.MustCompile(`(\d)(st|nd|rd|th)\b`)
+
+// currencySymbols are matched in order, the longest first so that "CA$" is not read as "A$" or "$"
+var currencySymbols = []struct {
+	symbol   string
+	currency string
+}{
+	{"CA$", "CAD"},
+	{"HK$", "HKD"},
+	{"NZ$", "NZD"},
+	{"US$", "USD"},
+	{"A$", "AUD"},
+	{"C$", "CAD"},
+	{"R$", "BRL"},
+	{"S$", "SGD"},
+	{"$", "USD"},
+	{"€", "EUR"},
+	{"£", "GBP"},
+	{"¥", "JPY"},
+	{"₹", "INR"},
+}
+
+var currencyCode = regexp.MustCompile(`\b(USD|EUR|GBP|JPY|CAD|AUD|NZD|CHF|CNY|HKD|SGD|INR|KRW|SEK|NOK|DKK|PLN|RUB|BRL|MXN|ZAR)\b`)
+
+// numberPattern captures the sign of a number, the digits before its first separator, its thousands groups and its
+// decimal part. A separator followed by three digits is a thousands separator.
+var numberPattern = regexp.MustCompile(`([-+]?)(\d+)((?:[.,]\d{3})*)([.,]\d+)?`)
+
+func parseDate(text string) (string, error) {
+	text = strings.Trim(strings.Join(strings.Fields(text), " "), " .,;:")
+	text = ordinalSuffix.ReplaceAllString(text, "$1")
+
+	for _, layout := range dateLayouts {
+		date, err := time.Parse(layout, text)
+		if err == nil {
+			return date.Format("2006-01-02"), nil
+		}
+	}
+
+	return "", fmt.Errorf("%q is not a recognized date", text)
+}
+
+// parseDecimal extracts the first number of the text as a decimal string, with either a decimal point or a decimal
+// comma: "1,234.56" and "1.234,56" are both 1234.56, "12,5" is 12.5
+func parseDecimal(text string) (string, error) {
+	match := numberPattern.FindStringSubmatch(text)
+	if match == nil {
+		return "", fmt.Errorf("%q does not contain a number", text)
+	}
+	sign, integer, thousands, decimals := match[1], match[2], match[3], match[4]
+
+	// No number starts with a thousands group of 0, the separator of "0.125" is a decimal one
+	if thousands != "" && strings.Trim(integer, "0") == "" {
+		thousands, decimals = "", thousands[:4]
+	}
+
+	number := strings.TrimPrefix(sign, "+") + integer + strings.NewReplacer(",", "", ".", "").Replace(thousands)
+	if decimals != "" {
+		number += "." + decimals[1:]
+	}
+
+	_, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a number", match[0])
+	}
+
+	return number, nil
+}
+
+func parseNumber(text string) (float64, error) {
+	number, err := parseDecimal(text)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(number, 64)
+}
+
+func parsePercentage(text string) (float64, error) {
+	lower := strings.ToLower(text)
+	if !strings.Contains(lower, "%") && !strings.Contains(lower, "percent") {
+		return 0, fmt.Errorf("%q is not a percentage", text)
+	}
+
+	return parseNumber(text)
+}
+
+func parseMoney(text string) (Money, error) {
+	var money Money
+
+	for _, s := range currencySymbols {
+		if strings.Contains(text, s.symbol) {
+			money.Currency = s.currency
+			break
+		}
+	}
+
+	// An explicit code wins over an ambiguous symbol, "$1,200 CAD" is in Canadian dollars
+	if code := currencyCode.FindString(text); code != "" {
+		money.Currency = code
+	}
+
+	if money.Currency == "" {
+		return money, fmt.Errorf("%q has no currency", text)
+	}
+
+	amount, err := parseDecimal(text)
+	if err != nil {
+		return money, err
+	}
+	money.Amount = amount
+
+	return money, nil
+}
+
+func parseEnum(text string, values []string) (string, error) {
+	text = strings.TrimSpace(text)
+
+	for _, value := range values {
+		if strings.EqualFold(text, value) {
+			return value, nil
+		}
+	}
+
+	return "", fmt.Errorf("%q is not one of %s", text, strings.Join(values, ", "))
+}
+
+// normalizeValue parses the text of an annotation according to the value type of its topic.
+// It returns the JSON encoded value, or the reason why the text could not be parsed.
+func normalizeValue(valueType string, enumValues []string, text string) (json.RawMessage, error) {
+	var value interface{}
+	var err error
+
+	switch valueType {
+	case ValueNone:
+		return nil, nil
+	case ValueDate:
+		value, err = parseDate(text)
+	case ValueMoney:
+		value, err = parseMoney(text)
+	case ValueNumber:
+		value, err = parseNumber(text)
+	case ValuePercentage:
+		value, err = parsePercentage(text)
+	case ValueEnum:
+		value, err = parseEnum(text, enumValues)
+	default:
+		return nil, fmt.Errorf("Unknown value type %q", valueType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value)
+}
+
+// normalizeAnnotation parses the text of an annotation with its topic, returning the values of the value and value_error columns
+func normalizeAnnotation(q querier, topicID uint, text string) (sql.NullString, string, error) {
+	var valueType, enumValues string
+
+	err := q.QueryRow("SELECT value_type, enum_values FROM topics WHERE topic_id = ?", topicID).Scan(&valueType, &enumValues)
+	if err != nil {
+		return sql.NullString{}, "", fmt.Errorf("Unable to read topic %d: %w", topicID, err)
+	}
+
+	var values []string
+	err = json.Unmarshal([]byte(enumValues), &values)
+	if err != nil {
+		return sql.NullString{}, "", fmt.Errorf("Unable to unmarshal enum values of topic %d: %w", topicID, err)
+	}
+
+	value, err := normalizeValue(valueType, values, text)
+	if err != nil {
+		return sql.NullString{}, err.Error(), nil
+	}
+
+	return sql.NullString{String: string(value), Valid: value != nil}, "", nil
+}
+
+// normalizeTopicAnnotations parses again the annotations of a topic, after its value type changed. It returns the
+// annotations whose value changed as they were before, by document, for the audit log.
+func normalizeTopicAnnotations(tx *sql.Tx, topicID uint) (map[uint][]Annotation, error) {
+	annotations, err := topicAnnotations(tx, topicID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := map[uint][]Annotation{}
+	for documentID, documentAnnotations := range annotations {
+		for _, annotation := range documentAnnotations {
+			value, valueError, err := normalizeAnnotation(tx, topicID, annotation.Text)
+			if err != nil {
+				return nil, err
+			}
+
+			if value.String == string(annotation.Value) && valueError == annotation.ValueError {
+				continue
+			}
+
+			_, err = tx.Exec("UPDATE annotations SET value = ?, value_error = ? WHERE annotation_id = ?", value, valueError, annotation.AnnotationID)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to update annotation value: %w", err)
+			}
+
+			updated[documentID] = append(updated[documentID], annotation)
+		}
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,94 @@
+package internal
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		text     string
+		amount   string
+		currency string
+	}{
+		{"$1,200.50", "1200.50", "USD"},
+		{"1.234,56 €", "1234.56", "EUR"},
+		{"€ 12,5", "12.5", "EUR"},
+		{"£1,000,000", "1000000", "GBP"},
+		{"CA$250", "250", "CAD"},
+		{"HK$ 1.000,00", "1000.00", "HKD"},
+		{"A$99.99", "99.99", "AUD"},
+		{"$1,200 CAD", "1200", "CAD"},
+		{"EUR 3.500", "3500", "EUR"},
+		{"USD -40.10", "-40.10", "USD"},
+		{"$0.125", "0.125", "USD"},
+		{"€0,5", "0.5", "EUR"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			money, err := parseMoney(test.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if money.Amount != test.amount || money.Currency != test.currency {
+				t.Errorf("parsed %s %s, want %s %s", money.Amount, money.Currency, test.amount, test.currency)
+			}
+		})
+	}
+
+	for _, text := range []string{"1,200", "$ per unit"} {
+		if money, err := parseMoney(text); err == nil {
+			t.Errorf("parsed %q as %+v, want an error", text, money)
+		}
+	}
+}
+
+func TestNormalizeValue(t *testing.T) {
+	tests := []struct {
+		valueType string
+		text      string
+		value     string // empty when the text cannot be parsed
+	}{
+		{ValueDate, "March 3rd, 2021", `"2021-03-03"`},
+		{ValueDate, "03/04/2021", `"2021-03-04"`},
+		{ValueDate, "12.05.2020", `"2020-05-12"`},
+		{ValueDate, "tomorrow", ""},
+		{ValueMoney, "1.234,56 €", `{"amount":"1234.56","currency":"EUR"}`},
+		{ValueMoney, "twelve", ""},
+		{ValueNumber, "1,500 units", "1500"},
+		{ValueNumber, "1,5", "1.5"},
+		{ValueNumber, "€ 1.234,56", "1234.56"},
+		{ValueNumber, "1,234.56", "1234.56"},
+		{ValueNumber, "0.125", "0.125"},
+		{ValueNumber, "+7", "7"},
+		{ValueNumber, "none", ""},
+		{ValuePercentage, "12.5%", "12.5"},
+		{ValuePercentage, "12,5 %", "12.5"},
+		{ValuePercentage, "0,75%", "0.75"},
+		{ValuePercentage, "3 percent", "3"},
+		{ValuePercentage, "12.5", ""},
+		{ValueEnum, " monthly ", `"Monthly"`},
+		{ValueEnum, "daily", ""},
+		{"color", "red", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.valueType+" "+test.text, func(t *testing.T) {
+			value, err := normalizeValue(test.valueType, []string{"Monthly", "Yearly"}, test.text)
+			if test.value == "" {
+				if err == nil {
+					t.Errorf("normalized to %s, want an error", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(value) != test.value {
+				t.Errorf("normalized to %s, want %s", value, test.value)
+			}
+		})
+	}
+
+	if value, err := normalizeValue(ValueNone, nil, "anything"); value != nil || err != nil {
+		t.Errorf("normalized a topic without value type to %s (%v), want no value", value, err)
+	}
+}
@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// PredictionRequest is the JSON payload sent to the prediction backend
+type PredictionRequest struct {
+	DocumentID uint         `json:"documentId"`
+	Name       string       `json:"name"`
+	Text       string       `json:"text"`
+	Topics     []string     `json:"topics"`
+	Pages      []PageTokens `json:"pages"`
+}
+
+// PredictedSpan is a single span returned by the prediction backend
+type PredictedSpan struct {
+	CharacterStart uint    `json:"characterStart"`
+	CharacterEnd   uint    `json:"characterEnd"`
+	Topic          string  `json:"topic"`
+	Confidence     float64 `json:"confidence"`
+}
+
+// PredictionResponse is the JSON payload expected back from the prediction backend
+type PredictionResponse struct {
+	Model string          `json:"model"`
+	Spans []PredictedSpan `json:"spans"`
+}
+
+// predictionTimeout bounds the time a prediction backend takes to answer
+const predictionTimeout = 5 * time.Minute
+
+// Predictor pre-annotates a processed document
+type Predictor interface {
+	Predict(request PredictionRequest) (PredictionResponse, error)
+}
+
+// HTTPPredictor posts the request to an HTTP endpoint and reads the spans from the response body
+type HTTPPredictor struct {
+	URL    string
+	Client *http.Client
+}
+
+func (p HTTPPredictor) Predict(request PredictionRequest) (PredictionResponse, error) {
+	var response PredictionResponse
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, fmt.Errorf("Unable to marshal prediction request: %w", err)
+	}
+
+	res, err := p.Client.Post(p.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return response, fmt.Errorf("Unable to reach prediction endpoint: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("Prediction endpoint returned %s", res.Status)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return response, fmt.Errorf("Unable to decode prediction response: %w", err)
+	}
+
+	return response, nil
+}
+
+// CommandPredictor runs a local program with its arguments, writing the request on its stdin and reading the spans
+// from its stdout. The program is killed when it does not answer in time.
+type CommandPredictor struct {
+	Command string
+	Args    []string
+}
+
+func (p CommandPredictor) Predict(request PredictionRequest) (PredictionResponse, error) {
+	var response PredictionResponse
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, fmt.Errorf("Unable to marshal prediction request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), predictionTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.Output()
+	if ctx.Err() != nil {
+		return response, fmt.Errorf("Prediction command did not answer within %v: %w", predictionTimeout, ctx.Err())
+	}
+	if err != nil {
+		return response, fmt.Errorf("Prediction command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	err = json.Unmarshal(stdout, &response)
+	if err != nil {
+		return response, fmt.Errorf("Unable to decode prediction response: %w", err)
+	}
+
+	return response, nil
+}
+
+// Configured prediction backend, nil when pre-annotation is disabled
+var predictor Predictor
+
+// InitPredictor configures the prediction backend from either an HTTP endpoint or a command and its arguments
+func InitPredictor(endpoint, command string, args []string) error {
+	if endpoint != "" && command != "" {
+		return fmt.Errorf("Only one of the prediction endpoint and command can be set")
+	}
+
+	if endpoint != "" {
+		log.Printf("Pre-annotating documents with %v", endpoint)
+		predictor = HTTPPredictor{URL: endpoint, Client: &http.Client{Timeout: predictionTimeout}}
+	}
+
+	if command != "" {
+		log.Printf("Pre-annotating documents with %q %q", command, args)
+		predictor = CommandPredictor{Command: command, Args: args}
+	}
+
+	return nil
+}
+
+func loadTopicIDs(q querier, projectID uint) (map[string]uint, error) {
+	rows, err := q.Query("SELECT topic_id, topic FROM topics WHERE project_id = ?", projectID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query topics: %w", err)
+	}
+	defer rows.Close()
+
+	topicIDs := map[string]uint{}
+
+	for rows.Next() {
+		var topicID uint
+		var topic string
+
+		err = rows.Scan(&topicID, &topic)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read topic: %w", err)
+		}
+
+		topicIDs[topic] = topicID
+	}
+
+	return topicIDs, rows.Err()
+}
+
+// PredictDocument sends a processed document to the prediction backend and stores the returned spans as suggested annotations
+func PredictDocument(documentID uint) error {
+	if predictor == nil {
+		return nil
+	}
+
+	request := PredictionRequest{DocumentID: documentID, Topics: []string{}}
+	var projectID uint
+
+	err := db.QueryRow("SELECT project_id, name, COALESCE(text, '') FROM documents WHERE document_id = ?", documentID).Scan(&projectID, &request.Name, &request.Text)
+	if err != nil {
+		return fmt.Errorf("Unable to read document: %w", err)
+	}
+
+	request.Pages, err = loadDocumentTokens(db, documentID)
+	if err != nil {
+		return err
+	}
+
+	topicIDs, err := loadTopicIDs(db, projectID)
+	if err != nil {
+		return err
+	}
+
+	for topic := range topicIDs {
+		request.Topics = append(request.Topics, topic)
+	}
+
+	log.Printf("Requesting predictions for document id %d\n", documentID)
+
+	response, err := predictor.Predict(request)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Cannot make transaction: %w", err)
+	}
+
+	// Predictions nobody reviewed yet are replaced by the new ones
+	replaced, err := queryAnnotations(tx, AnnotationFilter{DocumentID: documentID, Sources: []string{SourceModel}, Statuses: []string{StatusSuggested}})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	relations, err := queryRelations(tx, documentID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM annotations WHERE document_id = ? AND source = ? AND status = ?", documentID, SourceModel, StatusSuggested)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Unable to clear previous suggestions: %w", err)
+	}
+
+	err = recordAnnotationDeletions(tx, 0, documentID, replaced, relations, nil)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	stored := 0
+	for _, span := range response.Spans {
+		topicID, ok := topicIDs[span.Topic]
+		if !ok {
+			log.Printf("Skipping prediction with unknown topic %q\n", span.Topic)
+			continue
+		}
+
+		if span.CharacterEnd <= span.CharacterStart || span.CharacterEnd > uint(len(request.Text)) {
+			log.Printf("Skipping prediction with invalid span %d-%d\n", span.CharacterStart, span.CharacterEnd)
+			continue
+		}
+
+		location, err := locateSpan(request.Pages, span.CharacterStart, span.CharacterEnd)
+		if err != nil {
+			log.Printf("Skipping prediction: %v\n", err)
+			continue
+		}
+
+		text := request.Text[span.CharacterStart:span.CharacterEnd]
+
+		value, valueError, err := normalizeAnnotation(tx, topicID, text)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		res, err := tx.Exec(`INSERT INTO annotations (document_id, character_start, character_end, page_start, page_end, text, top_px, left_px, topic_id, status, source, confidence, model, value, value_error)
+								    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			documentID, span.CharacterStart, span.CharacterEnd, location.PageStart, location.PageEnd,
+			text, location.Top, location.Left, topicID, StatusSuggested, SourceModel, span.Confidence, response.Model, value, valueError)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Unable to insert suggestion: %w", err)
+		}
+
+		id, _ := res.LastInsertId()
+		suggestion, err := getAnnotation(tx, documentID, uint(id))
+		if err == nil {
+			err = recordAnnotationChange(tx, 0, documentID, nil, &suggestion)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		stored++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Unable to commit suggestions: %w", err)
+	}
+
+	log.Printf("Stored %d suggestions from model %q for document id %d\n", stored, response.Model, documentID)
+
+	Broadcast(projectID, fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d}`, documentID))
+
+	return nil
+}
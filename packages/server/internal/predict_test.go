@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// fixedPredictor returns the same response for every document
+type fixedPredictor PredictionResponse
+
+func (p fixedPredictor) Predict(request PredictionRequest) (PredictionResponse, error) {
+	return PredictionResponse(p), nil
+}
+
+func TestPredictDocumentStoresModel(t *testing.T) {
+	defer openTestDatabase(t)()
+	mustExec(t, "INSERT INTO topics (topic) VALUES ('Party')")
+	documentID := insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme", "Corp"})
+
+	predictor = fixedPredictor{Model: "ner-v2", Spans: []PredictedSpan{{CharacterStart: 0, CharacterEnd: 9, Topic: "Party", Confidence: 0.8}}}
+	defer func() { predictor = nil }()
+
+	if err := PredictDocument(documentID); err != nil {
+		t.Fatal(err)
+	}
+
+	annotations, err := queryAnnotations(db, AnnotationFilter{DocumentID: documentID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 1 {
+		t.Fatalf("stored %d suggestions, want 1", len(annotations))
+	}
+
+	a := annotations[0]
+	if a.Status != StatusSuggested || a.Source != SourceModel || a.Model != "ner-v2" || a.Confidence == nil || *a.Confidence != 0.8 {
+		t.Errorf("stored %s %s from %q with confidence %v, want a suggestion of ner-v2 with confidence 0.8", a.Status, a.Source, a.Model, a.Confidence)
+	}
+}
+
+func TestCommandPredictor(t *testing.T) {
+	// The script is a single argument, spaces and quotes included
+	p := CommandPredictor{Command: "sh", Args: []string{"-c", `cat > /dev/null; echo '{"model": "stub v1", "spans": []}'`}}
+	response, err := p.Predict(PredictionRequest{Topics: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Model != "stub v1" {
+		t.Errorf("got model %q, want stub v1", response.Model)
+	}
+
+	p = CommandPredictor{Command: "sh", Args: []string{"-c", "echo model not found >&2; exit 3"}}
+	_, err = p.Predict(PredictionRequest{Topics: []string{}})
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || !strings.Contains(err.Error(), "model not found") {
+		t.Errorf("got error %v, want the exit status and the stderr of the command", err)
+	}
+}
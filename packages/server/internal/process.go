@@ -183,24 +183,38 @@ func insertDocumentData(documentID uint, pagesPath string, pageCount uint) error
 	return nil
 }
 
-func ProcessDocument(uploadPath, fileID, fileName string) error {
+func ProcessDocument(uploadPath, fileID, fileName string, projectID, userID uint) error {
 	filePath := uploadPath + "/" + fileID
 	tmpPath := filePath + "-tmp"
 
 	log.Printf("Adding document %s in the database", fileName)
 
-	res, err := db.Exec("INSERT INTO documents (name) VALUES (?)", fileName)
+	// The uploaded file is kept with the document, for backups
+	original, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("Unable to read uploaded file: %v", err)
+	}
+
+	res, err := db.Exec("INSERT INTO documents (project_id, name, original) VALUES (?, ?, ?)", projectID, fileName, original)
 	if err != nil {
 		return fmt.Errorf("Unable to insert document: %v", err)
 	}
 
-	Broadcast(`{"type":"documentsChanged"}`)
+	Broadcast(projectID, `{"type":"documentsChanged"}`)
 
 	documentID, err := res.LastInsertId()
 	if err != nil {
 		return fmt.Errorf("Unable to get the ID of the inserted document: %v", err)
 	}
 
+	document, err := getDocumentSummary(db, uint(documentID))
+	if err == nil {
+		err = recordDocumentChange(db, userID, nil, &document)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to record the document in the audit log: %v", err)
+	}
+
 	log.Printf("Creating temporary folder %s\n", tmpPath)
 
 	err = os.MkdirAll(tmpPath, 0755)
@@ -266,7 +280,13 @@ func ProcessDocument(uploadPath, fileID, fileName string) error {
 		return fmt.Errorf("Error insert document: %v", err)
 	}
 
-	Broadcast(`{"type":"documentsChanged"}`)
+	Broadcast(projectID, `{"type":"documentsChanged"}`)
+
+	// A failing model must not fail the upload, the document can still be annotated by hand
+	err = PredictDocument(uint(documentID))
+	if err != nil {
+		log.Printf("Unable to pre-annotate document: %v", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultProjectID is the project created with the database, used by the routes which are not scoped to a project
+const DefaultProjectID = 1
+
+// projectID returns the project of a project-scoped route, or the default project
+func projectID(r *http.Request) uint {
+	projectID, err := strconv.Atoi(mux.Vars(r)["projectId"])
+	if err != nil {
+		return DefaultProjectID
+	}
+
+	return uint(projectID)
+}
+
+func documentProject(q querier, documentID uint) (uint, error) {
+	var projectID uint
+
+	err := q.QueryRow("SELECT project_id FROM documents WHERE document_id = ?", documentID).Scan(&projectID)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read project of document %d: %w", documentID, err)
+	}
+
+	return projectID, nil
+}
+
+func topicProject(q querier, topicID uint) (uint, error) {
+	var projectID uint
+
+	err := q.QueryRow("SELECT project_id FROM topics WHERE topic_id = ?", topicID).Scan(&projectID)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read project of topic %d: %w", topicID, err)
+	}
+
+	return projectID, nil
+}
+
+func relationTypeProject(q querier, relationTypeID uint) (uint, error) {
+	var projectID uint
+
+	err := q.QueryRow("SELECT project_id FROM relation_types WHERE relation_type_id = ?", relationTypeID).Scan(&projectID)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read project of relation type %d: %w", relationTypeID, err)
+	}
+
+	return projectID, nil
+}
+
+// checkDocumentTopic makes sure a topic can be used to annotate a document
+func checkDocumentTopic(q querier, documentID, topicID uint) error {
+	documentProjectID, err := documentProject(q, documentID)
+	if err != nil {
+		return err
+	}
+
+	topicProjectID, err := topicProject(q, topicID)
+	if err != nil {
+		return err
+	}
+
+	if documentProjectID != topicProjectID {
+		return fmt.Errorf("Topic %d does not belong to the project of document %d", topicID, documentID)
+	}
+
+	return nil
+}
+
+func getProject(q querier, projectID uint) (Project, error) {
+	var project Project
+	var settings string
+
+	err := q.QueryRow("SELECT project_id, name, settings FROM projects WHERE project_id = ?", projectID).
+		Scan(&project.ProjectID, &project.Name, &settings)
+	if err != nil {
+		return project, err
+	}
+
+	err = json.Unmarshal([]byte(settings), &project.Settings)
+	if err != nil {
+		return project, fmt.Errorf("Unable to unmarshal settings of project %d: %w", projectID, err)
+	}
+
+	return project, nil
+}
+
+// GetProjectsHandler lists the projects the user has a role in
+func GetProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT project_id FROM projects ORDER BY project_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	projectIDs := []uint{}
+	for rows.Next() {
+		var projectID uint
+
+		err = rows.Scan(&projectID)
+		if err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		projectIDs = append(projectIDs, projectID)
+	}
+	rows.Close()
+
+	projects := Projects{}
+
+	for _, projectID := range projectIDs {
+		role, err := userRole(db, currentUser(r), projectID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !hasRole(role, RoleReader) {
+			continue
+		}
+
+		project, err := getProject(db, projectID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		projects = append(projects, project)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(projects)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func GetProjectHandler(w http.ResponseWriter, r *http.Request) {
+	project, err := getProject(db, projectID(r))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(project)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func PostProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	var project Project
+	err := json.NewDecoder(r.Body).Decode(&project)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if project.Name == "" {
+		http.Error(w, "Project name is required", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := marshalAttributes(project.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec("INSERT INTO projects (name, settings) VALUES (?, ?)", project.Name, settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, _ := res.LastInsertId()
+	project, err = getProject(db, uint(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(project)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	Broadcast(0, `{"type":"projectsChanged"}`)
+}
+
+// PatchProjectHandler renames a project or updates its settings, merging the given keys
+func PatchProjectHandler(w http.ResponseWriter, r *http.Request) {
+	project, err := getProject(db, projectID(r))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Fields missing from the body keep their current value
+	err = json.NewDecoder(r.Body).Decode(&project)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := marshalAttributes(project.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("UPDATE projects SET name = ?, settings = ? WHERE project_id = ?", project.Name, settings, projectID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	Broadcast(0, `{"type":"projectsChanged"}`)
+}
+
+// DeleteProjectHandler deletes a project with its documents and topics, the default project cannot be deleted
+func DeleteProjectHandler(w http.ResponseWriter, r *http.Request) {
+	if projectID(r) == DefaultProjectID {
+		http.Error(w, "The default project cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	_, err := db.Exec("DELETE FROM projects WHERE project_id = ?", projectID(r))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	Broadcast(0, `{"type":"projectsChanged"}`)
+}
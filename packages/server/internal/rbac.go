@@ -0,0 +1,376 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Roles, each one can do everything the previous ones can
+const (
+	RoleReader    = "reader"
+	RoleAnnotator = "annotator"
+	RoleReviewer  = "reviewer"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleReader:    1,
+	RoleAnnotator: 2,
+	RoleReviewer:  3,
+	RoleAdmin:     4,
+}
+
+func validRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// hasRole tells if a role grants the rights of the required one, users without a role in a project cannot even read it
+func hasRole(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+func forbidden(w http.ResponseWriter, message string) {
+	http.Error(w, "Forbidden: "+message, http.StatusForbidden)
+}
+
+// userRole returns the highest role of a user in a project, from the assignments to this project and to all projects,
+// limited by the scope of the API token the user authenticated with.
+// With a projectID of 0, only the assignments to all projects are used.
+func userRole(q querier, user User, projectID uint) (string, error) {
+	rows, err := q.Query("SELECT role FROM role_assignments WHERE user_id = ? AND (project_id IS NULL OR project_id = ?)", user.UserID, projectID)
+	if err != nil {
+		return "", fmt.Errorf("Unable to query roles: %w", err)
+	}
+	defer rows.Close()
+
+	highest := ""
+	for rows.Next() {
+		var role string
+
+		err = rows.Scan(&role)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read role: %w", err)
+		}
+
+		if roleRanks[role] > roleRanks[highest] {
+			highest = role
+		}
+	}
+
+	if limit, ok := scopeRoles[user.Scope]; ok && roleRanks[highest] > roleRanks[limit] {
+		highest = limit
+	}
+
+	return highest, rows.Err()
+}
+
+// requestProject returns the project a request acts on, from the document, topic, relation type, task or audit entry of the route
+func requestProject(r *http.Request) (uint, error) {
+	params := mux.Vars(r)
+
+	if documentID, err := strconv.Atoi(params["documentId"]); err == nil {
+		return documentProject(db, uint(documentID))
+	}
+
+	if topicID, err := strconv.Atoi(params["topicId"]); err == nil {
+		return topicProject(db, uint(topicID))
+	}
+
+	if relationTypeID, err := strconv.Atoi(params["relationTypeId"]); err == nil {
+		return relationTypeProject(db, uint(relationTypeID))
+	}
+
+	if taskID, err := strconv.Atoi(params["taskId"]); err == nil {
+		return taskProject(db, uint(taskID))
+	}
+
+	if auditID, err := strconv.Atoi(params["auditId"]); err == nil {
+		return auditProject(db, uint(auditID))
+	}
+
+	return projectID(r), nil
+}
+
+// requireRole only lets through the users with at least the given role in the project of the request
+func requireRole(required string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID, err := requestProject(r)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		role, err := userRole(db, currentUser(r), projectID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !hasRole(role, required) {
+			forbidden(w, fmt.Sprintf("the %s role is required in project %d", required, projectID))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireAdmin only lets through the users who are admins of all projects
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, err := userRole(db, currentUser(r), 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !hasRole(role, RoleAdmin) {
+			forbidden(w, "the admin role is required for all projects")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireAnnotationOwner lets annotators change their own annotations, and reviewers those of anyone
+func requireAnnotationOwner(next http.HandlerFunc) http.HandlerFunc {
+	return requireOwner("SELECT user_id FROM annotations WHERE document_id = ? AND annotation_id = ?", "annotationId",
+		"Annotation not found", "annotators can only change their own annotations, the reviewer role is required", next)
+}
+
+// requireRelationOwner lets annotators change the relations they created, and reviewers those of anyone
+func requireRelationOwner(next http.HandlerFunc) http.HandlerFunc {
+	return requireOwner("SELECT user_id FROM relations WHERE document_id = ? AND relation_id = ?", "relationId",
+		"Relation not found", "annotators can only change their own relations, the reviewer role is required", next)
+}
+
+// requireOwner lets reviewers through, and annotators when the user_id selected by query from the document and the
+// entity of the idParam route parameter is theirs
+func requireOwner(query, idParam, notFound, message string, next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(RoleAnnotator, func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		documentID, _ := strconv.Atoi(params["documentId"])
+		entityID, _ := strconv.Atoi(params[idParam])
+
+		projectID, err := documentProject(db, uint(documentID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		role, err := userRole(db, currentUser(r), projectID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if hasRole(role, RoleReviewer) {
+			next(w, r)
+			return
+		}
+
+		var userID sql.NullInt64
+		err = db.QueryRow(query, documentID, entityID).Scan(&userID)
+		if err == sql.ErrNoRows {
+			http.Error(w, notFound, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !userID.Valid || uint(userID.Int64) != currentUser(r).UserID {
+			forbidden(w, message)
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// queryRoleAssignments returns the role assignments of a user, or of everyone when userID is 0
+func queryRoleAssignments(q querier, userID uint) (RoleAssignments, error) {
+	query := "SELECT role_assignment_id, user_id, project_id, role FROM role_assignments"
+	args := []interface{}{}
+
+	if userID != 0 {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+
+	rows, err := q.Query(query+" ORDER BY role_assignment_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query role assignments: %w", err)
+	}
+	defer rows.Close()
+
+	assignments := RoleAssignments{}
+
+	for rows.Next() {
+		var assignment RoleAssignment
+
+		err = rows.Scan(&assignment.RoleAssignmentID, &assignment.UserID, &assignment.ProjectID, &assignment.Role)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read role assignment: %w", err)
+		}
+
+		assignments = append(assignments, assignment)
+	}
+
+	return assignments, rows.Err()
+}
+
+// countAdmins counts the users who are admins of all projects
+func countAdmins(q querier) (int, error) {
+	var count int
+
+	err := q.QueryRow("SELECT COUNT(DISTINCT user_id) FROM role_assignments WHERE project_id IS NULL AND role = ?", RoleAdmin).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to count admins: %w", err)
+	}
+
+	return count, nil
+}
+
+func GetRolesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.URL.Query().Get("userId"))
+
+	assignments, err := queryRoleAssignments(db, uint(userID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(assignments)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// PostRolesHandler gives a role to a user in a project or in all projects, replacing the previous one
+func PostRolesHandler(w http.ResponseWriter, r *http.Request) {
+	var assignment RoleAssignment
+	err := json.NewDecoder(r.Body).Decode(&assignment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !validRole(assignment.Role) {
+		http.Error(w, fmt.Sprintf("Invalid role %q, expected reader, annotator, reviewer or admin", assignment.Role), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = getUser(tx, assignment.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("User %d does not exist", assignment.UserID), http.StatusBadRequest)
+		return
+	}
+
+	// project_id IS ? matches NULL too, for the assignments to all projects
+	_, err = tx.Exec("DELETE FROM role_assignments WHERE user_id = ? AND project_id IS ?", assignment.UserID, assignment.ProjectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := tx.Exec("INSERT INTO role_assignments (user_id, project_id, role) VALUES (?, ?, ?)",
+		assignment.UserID, assignment.ProjectID, assignment.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	count, err := countAdmins(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if count == 0 {
+		http.Error(w, "At least one user must stay admin of all projects", http.StatusConflict)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id, _ := res.LastInsertId()
+	assignment.RoleAssignmentID = uint(id)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(assignment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	roleAssignmentID, _ := strconv.Atoi(params["roleId"])
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM role_assignments WHERE role_assignment_id = ?", roleAssignmentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		http.Error(w, "Role assignment not found", http.StatusNotFound)
+		return
+	}
+
+	count, err := countAdmins(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if count == 0 {
+		http.Error(w, "At least one user must stay admin of all projects", http.StatusConflict)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
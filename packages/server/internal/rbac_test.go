@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestReadRequiresRole(t *testing.T) {
+	defer openTestDatabase(t)()
+
+	reader := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('reader', 'hash', '2020-01-01')")
+	stranger := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('stranger', 'hash', '2020-01-01')")
+	otherID := mustExec(t, "INSERT INTO projects (name) VALUES ('Other')")
+	mustExec(t, "INSERT INTO role_assignments (user_id, project_id, role) VALUES (?, ?, 'reader')", reader, otherID)
+	documentID := insertTestDocument(t, otherID, "contract.pdf", []string{"Acme"})
+
+	tests := []struct {
+		name   string
+		userID uint
+		vars   map[string]string
+		status int
+	}{
+		{"reader of the project", reader, map[string]string{"projectId": fmt.Sprint(otherID)}, http.StatusOK},
+		{"reader of the document", reader, map[string]string{"documentId": fmt.Sprint(documentID)}, http.StatusOK},
+		{"reader of another project", reader, map[string]string{"projectId": fmt.Sprint(DefaultProjectID)}, http.StatusForbidden},
+		{"without role", stranger, map[string]string{"documentId": fmt.Sprint(documentID)}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := GetDocumentsHandler
+			if _, ok := test.vars["documentId"]; ok {
+				handler = GetAnnotationsHandler
+			}
+
+			w := serveAs(requireRole(RoleReader, handler), test.userID, http.MethodGet, "", test.vars)
+			if w.Code != test.status {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body.String(), test.status)
+			}
+		})
+	}
+
+	// Projects are only listed to the users with a role in them
+	for userID, want := range map[uint][]uint{reader: {otherID}, stranger: {}} {
+		w := serveAs(GetProjectsHandler, userID, http.MethodGet, "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d %s, want 200", w.Code, w.Body.String())
+		}
+
+		var projects Projects
+		err := json.Unmarshal(w.Body.Bytes(), &projects)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		projectIDs := []uint{}
+		for _, project := range projects {
+			projectIDs = append(projectIDs, project.ProjectID)
+		}
+		if !reflect.DeepEqual(projectIDs, want) {
+			t.Errorf("user %d got projects %v, want %v", userID, projectIDs, want)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func queryRelations(q querier, documentID uint) ([]Relation, error) {
+	rows, err := q.Query(`SELECT r.relation_id, rt.relation_type_id, rt.relation_type, r.from_annotation_id, r.to_annotation_id, r.user_id
+									FROM relations r
+									INNER JOIN relation_types rt ON rt.relation_type_id = r.relation_type_id
+									WHERE r.document_id = ?
+									ORDER BY r.relation_id`, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query relations: %w", err)
+	}
+	defer rows.Close()
+
+	relations := []Relation{}
+
+	for rows.Next() {
+		var relation Relation
+
+		err = rows.Scan(&relation.RelationID, &relation.RelationTypeID, &relation.RelationType, &relation.FromAnnotationID, &relation.ToAnnotationID, &relation.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read relation: %w", err)
+		}
+
+		relations = append(relations, relation)
+	}
+
+	return relations, rows.Err()
+}
+
+// checkRelationEnds makes sure both annotations of a relation exist in the document and are different,
+// and that the relation type belongs to the project of the document
+func checkRelationEnds(q querier, documentID uint, relation Relation) error {
+	if relation.FromAnnotationID == relation.ToAnnotationID {
+		return fmt.Errorf("An annotation cannot be related to itself")
+	}
+
+	var count int
+	err := q.QueryRow(`SELECT COUNT(*) FROM relation_types rt
+								INNER JOIN documents d ON d.project_id = rt.project_id
+								WHERE rt.relation_type_id = ? AND d.document_id = ?`, relation.RelationTypeID, documentID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("Unable to check relation type: %w", err)
+	}
+
+	if count != 1 {
+		return fmt.Errorf("Relation type %d does not belong to the project of document %d", relation.RelationTypeID, documentID)
+	}
+
+	err = q.QueryRow("SELECT COUNT(*) FROM annotations WHERE document_id = ? AND annotation_id IN (?, ?)",
+		documentID, relation.FromAnnotationID, relation.ToAnnotationID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("Unable to check annotations: %w", err)
+	}
+
+	if count != 2 {
+		return fmt.Errorf("Both annotations must belong to document %d", documentID)
+	}
+
+	return nil
+}
+
+func getRelation(q querier, documentID, relationID uint) (Relation, error) {
+	relations, err := queryRelations(q, documentID)
+	if err != nil {
+		return Relation{}, err
+	}
+
+	for _, relation := range relations {
+		if relation.RelationID == relationID {
+			return relation, nil
+		}
+	}
+
+	return Relation{}, sql.ErrNoRows
+}
+
+// insertRelation stores a new relation made by a user, or by nobody when userID is 0, and records it in the audit log.
+// The relation belongs to its UserID, or to the user making it when nil.
+func insertRelation(q querier, documentID uint, relation Relation, userID uint) (int64, error) {
+	owner := relation.UserID
+	if owner == nil && userID != 0 {
+		owner = &userID
+	}
+
+	res, err := q.Exec("INSERT INTO relations (document_id, relation_type_id, from_annotation_id, to_annotation_id, user_id) VALUES (?, ?, ?, ?, ?)",
+		documentID, relation.RelationTypeID, relation.FromAnnotationID, relation.ToAnnotationID, owner)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to insert relation: %w", err)
+	}
+
+	id, _ := res.LastInsertId()
+
+	created, err := getRelation(q, documentID, uint(id))
+	if err != nil {
+		return 0, err
+	}
+
+	return id, recordRelationChange(q, userID, documentID, nil, &created)
+}
+
+func GetRelationsHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	relations, err := queryRelations(db, uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(relations)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func PostRelationsHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	var relation Relation
+	err := json.NewDecoder(r.Body).Decode(&relation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	relation.UserID = nil
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = checkRelationEnds(tx, uint(documentID), relation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = insertRelation(tx, uint(documentID), relation, currentUser(r).UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	BroadcastDocument(uint(documentID), fmt.Sprintf(`{"type":"relationsChanged", "documentId":%d}`, documentID))
+}
+
+func PatchRelationHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+	relationID, _ := strconv.Atoi(params["relationId"])
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := getRelation(tx, uint(documentID), uint(relationID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Relation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Fields missing from the body keep their current value
+	relation := before
+	err = json.NewDecoder(r.Body).Decode(&relation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = checkRelationEnds(tx, uint(documentID), relation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec("UPDATE relations SET relation_type_id = ?, from_annotation_id = ?, to_annotation_id = ? WHERE relation_id = ?",
+		relation.RelationTypeID, relation.FromAnnotationID, relation.ToAnnotationID, relationID)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	after, err := getRelation(tx, uint(documentID), uint(relationID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = recordRelationChange(tx, currentUser(r).UserID, uint(documentID), &before, &after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	BroadcastDocument(uint(documentID), fmt.Sprintf(`{"type":"relationsChanged", "documentId":%d}`, documentID))
+}
+
+func DeleteRelationHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+	relationID, _ := strconv.Atoi(params["relationId"])
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	relation, err := getRelation(tx, uint(documentID), uint(relationID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Relation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec("DELETE FROM relations WHERE document_id = ? AND relation_id = ?", documentID, relationID)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = recordRelationChange(tx, currentUser(r).UserID, uint(documentID), &relation, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	BroadcastDocument(uint(documentID), fmt.Sprintf(`{"type":"relationsChanged", "documentId":%d}`, documentID))
+}
+
+func queryRelationTypes(q querier, projectID uint) (RelationTypes, error) {
+	rows, err := q.Query("SELECT relation_type_id, project_id, relation_type FROM relation_types WHERE project_id = ?", projectID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query relation types: %w", err)
+	}
+	defer rows.Close()
+
+	relationTypes := RelationTypes{}
+
+	for rows.Next() {
+		var relationType RelationType
+
+		err = rows.Scan(&relationType.RelationTypeID, &relationType.ProjectID, &relationType.RelationType)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read relation type: %w", err)
+		}
+
+		relationTypes = append(relationTypes, relationType)
+	}
+
+	return relationTypes, rows.Err()
+}
+
+func GetRelationTypesHandler(w http.ResponseWriter, r *http.Request) {
+	relationTypes, err := queryRelationTypes(db, projectID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(relationTypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func PostRelationTypesHandler(w http.ResponseWriter, r *http.Request) {
+	var relationType RelationType
+	err := json.NewDecoder(r.Body).Decode(&relationType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("INSERT INTO relation_types (project_id, relation_type) VALUES (?, ?)", projectID(r), relationType.RelationType)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	Broadcast(projectID(r), `{"type":"relationTypesChanged"}`)
+}
+
+// recordRelationTypeDeletion records the deletion of the relations of a type, in every document
+func recordRelationTypeDeletion(q querier, userID, relationTypeID uint) error {
+	rows, err := q.Query("SELECT DISTINCT document_id FROM relations WHERE relation_type_id = ? ORDER BY document_id", relationTypeID)
+	if err != nil {
+		return fmt.Errorf("Unable to query relations: %w", err)
+	}
+
+	documentIDs := []uint{}
+	for rows.Next() {
+		var documentID uint
+		if err = rows.Scan(&documentID); err != nil {
+			rows.Close()
+			return fmt.Errorf("Unable to read relation: %w", err)
+		}
+		documentIDs = append(documentIDs, documentID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, documentID := range documentIDs {
+		relations, err := queryRelations(q, documentID)
+		if err != nil {
+			return err
+		}
+
+		for _, relation := range relations {
+			if relation.RelationTypeID != relationTypeID {
+				continue
+			}
+
+			err = recordRelationChange(q, userID, documentID, &relation, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func DeleteRelationTypeHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	relationTypeID, _ := strconv.Atoi(params["relationTypeId"])
+
+	var projectID uint
+	err := db.QueryRow("SELECT project_id FROM relation_types WHERE relation_type_id = ?", relationTypeID).Scan(&projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// The relations of the type are deleted with it by the foreign key, they are recorded first
+	err = recordRelationTypeDeletion(tx, currentUser(r).UserID, uint(relationTypeID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec("DELETE FROM relation_types WHERE relation_type_id = ?", relationTypeID)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	Broadcast(projectID, `{"type":"relationTypesChanged"}`)
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+)
+
+func countRows(t *testing.T, table string) int {
+	t.Helper()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// The deletes of the handlers rely on the foreign keys to remove what belongs to the deleted rows
+func TestForeignKeyCascades(t *testing.T) {
+	tests := []struct {
+		name      string
+		delete    string
+		relations int
+		remaining map[string]int
+	}{
+		{"annotation", "DELETE FROM annotations WHERE annotation_id = 2", 0, map[string]int{"annotations": 2}},
+		{"document", "DELETE FROM documents WHERE document_id = 1", 0, map[string]int{"annotations": 0, "document_pages": 0}},
+		{"relation type", "DELETE FROM relation_types WHERE relation_type_id = 1", 0, map[string]int{"annotations": 3}},
+		{"topic", "DELETE FROM topics WHERE topic_id = 1", 0, map[string]int{"annotations": 1, "topics": 2}},
+		{"user", "DELETE FROM users WHERE user_id = 1", 2, map[string]int{"annotations": 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer openTestDatabase(t)()
+			mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('ann', 'hash', '2020-01-01')")
+			auditFixture(t)
+			mustExec(t, "UPDATE relations SET user_id = 1")
+
+			mustExec(t, test.delete)
+
+			if count := countRows(t, "relations"); count != test.relations {
+				t.Errorf("%d relations left, want %d", count, test.relations)
+			}
+			for table, want := range test.remaining {
+				if count := countRows(t, table); count != want {
+					t.Errorf("%d %s left, want %d", count, table, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRelationTypeDeletionRecordsRelations(t *testing.T) {
+	defer openTestDatabase(t)()
+	documentID, _, _ := auditFixture(t)
+
+	if err := recordRelationTypeDeletion(db, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := queryAuditLog(db, DefaultProjectID, documentID, []string{EntityRelation})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := 0
+	for _, entry := range entries {
+		if entry.Action == ActionDelete {
+			deleted++
+		}
+	}
+	if deleted != 2 {
+		t.Errorf("recorded %d deletions, want the 2 relations", deleted)
+	}
+}
+
+func TestRequireRelationOwner(t *testing.T) {
+	defer openTestDatabase(t)()
+	ann := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('ann', 'hash', '2020-01-01')")
+	other := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('other', 'hash', '2020-01-01')")
+	rev := mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('rev', 'hash', '2020-01-01')")
+	mustExec(t, "INSERT INTO role_assignments (user_id, project_id, role) VALUES (?, 1, 'annotator'), (?, 1, 'annotator'), (?, 1, 'reviewer')", ann, other, rev)
+	auditFixture(t)
+	mustExec(t, "UPDATE relations SET user_id = ?", ann)
+
+	tests := []struct {
+		name   string
+		userID uint
+		status int
+	}{
+		{"owner", ann, http.StatusOK},
+		{"other annotator", other, http.StatusForbidden},
+		{"reviewer", rev, http.StatusOK},
+	}
+
+	handler := requireRelationOwner(func(w http.ResponseWriter, r *http.Request) {})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serveAs(handler, test.userID, http.MethodDelete, "", map[string]string{"documentId": "1", "relationId": "1"})
+			if w.Code != test.status {
+				t.Errorf("got %d, want %d", w.Code, test.status)
+			}
+		})
+	}
+}
@@ -23,19 +23,104 @@ func NewRouter(uploadDir, webBuildDir string) (http.Handler, error) {
 	}
 
 	r.Use(loggingMiddleware) // comment if you don't want all the logging
+	r.Use(authMiddleware)
 
-	r.HandleFunc("/documents", GetDocumentsHandler).Methods(http.MethodGet)
-	r.HandleFunc("/document/{documentId}", GetDocumentHandler).Methods(http.MethodGet)
-	r.HandleFunc("/document/{documentId}", DeleteDocumentHandler).Methods(http.MethodDelete)
-	r.HandleFunc("/document/{documentId}/annotations", GetAnnotationsHandler).Methods(http.MethodGet)
-	r.HandleFunc("/document/{documentId}/annotations", PostAnnotationsHandler).Methods(http.MethodPost)
-	r.HandleFunc("/document/{documentId}/annotation/{annotationId}", DeleteAnnotationHandler).Methods(http.MethodDelete)
-	r.HandleFunc("/document/{documentId}/page/{pageNumber}/tokens", GetTokensHandler).Methods(http.MethodGet)
-	r.HandleFunc("/document/{documentId}/page/{pageNumber}/image", GetImageHandler).Methods(http.MethodGet)
-
-	r.HandleFunc("/topics", GetTopicsHandler).Methods(http.MethodGet)
-	r.HandleFunc("/topics", PostTopicsHandler).Methods(http.MethodPost)
-	r.HandleFunc("/topic/{topicId}", DeleteTopicHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/login", LoginHandler).Methods(http.MethodPost)
+	r.HandleFunc("/logout", LogoutHandler).Methods(http.MethodPost)
+	r.HandleFunc("/me", GetMeHandler).Methods(http.MethodGet)
+	r.HandleFunc("/users", GetUsersHandler).Methods(http.MethodGet)
+	r.HandleFunc("/users", PostUsersHandler).Methods(http.MethodPost) // admins only, checked by the handler to let the first account be created
+	r.HandleFunc("/apiTokens", GetAPITokensHandler).Methods(http.MethodGet)
+	r.HandleFunc("/apiTokens", PostAPITokensHandler).Methods(http.MethodPost)
+	r.HandleFunc("/apiToken/{apiTokenId}", DeleteAPITokenHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/roles", requireAdmin(GetRolesHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/roles", requireAdmin(PostRolesHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/role/{roleId}", requireAdmin(DeleteRoleHandler)).Methods(http.MethodDelete)
+
+	// Backups hold the accounts of the users, only admins of all projects take and restore them
+	r.HandleFunc("/backup", requireAdmin(GetBackupHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/restore", requireAdmin(PostRestoreHandler)).Methods(http.MethodPost)
+
+	r.HandleFunc("/projects", GetProjectsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/projects", requireAdmin(PostProjectsHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/project/{projectId}", requireRole(RoleReader, GetProjectHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}", requireRole(RoleAdmin, PatchProjectHandler)).Methods(http.MethodPatch)
+	r.HandleFunc("/project/{projectId}", requireAdmin(DeleteProjectHandler)).Methods(http.MethodDelete)
+	r.HandleFunc("/project/{projectId}/backup", requireAdmin(GetBackupHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/restore", requireAdmin(PostRestoreHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/project/{projectId}/documents", requireRole(RoleReader, GetDocumentsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/topics", requireRole(RoleReader, GetTopicsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/topics", requireRole(RoleReviewer, PostTopicsHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/project/{projectId}/relationTypes", requireRole(RoleReader, GetRelationTypesHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/agreement", requireRole(RoleReader, GetAgreementHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/audit", requireRole(RoleReader, GetAuditLogHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/annotations/import", requireRole(RoleAnnotator, PostImportHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/project/{projectId}/export/conll", requireRole(RoleReader, GetCoNLLHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/export/jsonl", requireRole(RoleReader, GetJSONLHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/export/funsd", requireRole(RoleReader, GetFUNSDHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/export/coco", requireRole(RoleReader, GetCOCOHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/export/brat", requireRole(RoleReader, GetBratHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/export/labelstudio", requireRole(RoleReader, GetLabelStudioHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/export/doccano", requireRole(RoleReader, GetDoccanoHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/tasks", requireRole(RoleReader, GetTasksHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/tasks", requireRole(RoleReviewer, PostTasksHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/project/{projectId}/tasks/next", requireRole(RoleAnnotator, GetNextTaskHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/project/{projectId}/relationTypes", requireRole(RoleReviewer, PostRelationTypesHandler)).Methods(http.MethodPost)
+
+	// /documents, /agreement, /annotations/import, /audit, /export, /tasks, /topics and /relationTypes are those of the default project
+	r.HandleFunc("/documents", requireRole(RoleReader, GetDocumentsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/agreement", requireRole(RoleReader, GetAgreementHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/audit", requireRole(RoleReader, GetAuditLogHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/audit/{auditId}/undo", requireRole(RoleReviewer, UndoHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/annotations/import", requireRole(RoleAnnotator, PostImportHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/export/conll", requireRole(RoleReader, GetCoNLLHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/export/jsonl", requireRole(RoleReader, GetJSONLHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/export/funsd", requireRole(RoleReader, GetFUNSDHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/export/coco", requireRole(RoleReader, GetCOCOHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/export/brat", requireRole(RoleReader, GetBratHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/export/labelstudio", requireRole(RoleReader, GetLabelStudioHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/export/doccano", requireRole(RoleReader, GetDoccanoHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/tasks", requireRole(RoleReader, GetTasksHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/tasks", requireRole(RoleReviewer, PostTasksHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/tasks/next", requireRole(RoleAnnotator, GetNextTaskHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/task/{taskId}/done", UpdateTaskHandler(TaskDone)).Methods(http.MethodPost)
+	r.HandleFunc("/task/{taskId}/release", UpdateTaskHandler(TaskAssigned)).Methods(http.MethodPost)
+	r.HandleFunc("/task/{taskId}", requireRole(RoleReviewer, DeleteTaskHandler)).Methods(http.MethodDelete)
+	r.HandleFunc("/document/{documentId}", requireRole(RoleReader, GetDocumentHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/document/{documentId}", requireRole(RoleAdmin, DeleteDocumentHandler)).Methods(http.MethodDelete)
+	r.HandleFunc("/document/{documentId}/status", requireRole(RoleAnnotator, PostDocumentStatusHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/transitions", requireRole(RoleReader, GetDocumentTransitionsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/document/{documentId}/audit", requireRole(RoleReader, GetDocumentAuditLogHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/document/{documentId}/export/conll", requireRole(RoleReader, GetDocumentCoNLLHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/document/{documentId}/annotations", requireRole(RoleReader, GetAnnotationsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/document/{documentId}/annotations", requireRole(RoleAnnotator, PostAnnotationsHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/annotations/import", requireRole(RoleAnnotator, PostDocumentImportHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/layers", requireRole(RoleReader, GetLayersHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/document/{documentId}/adjudication", requireRole(RoleReviewer, GetAdjudicationHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/document/{documentId}/gold", requireRole(RoleReviewer, PostGoldHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/annotation/{annotationId}", requireAnnotationOwner(PatchAnnotationHandler)).Methods(http.MethodPatch)
+	r.HandleFunc("/document/{documentId}/annotation/{annotationId}", requireAnnotationOwner(DeleteAnnotationHandler)).Methods(http.MethodDelete)
+	r.HandleFunc("/document/{documentId}/annotation/{annotationId}/accept", requireRole(RoleReviewer, ReviewAnnotationHandler(StatusAccepted))).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/annotation/{annotationId}/reject", requireRole(RoleReviewer, ReviewAnnotationHandler(StatusRejected))).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/annotations/accept", requireRole(RoleReviewer, ReviewAnnotationsHandler(StatusAccepted))).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/annotations/reject", requireRole(RoleReviewer, ReviewAnnotationsHandler(StatusRejected))).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/relations", requireRole(RoleReader, GetRelationsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/document/{documentId}/relations", requireRole(RoleAnnotator, PostRelationsHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/relation/{relationId}", requireRelationOwner(PatchRelationHandler)).Methods(http.MethodPatch)
+	r.HandleFunc("/document/{documentId}/relation/{relationId}", requireRelationOwner(DeleteRelationHandler)).Methods(http.MethodDelete)
+	r.HandleFunc("/document/{documentId}/predict", requireRole(RoleAnnotator, PostPredictHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/document/{documentId}/page/{pageNumber}/tokens", requireRole(RoleReader, GetTokensHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/document/{documentId}/page/{pageNumber}/image", requireRole(RoleReader, GetImageHandler)).Methods(http.MethodGet)
+
+	r.HandleFunc("/topics", requireRole(RoleReader, GetTopicsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/topics", requireRole(RoleReviewer, PostTopicsHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/topic/{topicId}", requireRole(RoleReviewer, PatchTopicHandler)).Methods(http.MethodPatch)
+	r.HandleFunc("/topic/{topicId}", requireRole(RoleAdmin, DeleteTopicHandler)).Methods(http.MethodDelete)
+	r.HandleFunc("/topic/{topicId}/merge", requireRole(RoleAdmin, MergeTopicHandler)).Methods(http.MethodPost)
+
+	r.HandleFunc("/relationTypes", requireRole(RoleReader, GetRelationTypesHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/relationTypes", requireRole(RoleReviewer, PostRelationTypesHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/relationType/{relationTypeId}", requireRole(RoleAdmin, DeleteRelationTypeHandler)).Methods(http.MethodDelete)
 
 	r.HandleFunc("/ws", WebSocketHandler).Methods(http.MethodGet)
 
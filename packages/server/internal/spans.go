@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// PageTokens holds the tokens of a single page along with its dimensions
+type PageTokens struct {
+	Page           uint   `json:"page"`
+	OriginalHeight uint   `json:"originalHeight"`
+	OriginalWidth  uint   `json:"originalWidth"`
+	Tokens         Tokens `json:"tokens"`
+}
+
+// SpanLocation is where a character span starts and ends in the pages of a document
+type SpanLocation struct {
+	PageStart uint
+	PageEnd   uint
+	Top       uint
+	Left      uint
+}
+
+func loadDocumentTokens(q querier, documentID uint) ([]PageTokens, error) {
+	rows, err := q.Query("SELECT page, height, width, tokens FROM document_pages WHERE document_id = ? ORDER BY page", documentID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query pages: %w", err)
+	}
+	defer rows.Close()
+
+	pages := []PageTokens{}
+
+	for rows.Next() {
+		var page PageTokens
+		var tokensBlob []byte
+
+		err = rows.Scan(&page.Page, &page.OriginalHeight, &page.OriginalWidth, &tokensBlob)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read page: %w", err)
+		}
+
+		err = json.Unmarshal(tokensBlob, &page.Tokens)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to unmarshal tokens of page %d: %w", page.Page, err)
+		}
+
+		pages = append(pages, page)
+	}
+
+	return pages, rows.Err()
+}
+
+// locateSpan finds the pages and the top/left position of the first token overlapping [start, end)
+func locateSpan(pages []PageTokens, start, end uint) (SpanLocation, error) {
+	var location SpanLocation
+	found := false
+
+	for _, page := range pages {
+		for _, token := range page.Tokens {
+			if token.CharacterEnd <= start || token.CharacterStart >= end {
+				continue
+			}
+
+			if !found {
+				location.PageStart = page.Page
+				location.Top = token.BoundingBox.Top
+				location.Left = token.BoundingBox.Left
+				found = true
+			}
+
+			location.PageEnd = page.Page
+		}
+	}
+
+	if !found {
+		return location, fmt.Errorf("No token between characters %d and %d", start, end)
+	}
+
+	return location, nil
+}
@@ -0,0 +1,561 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Task statuses
+const (
+	TaskAssigned = "assigned" // waiting for its user
+	TaskClaimed  = "claimed"  // being annotated, until the claim expires
+	TaskDone     = "done"
+)
+
+// Assignment strategies
+const (
+	AssignManual     = "manual"     // every document to every user
+	AssignRoundRobin = "roundRobin" // documents dealt to the users in turn
+	AssignBalanced   = "balanced"   // each document to the users with the fewest unfinished tasks
+)
+
+// ClaimDuration is how long a claimed document stays reserved to its annotator
+const ClaimDuration = 2 * time.Hour
+
+// claimMutex makes sure two annotators asking for their next task at the same time do not claim the same document
+var claimMutex sync.Mutex
+
+func validTaskStatus(status string) bool {
+	return status == TaskAssigned || status == TaskClaimed || status == TaskDone
+}
+
+const taskColumns = `t.task_id, t.document_id, d.name, d.project_id, t.user_id, t.status, t.assigned_by,
+									 t.created_at, t.claimed_at, t.expires_at, t.completed_at`
+
+func scanTask(row interface{ Scan(...interface{}) error }) (Task, error) {
+	var task Task
+
+	err := row.Scan(&task.TaskID, &task.DocumentID, &task.DocumentName, &task.ProjectID, &task.UserID, &task.Status, &task.AssignedBy,
+		&task.CreatedAt, &task.ClaimedAt, &task.ExpiresAt, &task.CompletedAt)
+	return task, err
+}
+
+func getTask(q querier, taskID uint) (Task, error) {
+	return scanTask(q.QueryRow(`SELECT `+taskColumns+` FROM tasks t
+									INNER JOIN documents d ON d.document_id = t.document_id
+									WHERE t.task_id = ?`, taskID))
+}
+
+func taskProject(q querier, taskID uint) (uint, error) {
+	var projectID uint
+
+	err := q.QueryRow("SELECT d.project_id FROM tasks t INNER JOIN documents d ON d.document_id = t.document_id WHERE t.task_id = ?", taskID).
+		Scan(&projectID)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read project of task %d: %w", taskID, err)
+	}
+
+	return projectID, nil
+}
+
+// expireTasks releases the claims which expired: documents claimed from the queue go back to the queue,
+// documents assigned to a user go back to that user
+func expireTasks(q querier) error {
+	now := time.Now().UTC()
+
+	_, err := q.Exec("DELETE FROM tasks WHERE status = ? AND expires_at <= ? AND assigned_by IS NULL", TaskClaimed, now)
+	if err != nil {
+		return fmt.Errorf("Unable to expire tasks: %w", err)
+	}
+
+	_, err = q.Exec("UPDATE tasks SET status = ?, claimed_at = NULL, expires_at = NULL WHERE status = ? AND expires_at <= ?",
+		TaskAssigned, TaskClaimed, now)
+	if err != nil {
+		return fmt.Errorf("Unable to expire tasks: %w", err)
+	}
+
+	return nil
+}
+
+// annotatorsPerDocument is how many annotators work on each document of a project, from its settings, 1 by default
+func annotatorsPerDocument(q querier, projectID uint) (int, error) {
+	project, err := getProject(q, projectID)
+	if err != nil {
+		return 0, err
+	}
+
+	if annotators, ok := project.Settings["annotatorsPerDocument"].(float64); ok && annotators >= 1 {
+		return int(annotators), nil
+	}
+
+	return 1, nil
+}
+
+// claimNextTask returns the task a user should work on in a project: the document already claimed,
+// else the first document assigned to the user, else the first processed document of the queue
+// which still needs annotators. It returns sql.ErrNoRows when there is nothing left to annotate.
+func claimNextTask(tx *sql.Tx, userID, projectID uint) (Task, error) {
+	err := expireTasks(tx)
+	if err != nil {
+		return Task{}, err
+	}
+
+	var taskID uint
+	err = tx.QueryRow(`SELECT t.task_id FROM tasks t
+								INNER JOIN documents d ON d.document_id = t.document_id
+								WHERE t.user_id = ? AND d.project_id = ? AND t.status IN (?, ?)
+								ORDER BY t.status = ? DESC, t.task_id
+								LIMIT 1`, userID, projectID, TaskClaimed, TaskAssigned, TaskClaimed).Scan(&taskID)
+
+	if err == sql.ErrNoRows {
+		annotators, err := annotatorsPerDocument(tx, projectID)
+		if err != nil {
+			return Task{}, err
+		}
+
+		var documentID uint
+		err = tx.QueryRow(`SELECT d.document_id FROM documents d
+									WHERE d.project_id = ? AND d.processed
+									AND NOT EXISTS (SELECT 1 FROM tasks t WHERE t.document_id = d.document_id AND t.user_id = ?)
+									AND (SELECT COUNT(*) FROM tasks t WHERE t.document_id = d.document_id) < ?
+									ORDER BY d.document_id
+									LIMIT 1`, projectID, userID, annotators).Scan(&documentID)
+		if err != nil {
+			return Task{}, err
+		}
+
+		res, err := tx.Exec("INSERT INTO tasks (document_id, user_id, status, created_at) VALUES (?, ?, ?, ?)",
+			documentID, userID, TaskAssigned, time.Now().UTC())
+		if err != nil {
+			return Task{}, fmt.Errorf("Unable to create task: %w", err)
+		}
+
+		id, _ := res.LastInsertId()
+		taskID = uint(id)
+	} else if err != nil {
+		return Task{}, fmt.Errorf("Unable to query tasks: %w", err)
+	}
+
+	// Claiming again a claimed document extends the claim
+	now := time.Now().UTC()
+	_, err = tx.Exec("UPDATE tasks SET status = ?, claimed_at = COALESCE(claimed_at, ?), expires_at = ? WHERE task_id = ?",
+		TaskClaimed, now, now.Add(ClaimDuration), taskID)
+	if err != nil {
+		return Task{}, fmt.Errorf("Unable to claim task: %w", err)
+	}
+
+	task, err := getTask(tx, taskID)
+	if err != nil {
+		return task, err
+	}
+
+	// Claiming a new document starts its annotation
+	_, err = advanceDocument(tx, task.DocumentID, DocumentNew, DocumentInProgress, userID)
+	return task, err
+}
+
+// hasDocumentTask tells if a document was handed to a user, whatever the status of the task
+func hasDocumentTask(q querier, documentID, userID uint) (bool, error) {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM tasks WHERE document_id = ? AND user_id = ?", documentID, userID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("Unable to query tasks: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// GetNextTaskHandler claims the next document the logged in user should annotate, 204 when there is none left
+func GetNextTaskHandler(w http.ResponseWriter, r *http.Request) {
+	claimMutex.Lock()
+	defer claimMutex.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	task, err := claimNextTask(tx, currentUser(r).UserID, projectID(r))
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(task)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	Broadcast(projectID(r), `{"type":"tasksChanged"}`)
+	Broadcast(projectID(r), `{"type":"documentsChanged"}`)
+}
+
+// GetTasksHandler lists the tasks of the logged in user in a project, or for reviewers those of ?userId=, filtered by
+// ?status=
+func GetTasksHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	userID := currentUser(r).UserID
+	if value := query.Get("userId"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid userId: %v", err), http.StatusBadRequest)
+			return
+		}
+		userID = uint(id)
+	}
+
+	if userID != currentUser(r).UserID {
+		role, err := userRole(db, currentUser(r), projectID(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !hasRole(role, RoleReviewer) {
+			forbidden(w, "the reviewer role is required to list the tasks of another user")
+			return
+		}
+	}
+
+	err := expireTasks(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tasksQuery := `SELECT ` + taskColumns + ` FROM tasks t
+									INNER JOIN documents d ON d.document_id = t.document_id
+									WHERE d.project_id = ? AND t.user_id = ?`
+	args := []interface{}{projectID(r), userID}
+
+	if statuses := query["status"]; len(statuses) > 0 {
+		for _, status := range statuses {
+			if !validTaskStatus(status) {
+				http.Error(w, fmt.Sprintf("Invalid status %q", status), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var clause string
+		clause, args = inClause("t.status", statuses, args)
+		tasksQuery += clause
+	}
+
+	rows, err := db.Query(tasksQuery+" ORDER BY t.task_id", args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	tasks := Tasks{}
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(tasks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// assignDocuments decides which users get each document of a project, according to the strategy
+func assignDocuments(q querier, projectID uint, request TaskAssignment) (map[uint][]uint, error) {
+	assignments := map[uint][]uint{}
+
+	switch request.Strategy {
+	case AssignManual, "":
+		for _, documentID := range request.DocumentIDs {
+			assignments[documentID] = request.UserIDs
+		}
+
+	case AssignRoundRobin:
+		next := 0
+		for _, documentID := range request.DocumentIDs {
+			for i := 0; i < request.Annotators && i < len(request.UserIDs); i++ {
+				assignments[documentID] = append(assignments[documentID], request.UserIDs[next%len(request.UserIDs)])
+				next++
+			}
+		}
+
+	case AssignBalanced:
+		open := map[uint]int{}
+		for _, userID := range request.UserIDs {
+			var count int
+
+			// Only the tasks of the project count, the users may be busy with other projects
+			err := q.QueryRow(`SELECT COUNT(*) FROM tasks t
+										INNER JOIN documents d ON d.document_id = t.document_id
+										WHERE t.user_id = ? AND t.status != ? AND d.project_id = ?`, userID, TaskDone, projectID).Scan(&count)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to count tasks: %w", err)
+			}
+
+			open[userID] = count
+		}
+
+		for _, documentID := range request.DocumentIDs {
+			users := append([]uint{}, request.UserIDs...)
+			sort.SliceStable(users, func(i, j int) bool { return open[users[i]] < open[users[j]] })
+
+			for i := 0; i < request.Annotators && i < len(users); i++ {
+				assignments[documentID] = append(assignments[documentID], users[i])
+				open[users[i]]++
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("Invalid strategy %q, expected manual, roundRobin or balanced", request.Strategy)
+	}
+
+	return assignments, nil
+}
+
+// PostTasksHandler assigns documents of a project to users, documents already assigned to a user are skipped
+func PostTasksHandler(w http.ResponseWriter, r *http.Request) {
+	var request TaskAssignment
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(request.DocumentIDs) == 0 || len(request.UserIDs) == 0 {
+		http.Error(w, "documentIds and userIds are required", http.StatusBadRequest)
+		return
+	}
+
+	if request.Annotators < 1 {
+		request.Annotators = 1
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, documentID := range request.DocumentIDs {
+		documentProjectID, err := documentProject(tx, documentID)
+		if err != nil || documentProjectID != projectID(r) {
+			http.Error(w, fmt.Sprintf("Document %d does not belong to project %d", documentID, projectID(r)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, userID := range request.UserIDs {
+		_, err = getUser(tx, userID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("User %d does not exist", userID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	assignments, err := assignDocuments(tx, projectID(r), request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tasks := Tasks{}
+	for _, documentID := range request.DocumentIDs {
+		for _, userID := range assignments[documentID] {
+			res, err := tx.Exec(`INSERT INTO tasks (document_id, user_id, status, assigned_by, created_at) VALUES (?, ?, ?, ?, ?)
+										ON CONFLICT (document_id, user_id) DO NOTHING`,
+				documentID, userID, TaskAssigned, currentUser(r).UserID, time.Now().UTC())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if count, _ := res.RowsAffected(); count == 0 {
+				continue
+			}
+
+			id, _ := res.LastInsertId()
+			task, err := getTask(tx, uint(id))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			tasks = append(tasks, task)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(tasks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	Broadcast(projectID(r), `{"type":"tasksChanged"}`)
+}
+
+// UpdateTaskHandler marks a task of the logged in user as done, or releases it with TaskAssigned,
+// giving a claimed document back to the queue, or to its assignee. Reviewers can change the tasks of anyone.
+func UpdateTaskHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		taskID, _ := strconv.Atoi(params["taskId"])
+
+		task, err := getTask(db, uint(taskID))
+		if err == sql.ErrNoRows {
+			http.Error(w, "Task not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if status == TaskAssigned && task.Status == TaskDone {
+			http.Error(w, "A done task cannot be released", http.StatusConflict)
+			return
+		}
+
+		if task.UserID != currentUser(r).UserID {
+			role, err := userRole(db, currentUser(r), task.ProjectID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if !hasRole(role, RoleReviewer) {
+				forbidden(w, "annotators can only change their own tasks, the reviewer role is required")
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		switch {
+		case status == TaskDone:
+			_, err = tx.Exec("UPDATE tasks SET status = ?, expires_at = NULL, completed_at = ? WHERE task_id = ?", TaskDone, time.Now().UTC(), taskID)
+		case task.AssignedBy == nil:
+			_, err = tx.Exec("DELETE FROM tasks WHERE task_id = ?", taskID)
+		default:
+			_, err = tx.Exec("UPDATE tasks SET status = ?, claimed_at = NULL, expires_at = NULL, completed_at = NULL WHERE task_id = ?", TaskAssigned, taskID)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// A task can be done without being claimed, which starts the annotation of the document all the same. The
+		// document is annotated once every annotator it was handed to is done, and it had enough of them.
+		moved := false
+		if status == TaskDone {
+			moved, err = advanceDocument(tx, task.DocumentID, DocumentNew, DocumentInProgress, currentUser(r).UserID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var open, done int
+			err = tx.QueryRow("SELECT COUNT(*) FILTER (WHERE status != ?), COUNT(*) FILTER (WHERE status = ?) FROM tasks WHERE document_id = ?",
+				TaskDone, TaskDone, task.DocumentID).Scan(&open, &done)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			annotators, err := annotatorsPerDocument(tx, task.ProjectID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if open == 0 && done >= annotators {
+				annotated, err := advanceDocument(tx, task.DocumentID, DocumentInProgress, DocumentAnnotated, currentUser(r).UserID)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				moved = moved || annotated
+			}
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		Broadcast(task.ProjectID, `{"type":"tasksChanged"}`)
+		if moved {
+			Broadcast(task.ProjectID, `{"type":"documentsChanged"}`)
+		}
+	}
+}
+
+func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	taskID, _ := strconv.Atoi(params["taskId"])
+
+	projectID, err := taskProject(db, uint(taskID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	_, err = db.Exec("DELETE FROM tasks WHERE task_id = ?", taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	Broadcast(projectID, `{"type":"tasksChanged"}`)
+}
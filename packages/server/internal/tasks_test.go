@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGetTasksOfAnotherUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   func(ann, other, rev uint) uint
+		status int
+	}{
+		{"own tasks", func(ann, other, rev uint) uint { return ann }, http.StatusOK},
+		{"other annotator", func(ann, other, rev uint) uint { return other }, http.StatusForbidden},
+		{"reviewer", func(ann, other, rev uint) uint { return rev }, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer openTestDatabase(t)()
+			_, _, ann, other, rev := workflowFixture(t)
+
+			w := serveAs(func(w http.ResponseWriter, r *http.Request) {
+				r.URL.RawQuery = fmt.Sprintf("userId=%d", ann)
+				GetTasksHandler(w, r)
+			}, test.user(ann, other, rev), http.MethodGet, "", nil)
+			if w.Code != test.status {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body.String(), test.status)
+			}
+		})
+	}
+}
+
+func TestReleaseDoneTask(t *testing.T) {
+	defer openTestDatabase(t)()
+	_, taskID, ann, _, _ := workflowFixture(t)
+	vars := map[string]string{"taskId": fmt.Sprint(taskID)}
+
+	if w := serveAs(UpdateTaskHandler(TaskDone), ann, http.MethodPost, "", vars); w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", w.Code, w.Body.String())
+	}
+
+	if w := serveAs(UpdateTaskHandler(TaskAssigned), ann, http.MethodPost, "", vars); w.Code != http.StatusConflict {
+		t.Errorf("got %d %s, want 409", w.Code, w.Body.String())
+	}
+
+	var status string
+	if err := db.QueryRow("SELECT status FROM tasks WHERE task_id = ?", taskID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != TaskDone {
+		t.Errorf("task is %s, want it done", status)
+	}
+}
+
+func TestAssignBalancedWithinProject(t *testing.T) {
+	defer openTestDatabase(t)()
+	_, _, ann, other, rev := workflowFixture(t)
+
+	// other is busy with another project, ann has one open task in this one
+	projectID := mustExec(t, "INSERT INTO projects (name) VALUES ('Other')")
+	for _, name := range []string{"a.pdf", "b.pdf"} {
+		documentID := insertTestDocument(t, projectID, name, []string{"Acme"})
+		mustExec(t, "INSERT INTO tasks (document_id, user_id, status, assigned_by, created_at) VALUES (?, ?, 'assigned', ?, '2020-01-01')", documentID, other, rev)
+	}
+	documentID := insertTestDocument(t, DefaultProjectID, "next.pdf", []string{"Acme"})
+
+	assignments, err := assignDocuments(db, DefaultProjectID, TaskAssignment{
+		DocumentIDs: []uint{documentID},
+		UserIDs:     []uint{ann, other},
+		Strategy:    AssignBalanced,
+		Annotators:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[uint][]uint{documentID: {other}}; !reflect.DeepEqual(assignments, want) {
+		t.Errorf("got %v, want %v", assignments, want)
+	}
+}
+
+func TestAssignDocuments(t *testing.T) {
+	defer openTestDatabase(t)()
+	_, _, ann, other, rev := workflowFixture(t)
+
+	// ann has the open task of the fixture, rev a done one
+	done := insertTestDocument(t, DefaultProjectID, "done.pdf", []string{"Acme"})
+	mustExec(t, "INSERT INTO tasks (document_id, user_id, status, assigned_by, created_at) VALUES (?, ?, 'done', ?, '2020-01-01')", done, rev, rev)
+
+	tests := []struct {
+		name    string
+		request TaskAssignment
+		want    map[uint][]uint
+		err     bool
+	}{
+		{"manual", TaskAssignment{DocumentIDs: []uint{10, 11}, UserIDs: []uint{ann, other}},
+			map[uint][]uint{10: {ann, other}, 11: {ann, other}}, false},
+		{"round robin", TaskAssignment{DocumentIDs: []uint{10, 11, 12}, UserIDs: []uint{ann, other, rev}, Strategy: AssignRoundRobin, Annotators: 1},
+			map[uint][]uint{10: {ann}, 11: {other}, 12: {rev}}, false},
+		{"round robin pairs", TaskAssignment{DocumentIDs: []uint{10, 11}, UserIDs: []uint{ann, other, rev}, Strategy: AssignRoundRobin, Annotators: 2},
+			map[uint][]uint{10: {ann, other}, 11: {rev, ann}}, false},
+		{"round robin more annotators than users", TaskAssignment{DocumentIDs: []uint{10}, UserIDs: []uint{ann}, Strategy: AssignRoundRobin, Annotators: 2},
+			map[uint][]uint{10: {ann}}, false},
+		{"balanced", TaskAssignment{DocumentIDs: []uint{10, 11, 12}, UserIDs: []uint{ann, other, rev}, Strategy: AssignBalanced, Annotators: 1},
+			map[uint][]uint{10: {other}, 11: {rev}, 12: {ann}}, false},
+		{"balanced pairs", TaskAssignment{DocumentIDs: []uint{10, 11}, UserIDs: []uint{ann, other}, Strategy: AssignBalanced, Annotators: 2},
+			map[uint][]uint{10: {other, ann}, 11: {other, ann}}, false},
+		{"invalid strategy", TaskAssignment{DocumentIDs: []uint{10}, UserIDs: []uint{ann}, Strategy: "random"}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assignments, err := assignDocuments(db, DefaultProjectID, test.request)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, want an error %v", err, test.err)
+			}
+			if test.err {
+				return
+			}
+
+			if !reflect.DeepEqual(assignments, test.want) {
+				t.Errorf("got %v, want %v", assignments, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// API token scopes
+const (
+	ScopeRead     = "read"
+	ScopeAnnotate = "annotate"
+	ScopeAdmin    = "admin"
+)
+
+// scopeRoles is the highest role a token of each scope grants, whatever the role of its user.
+// Read-only tokens are further limited to GET requests by authMiddleware.
+var scopeRoles = map[string]string{
+	ScopeRead:     RoleReader,
+	ScopeAnnotate: RoleAnnotator,
+	ScopeAdmin:    RoleAdmin,
+}
+
+var scopeRanks = map[string]int{
+	ScopeRead:     1,
+	ScopeAnnotate: 2,
+	ScopeAdmin:    3,
+}
+
+const apiTokenPrefix = "spt_"
+
+// bearerToken returns the API token of the Authorization header, if any
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// tokenUser returns the user of an API token, with the scope of the token, and records when the token was last used
+func tokenUser(q querier, token string) (User, error) {
+	var user User
+	var apiTokenID uint
+
+	err := q.QueryRow(`SELECT t.api_token_id, t.scope, u.user_id, u.username FROM api_tokens t
+								INNER JOIN users u ON u.user_id = t.user_id
+								WHERE t.token_hash = ?`, hashToken(token)).
+		Scan(&apiTokenID, &user.Scope, &user.UserID, &user.Username)
+	if err != nil {
+		return user, err
+	}
+
+	_, err = q.Exec("UPDATE api_tokens SET last_used_at = ? WHERE api_token_id = ?", time.Now().UTC(), apiTokenID)
+	if err != nil {
+		return user, fmt.Errorf("Unable to update token: %w", err)
+	}
+
+	return user, nil
+}
+
+func GetAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT api_token_id, name, scope, created_at, last_used_at FROM api_tokens WHERE user_id = ? ORDER BY api_token_id",
+		currentUser(r).UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	tokens := APITokens{}
+
+	for rows.Next() {
+		var token APIToken
+
+		err = rows.Scan(&token.APITokenID, &token.Name, &token.Scope, &token.CreatedAt, &token.LastUsedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(tokens)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// PostAPITokensHandler creates an API token for the logged in user, the token is only returned by this call
+func PostAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	var token APIToken
+	err := json.NewDecoder(r.Body).Decode(&token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if token.Name == "" {
+		http.Error(w, "Token name is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := scopeRanks[token.Scope]; !ok {
+		http.Error(w, fmt.Sprintf("Invalid scope %q, expected read, annotate or admin", token.Scope), http.StatusBadRequest)
+		return
+	}
+
+	// A token cannot be used to get a token with a wider scope
+	user := currentUser(r)
+	if user.Scope != "" && scopeRanks[token.Scope] > scopeRanks[user.Scope] {
+		forbidden(w, fmt.Sprintf("a token with the %s scope cannot create a token with the %s scope", user.Scope, token.Scope))
+		return
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token.Token = apiTokenPrefix + secret
+	token.CreatedAt = time.Now().UTC()
+
+	res, err := db.Exec("INSERT INTO api_tokens (user_id, name, scope, token_hash, created_at) VALUES (?, ?, ?, ?, ?)",
+		user.UserID, token.Name, token.Scope, hashToken(token.Token), token.CreatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, _ := res.LastInsertId()
+	token.APITokenID = uint(id)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DeleteAPITokenHandler revokes one of the API tokens of the logged in user
+func DeleteAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	apiTokenID, _ := strconv.Atoi(params["apiTokenId"])
+
+	res, err := db.Exec("DELETE FROM api_tokens WHERE api_token_id = ? AND user_id = ?", apiTokenID, currentUser(r).UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,313 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// validateTopic checks the display fields of a topic before it is stored
+func validateTopic(topic Topic) error {
+	if topic.Topic == "" {
+		return fmt.Errorf("Topic name is required")
+	}
+
+	if topic.Color != "" && !colorPattern.MatchString(topic.Color) {
+		return fmt.Errorf("Invalid color %q, expected #rrggbb", topic.Color)
+	}
+
+	if len([]rune(topic.Shortcut)) > 1 {
+		return fmt.Errorf("Invalid shortcut %q, expected a single key", topic.Shortcut)
+	}
+
+	if !validValueType(topic.ValueType) {
+		return fmt.Errorf("Invalid value type %q", topic.ValueType)
+	}
+
+	if topic.ValueType == ValueEnum && len(topic.EnumValues) == 0 {
+		return fmt.Errorf("Enum topics need at least one value")
+	}
+
+	return nil
+}
+
+// marshalEnumValues encodes enum values for the topics.enum_values column, nil being an empty list
+func marshalEnumValues(values []string) (string, error) {
+	if values == nil {
+		values = []string{}
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("Unable to marshal enum values: %w", err)
+	}
+
+	return string(b), nil
+}
+
+// nullString stores empty strings as NULL, for optional columns with a UNIQUE constraint
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+const topicColumns = "topic_id, project_id, topic, parent_topic_id, color, description, COALESCE(shortcut, ''), value_type, enum_values"
+
+func scanTopic(row interface{ Scan(...interface{}) error }) (Topic, error) {
+	var topic Topic
+	var enumValues string
+
+	err := row.Scan(&topic.TopicID, &topic.ProjectID, &topic.Topic, &topic.ParentID, &topic.Color, &topic.Description, &topic.Shortcut,
+		&topic.ValueType, &enumValues)
+	if err != nil {
+		return topic, err
+	}
+
+	err = json.Unmarshal([]byte(enumValues), &topic.EnumValues)
+	if err != nil {
+		return topic, fmt.Errorf("Unable to unmarshal enum values of topic %d: %w", topic.TopicID, err)
+	}
+
+	return topic, nil
+}
+
+// queryTopics lists the topics of a project, or of all projects when projectID is 0
+func queryTopics(q querier, projectID uint) (Topics, error) {
+	rows, err := q.Query(`SELECT `+topicColumns+`
+									FROM topics
+									WHERE ? = 0 OR project_id = ?
+									ORDER BY topic`, projectID, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query topics: %w", err)
+	}
+	defer rows.Close()
+
+	topics := Topics{}
+
+	for rows.Next() {
+		topic, err := scanTopic(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read topic: %w", err)
+		}
+
+		topics = append(topics, topic)
+	}
+
+	return topics, rows.Err()
+}
+
+func getTopic(q querier, topicID uint) (Topic, error) {
+	return scanTopic(q.QueryRow("SELECT "+topicColumns+" FROM topics WHERE topic_id = ?", topicID))
+}
+
+// insertTopic stores a validated topic and records it in the audit log
+func insertTopic(q querier, topic Topic, userID uint) (Topic, error) {
+	enumValues, err := marshalEnumValues(topic.EnumValues)
+	if err != nil {
+		return Topic{}, err
+	}
+
+	res, err := q.Exec("INSERT INTO topics (project_id, topic, parent_topic_id, color, description, shortcut, value_type, enum_values) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		topic.ProjectID, topic.Topic, topic.ParentID, topic.Color, topic.Description, nullString(topic.Shortcut), topic.ValueType, enumValues)
+	if err != nil {
+		return Topic{}, fmt.Errorf("Unable to insert topic: %w", err)
+	}
+
+	id, _ := res.LastInsertId()
+	created, err := getTopic(q, uint(id))
+	if err != nil {
+		return Topic{}, err
+	}
+
+	return created, recordTopicChange(q, userID, nil, &created)
+}
+
+// topicTree nests the topics under their parent, keeping the order of the given list
+func topicTree(topics Topics) Topics {
+	children := map[uint]Topics{}
+	exists := map[uint]bool{}
+
+	for _, topic := range topics {
+		exists[topic.TopicID] = true
+	}
+
+	roots := Topics{}
+	for _, topic := range topics {
+		if topic.ParentID != nil && exists[*topic.ParentID] {
+			children[*topic.ParentID] = append(children[*topic.ParentID], topic)
+		} else {
+			roots = append(roots, topic)
+		}
+	}
+
+	var attach func(topics Topics) Topics
+	attach = func(topics Topics) Topics {
+		for i := range topics {
+			topics[i].Children = attach(children[topics[i].TopicID])
+		}
+		return topics
+	}
+
+	return attach(roots)
+}
+
+// checkTopicsProject makes sure a topic belongs to a project, before nesting or merging topics
+func checkTopicsProject(q querier, projectID, topicID uint) error {
+	topicProjectID, err := topicProject(q, topicID)
+	if err != nil {
+		return err
+	}
+
+	if topicProjectID != projectID {
+		return fmt.Errorf("Topic %d does not belong to project %d", topicID, projectID)
+	}
+
+	return nil
+}
+
+// checkTopicParent makes sure setting parentID as the parent of topicID does not create a cycle
+func checkTopicParent(q querier, topicID, parentID uint) error {
+	for id := parentID; ; {
+		if id == topicID {
+			return fmt.Errorf("Topic %d cannot be nested under one of its descendants", topicID)
+		}
+
+		var parent sql.NullInt64
+		err := q.QueryRow("SELECT parent_topic_id FROM topics WHERE topic_id = ?", id).Scan(&parent)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("Topic %d does not exist", id)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read topic: %w", err)
+		}
+
+		if !parent.Valid {
+			return nil
+		}
+		id = uint(parent.Int64)
+	}
+}
+
+// topicDocuments lists the documents having annotations of a topic
+func topicDocuments(q querier, topicID uint) ([]uint, error) {
+	rows, err := q.Query("SELECT DISTINCT document_id FROM annotations WHERE topic_id = ?", topicID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query annotations: %w", err)
+	}
+	defer rows.Close()
+
+	documentIDs := []uint{}
+
+	for rows.Next() {
+		var documentID uint
+
+		err = rows.Scan(&documentID)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read annotation: %w", err)
+		}
+
+		documentIDs = append(documentIDs, documentID)
+	}
+
+	return documentIDs, rows.Err()
+}
+
+// mergeTopic moves the annotations and the children of a topic to another one, then deletes it
+func mergeTopic(tx *sql.Tx, topicID, intoTopicID, userID uint) error {
+	if topicID == intoTopicID {
+		return fmt.Errorf("A topic cannot be merged into itself")
+	}
+
+	projectID, err := topicProject(tx, topicID)
+	if err != nil {
+		return err
+	}
+
+	err = checkTopicsProject(tx, projectID, intoTopicID)
+	if err != nil {
+		return fmt.Errorf("Unable to merge: %w", err)
+	}
+
+	// The children are moved under the target, which must not be one of them
+	err = checkTopicParent(tx, topicID, intoTopicID)
+	if err != nil {
+		return fmt.Errorf("Unable to merge: %w", err)
+	}
+
+	removal, err := captureTopicRemoval(tx, topicID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE annotations SET topic_id = ? WHERE topic_id = ?", intoTopicID, topicID)
+	if err != nil {
+		return fmt.Errorf("Unable to move annotations: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE topics SET parent_topic_id = ? WHERE parent_topic_id = ?", intoTopicID, topicID)
+	if err != nil {
+		return fmt.Errorf("Unable to move child topics: %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM topics WHERE topic_id = ?", topicID)
+	if err != nil {
+		return fmt.Errorf("Unable to delete topic: %w", err)
+	}
+
+	// The moved annotations follow the value type of their new topic, their entries record their new value
+	updated, err := normalizeTopicAnnotations(tx, intoTopicID)
+	if err != nil {
+		return err
+	}
+
+	causeID, err := removal.record(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	// The other annotations of the target keep their value unless it was out of date, which is recorded too
+	moved := map[uint]bool{}
+	for _, annotations := range removal.Annotations {
+		for _, annotation := range annotations {
+			moved[annotation.AnnotationID] = true
+		}
+	}
+	for documentID, annotations := range updated {
+		kept := []Annotation{}
+		for _, annotation := range annotations {
+			if !moved[annotation.AnnotationID] {
+				kept = append(kept, annotation)
+			}
+		}
+		updated[documentID] = kept
+	}
+
+	return recordAnnotationUpdates(tx, userID, updated, &causeID)
+}
+
+// deleteTopic deletes a topic along with its annotations, its children moving to the top level
+func deleteTopic(tx *sql.Tx, topicID, userID uint) error {
+	removal, err := captureTopicRemoval(tx, topicID)
+	if err != nil {
+		return err
+	}
+
+	// Annotations are deleted by the cascade, and their relations with them
+	_, err = tx.Exec("DELETE FROM topics WHERE topic_id = ?", topicID)
+	if err != nil {
+		return fmt.Errorf("Unable to delete topic: %w", err)
+	}
+
+	_, err = removal.record(tx, userID)
+	return err
+}
+
+func broadcastTopicChange(projectID uint, documentIDs []uint) {
+	Broadcast(projectID, `{"type":"topicsChanged"}`)
+
+	for _, documentID := range documentIDs {
+		Broadcast(projectID, fmt.Sprintf(`{"type":"annotationsChanged", "documentId":%d}`, documentID))
+	}
+}
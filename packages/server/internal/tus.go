@@ -3,12 +3,52 @@ package internal
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/tus/tusd/pkg/filestore"
 	tusd "github.com/tus/tusd/pkg/handler"
 )
 
+// uploadProject is the project picked with the "project" metadata of an upload, or the default project
+func uploadProject(upload tusd.FileInfo) uint {
+	projectID, err := strconv.Atoi(upload.MetaData["project"])
+	if err != nil {
+		return DefaultProjectID
+	}
+
+	return uint(projectID)
+}
+
+// checkUploadProject refuses uploads to a project which does not exist, or where the user cannot annotate,
+// before any byte is received
+func checkUploadProject(hook tusd.HookEvent) error {
+	projectID := uploadProject(hook.Upload)
+
+	_, err := getProject(db, projectID)
+	if err != nil {
+		return tusd.NewHTTPError(fmt.Errorf("Unknown project %q", hook.Upload.MetaData["project"]), http.StatusBadRequest)
+	}
+
+	// The hook only gets the headers of the request, enough to find the user again
+	user, err := authenticate(&http.Request{Header: http.Header(hook.HTTPRequest.Header)})
+	if err != nil {
+		return tusd.NewHTTPError(errUnauthenticated, http.StatusUnauthorized)
+	}
+
+	role, err := userRole(db, user, projectID)
+	if err != nil {
+		return err
+	}
+
+	if !hasRole(role, RoleAnnotator) {
+		return tusd.NewHTTPError(fmt.Errorf("Forbidden: the %s role is required in project %d", RoleAnnotator, projectID), http.StatusForbidden)
+	}
+
+	return nil
+}
+
 func NewUploadHandler(uploadDir, urlPrefix string) (*tusd.Handler, error) {
 	store := filestore.FileStore{
 		Path: uploadDir,
@@ -18,9 +58,10 @@ func NewUploadHandler(uploadDir, urlPrefix string) (*tusd.Handler, error) {
 	store.UseIn(composer)
 
 	uploadHandler, err := tusd.NewHandler(tusd.Config{
-		BasePath:              urlPrefix,
-		StoreComposer:         composer,
-		NotifyCompleteUploads: true,
+		BasePath:                urlPrefix,
+		StoreComposer:           composer,
+		NotifyCompleteUploads:   true,
+		PreUploadCreateCallback: checkUploadProject,
 	})
 
 	if err != nil {
@@ -36,7 +77,10 @@ func NewUploadHandler(uploadDir, urlPrefix string) (*tusd.Handler, error) {
 
 			log.Println("Start processing")
 
-			err := ProcessDocument(uploadDir, event.Upload.ID, event.Upload.MetaData["filename"])
+			// The last request of the upload tells who uploaded the document, for the audit log
+			uploader, _ := authenticate(&http.Request{Header: http.Header(event.HTTPRequest.Header)})
+
+			err := ProcessDocument(uploadDir, event.Upload.ID, event.Upload.MetaData["filename"], uploadProject(event.Upload), uploader.UserID)
 
 			if err != nil {
 				log.Fatalf("Process document error: %v", err)
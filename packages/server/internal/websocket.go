@@ -3,19 +3,31 @@ package internal
 import (
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
 
 	"github.com/gorilla/websocket"
 )
 
-var clients = make(map[*websocket.Conn]bool)
+// Connected clients with the project they listen to, 0 for all projects
+var clients = make(map[*websocket.Conn]uint)
+var clientsMutex sync.Mutex
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
-func Broadcast(jsonString string) {
-	for client := range clients {
+// Broadcast sends a message to the clients listening to a project, or to every client when projectID is 0
+func Broadcast(projectID uint, jsonString string) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	for client, clientProjectID := range clients {
+		if projectID != 0 && clientProjectID != 0 && clientProjectID != projectID {
+			continue
+		}
+
 		err := client.WriteMessage(websocket.TextMessage, []byte(jsonString))
 
 		if err != nil {
@@ -26,7 +38,21 @@ func Broadcast(jsonString string) {
 	}
 }
 
+// BroadcastDocument sends a message to the clients listening to the project of a document
+func BroadcastDocument(documentID uint, jsonString string) {
+	projectID, err := documentProject(db, documentID)
+	if err != nil {
+		log.Printf("Unable to broadcast: %v", err)
+		return
+	}
+
+	Broadcast(projectID, jsonString)
+}
+
+// WebSocketHandler registers a client, listening to the events of a single project with ?project={projectId}
 func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	projectID, _ := strconv.Atoi(r.URL.Query().Get("project"))
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 
 	if err != nil {
@@ -34,5 +60,7 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clients[conn] = true
+	clientsMutex.Lock()
+	clients[conn] = uint(projectID)
+	clientsMutex.Unlock()
 }
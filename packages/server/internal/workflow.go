@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Statuses of the labeling lifecycle of a document
+const (
+	DocumentNew        = "new"
+	DocumentInProgress = "inProgress"
+	DocumentAnnotated  = "annotated"
+	DocumentInReview   = "inReview"
+	DocumentApproved   = "approved"
+	DocumentRejected   = "rejected"
+)
+
+// documentTransitions lists the statuses a document can move to from each status
+var documentTransitions = map[string][]string{
+	DocumentNew:        {DocumentInProgress},
+	DocumentInProgress: {DocumentNew, DocumentAnnotated},
+	DocumentAnnotated:  {DocumentInProgress, DocumentInReview},
+	DocumentInReview:   {DocumentAnnotated, DocumentApproved, DocumentRejected},
+	DocumentApproved:   {DocumentInReview},
+	DocumentRejected:   {DocumentInProgress, DocumentInReview},
+}
+
+// reviewStatuses are the statuses only reviewers can move a document to. A rejected document goes back
+// to its annotators, but only reviewers can move a document out of review or reopen an approved one.
+var reviewStatuses = map[string]bool{
+	DocumentInReview: true,
+	DocumentApproved: true,
+	DocumentRejected: true,
+}
+
+func validDocumentStatus(status string) bool {
+	_, ok := documentTransitions[status]
+	return ok
+}
+
+func allowedTransition(from, to string) bool {
+	for _, status := range documentTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transitionDocument moves a document to a status and records who moved it, userID 0 for the server itself
+func transitionDocument(q querier, documentID uint, to string, userID uint, comment string) (DocumentTransition, error) {
+	transition := DocumentTransition{
+		DocumentID: documentID,
+		ToStatus:   to,
+		Comment:    comment,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	err := q.QueryRow("SELECT status FROM documents WHERE document_id = ?", documentID).Scan(&transition.FromStatus)
+	if err != nil {
+		return transition, fmt.Errorf("Unable to read status of document %d: %w", documentID, err)
+	}
+
+	if !allowedTransition(transition.FromStatus, to) {
+		return transition, fmt.Errorf("A document cannot go from %s to %s", transition.FromStatus, to)
+	}
+
+	var user sql.NullInt64
+	if userID != 0 {
+		user = sql.NullInt64{Int64: int64(userID), Valid: true}
+		transition.UserID = &userID
+	}
+
+	before, err := getDocumentSummary(q, documentID)
+	if err != nil {
+		return transition, fmt.Errorf("Unable to read document %d: %w", documentID, err)
+	}
+
+	_, err = q.Exec("UPDATE documents SET status = ? WHERE document_id = ?", to, documentID)
+	if err != nil {
+		return transition, fmt.Errorf("Unable to update status of document %d: %w", documentID, err)
+	}
+
+	after := before
+	after.Status = to
+	err = recordDocumentChange(q, userID, &before, &after)
+	if err != nil {
+		return transition, err
+	}
+
+	res, err := q.Exec("INSERT INTO document_transitions (document_id, from_status, to_status, user_id, comment, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		documentID, transition.FromStatus, to, user, comment, transition.CreatedAt)
+	if err != nil {
+		return transition, fmt.Errorf("Unable to record transition of document %d: %w", documentID, err)
+	}
+
+	id, _ := res.LastInsertId()
+	transition.DocumentTransitionID = uint(id)
+
+	return transition, nil
+}
+
+// advanceDocument moves a document to a status when it is in the given one, leaving it unchanged otherwise
+func advanceDocument(q querier, documentID uint, from, to string, userID uint) (bool, error) {
+	var status string
+
+	err := q.QueryRow("SELECT status FROM documents WHERE document_id = ?", documentID).Scan(&status)
+	if err != nil {
+		return false, fmt.Errorf("Unable to read status of document %d: %w", documentID, err)
+	}
+
+	if status != from {
+		return false, nil
+	}
+
+	_, err = transitionDocument(q, documentID, to, userID, "")
+	return err == nil, err
+}
+
+func queryDocumentTransitions(q querier, documentID uint) (DocumentTransitions, error) {
+	rows, err := q.Query(`SELECT document_transition_id, document_id, from_status, to_status, user_id, comment, created_at
+								FROM document_transitions WHERE document_id = ? ORDER BY document_transition_id`, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query transitions: %w", err)
+	}
+	defer rows.Close()
+
+	transitions := DocumentTransitions{}
+
+	for rows.Next() {
+		var transition DocumentTransition
+
+		err = rows.Scan(&transition.DocumentTransitionID, &transition.DocumentID, &transition.FromStatus, &transition.ToStatus,
+			&transition.UserID, &transition.Comment, &transition.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read transition: %w", err)
+		}
+
+		transitions = append(transitions, transition)
+	}
+
+	return transitions, rows.Err()
+}
+
+// GetDocumentTransitionsHandler returns the history of the statuses of a document
+func GetDocumentTransitionsHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	transitions, err := queryDocumentTransitions(db, uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(transitions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// PostDocumentStatusHandler moves a document through its lifecycle. Annotators move the documents they have a task for
+// until they are annotated, reviewers move any document and put it in review, approve or reject it.
+func PostDocumentStatusHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	documentID, _ := strconv.Atoi(params["documentId"])
+
+	var change StatusChange
+	err := json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !validDocumentStatus(change.Status) {
+		http.Error(w, fmt.Sprintf("Invalid status %q, expected new, inProgress, annotated, inReview, approved or rejected", change.Status), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	projectID, err := documentProject(tx, uint(documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var current string
+	err = tx.QueryRow("SELECT status FROM documents WHERE document_id = ?", documentID).Scan(&current)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	role, err := userRole(tx, currentUser(r), projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !hasRole(role, RoleReviewer) {
+		if reviewStatuses[change.Status] || current == DocumentInReview || current == DocumentApproved {
+			forbidden(w, "the reviewer role is required to review documents")
+			return
+		}
+
+		assigned, err := hasDocumentTask(tx, uint(documentID), currentUser(r).UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !assigned {
+			forbidden(w, "annotators can only move the documents of their tasks, the reviewer role is required")
+			return
+		}
+	}
+
+	transition, err := transitionDocument(tx, uint(documentID), change.Status, currentUser(r).UserID, change.Comment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(transition)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	Broadcast(projectID, `{"type":"documentsChanged"}`)
+}
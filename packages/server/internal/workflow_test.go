@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// workflowFixture creates an annotator with a task on a new document, another annotator and a reviewer
+func workflowFixture(t *testing.T) (documentID, taskID, ann, other, rev uint) {
+	t.Helper()
+
+	ann = mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('ann', 'hash', '2020-01-01')")
+	other = mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('other', 'hash', '2020-01-01')")
+	rev = mustExec(t, "INSERT INTO users (username, password_hash, created_at) VALUES ('rev', 'hash', '2020-01-01')")
+	mustExec(t, "INSERT INTO role_assignments (user_id, project_id, role) VALUES (?, 1, 'annotator'), (?, 1, 'annotator'), (?, 1, 'reviewer')", ann, other, rev)
+
+	documentID = insertTestDocument(t, DefaultProjectID, "contract.pdf", []string{"Acme"})
+	taskID = mustExec(t, "INSERT INTO tasks (document_id, user_id, status, assigned_by, created_at) VALUES (?, ?, 'assigned', ?, '2020-01-01')", documentID, ann, rev)
+
+	return documentID, taskID, ann, other, rev
+}
+
+func documentStatus(t *testing.T, documentID uint) string {
+	t.Helper()
+
+	var status string
+	if err := db.QueryRow("SELECT status FROM documents WHERE document_id = ?", documentID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestPostDocumentStatusRequiresTask(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   func(ann, other, rev uint) uint
+		status int
+	}{
+		{"assignee", func(ann, other, rev uint) uint { return ann }, http.StatusOK},
+		{"other annotator", func(ann, other, rev uint) uint { return other }, http.StatusForbidden},
+		{"reviewer", func(ann, other, rev uint) uint { return rev }, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer openTestDatabase(t)()
+			documentID, _, ann, other, rev := workflowFixture(t)
+
+			w := serveAs(PostDocumentStatusHandler, test.user(ann, other, rev), http.MethodPost, `{"status": "inProgress"}`,
+				map[string]string{"documentId": fmt.Sprint(documentID)})
+			if w.Code != test.status {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body.String(), test.status)
+			}
+			if test.status == http.StatusOK && documentStatus(t, documentID) != DocumentInProgress {
+				t.Errorf("document is %s, want it in progress", documentStatus(t, documentID))
+			}
+		})
+	}
+}
+
+func TestTaskDoneWithoutClaim(t *testing.T) {
+	defer openTestDatabase(t)()
+	documentID, taskID, ann, _, _ := workflowFixture(t)
+
+	w := serveAs(UpdateTaskHandler(TaskDone), ann, http.MethodPost, "", map[string]string{"taskId": fmt.Sprint(taskID)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", w.Code, w.Body.String())
+	}
+
+	if status := documentStatus(t, documentID); status != DocumentAnnotated {
+		t.Errorf("document is %s, want it annotated", status)
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/spectator/server/internal"
@@ -12,10 +17,38 @@ const databasePath = "./spectator.db"
 const uploadDir = "./uploads"
 const webBuildDir = "./web/build"
 
+// stringsFlag collects the values of a flag given several times
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func main() {
-	
+	predictorURL := flag.String("predictor-url", "", "HTTP endpoint used to pre-annotate processed documents")
+	predictorCommand := flag.String("predictor-command", "", "program used to pre-annotate processed documents, speaking JSON on stdin/stdout")
+	var predictorArgs stringsFlag
+	flag.Var(&predictorArgs, "predictor-arg", "argument of the predictor program, repeated for each argument")
+	flag.Parse()
+
 	internal.InitDatabase(databasePath)
 
+	if flag.NArg() > 0 {
+		runCommand(flag.Args())
+		return
+	}
+
+	err := internal.InitPredictor(*predictorURL, *predictorCommand, predictorArgs)
+
+	if err != nil {
+		panic(err)
+	}
+
 	r, err := internal.NewRouter(uploadDir, webBuildDir)
 
 	if err != nil {
@@ -23,12 +56,53 @@ func main() {
 	}
 
 	log.Printf("Server will start on port 8000")
+	// Only the headers have a deadline: backups, restores and the zip exports carry every page image and original file,
+	// and would be cut off mid-transfer by a deadline on the whole request or response
 	srv := &http.Server{
-		Handler:      r,
-		Addr:         "127.0.0.1:8000",
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		Handler:           r,
+		Addr:              "127.0.0.1:8000",
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       2 * time.Minute,
 	}
 
 	log.Fatal(srv.ListenAndServe())
 }
+
+// runCommand runs the backup and restore commands against the database, the server can keep running meanwhile:
+//
+//	server backup [-project ID] archive.zip
+//	server restore [-project ID] archive.zip
+func runCommand(args []string) {
+	if args[0] != "backup" && args[0] != "restore" {
+		log.Fatalf("Unknown command %q, expected backup or restore", args[0])
+	}
+
+	commands := flag.NewFlagSet(args[0], flag.ExitOnError)
+	projectID := commands.Uint("project", 0, "project to back up or to restore into, all projects or those of the archive when 0")
+	commands.Usage = func() {
+		fmt.Fprintf(commands.Output(), "Usage: %s %s [-project ID] archive.zip\n", os.Args[0], args[0])
+		commands.PrintDefaults()
+	}
+	commands.Parse(args[1:])
+
+	if commands.NArg() != 1 {
+		commands.Usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "backup":
+		err := internal.Backup(commands.Arg(0), *projectID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Backup written to %s", commands.Arg(0))
+
+	case "restore":
+		report, err := internal.Restore(commands.Arg(0), *projectID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		json.NewEncoder(os.Stdout).Encode(report)
+	}
+}
@@ -21,7 +21,65 @@ CREATE TABLE annotations (
     top_px          INTEGER NOT NULL,
     left_px         INTEGER NOT NULL,
     topic_id                REFERENCES topics (topic_id) ON DELETE CASCADE
-                            NOT NULL
+                            NOT NULL,
+    status          TEXT    NOT NULL
+                            DEFAULT 'accepted'
+                            CHECK (status IN ('suggested', 'accepted', 'rejected')),
+    source          TEXT    NOT NULL
+                            DEFAULT 'human'
+                            CHECK (source IN ('human', 'rule', 'model')),
+    confidence      REAL,
+    model           TEXT    NOT NULL
+                            DEFAULT '',
+    notes           TEXT    NOT NULL
+                            DEFAULT '',
+    attributes      TEXT    NOT NULL
+                            DEFAULT '{}',
+    value           TEXT,
+    value_error     TEXT    NOT NULL
+                            DEFAULT '',
+    user_id                 REFERENCES users (user_id) ON DELETE SET NULL,
+    gold            BOOLEAN NOT NULL
+                            DEFAULT FALSE,
+    source_annotation_id INTEGER
+);
+
+
+-- Table: api_tokens
+DROP TABLE IF EXISTS api_tokens;
+
+CREATE TABLE api_tokens (
+    api_token_id INTEGER  PRIMARY KEY AUTOINCREMENT,
+    user_id               REFERENCES users (user_id) ON DELETE CASCADE
+                          NOT NULL,
+    name         TEXT     NOT NULL,
+    scope        TEXT     NOT NULL
+                          CHECK (scope IN ('read', 'annotate', 'admin')),
+    token_hash   TEXT     NOT NULL
+                          UNIQUE,
+    created_at   DATETIME NOT NULL,
+    last_used_at DATETIME
+);
+
+
+-- Table: audit_log
+DROP TABLE IF EXISTS audit_log;
+
+CREATE TABLE audit_log (
+    audit_id    INTEGER  PRIMARY KEY AUTOINCREMENT,
+    project_id  INTEGER  NOT NULL,
+    document_id INTEGER,
+    entity      TEXT     NOT NULL
+                         CHECK (entity IN ('annotation', 'topic', 'document', 'relation')),
+    entity_id   INTEGER  NOT NULL,
+    action      TEXT     NOT NULL
+                         CHECK (action IN ('create', 'update', 'delete')),
+    user_id     INTEGER,
+    before      TEXT,
+    after       TEXT,
+    reverts_id  INTEGER,
+    cause_id    INTEGER,
+    created_at  DATETIME NOT NULL
 );
 
 
@@ -41,15 +99,129 @@ CREATE TABLE document_pages (
 );
 
 
+-- Table: document_transitions
+DROP TABLE IF EXISTS document_transitions;
+
+CREATE TABLE document_transitions (
+    document_transition_id INTEGER  PRIMARY KEY AUTOINCREMENT,
+    document_id                     REFERENCES documents (document_id) ON DELETE CASCADE
+                                    NOT NULL,
+    from_status            TEXT     NOT NULL,
+    to_status              TEXT     NOT NULL,
+    user_id                         REFERENCES users (user_id) ON DELETE SET NULL,
+    comment                TEXT     NOT NULL
+                                    DEFAULT '',
+    created_at             DATETIME NOT NULL
+);
+
+
 -- Table: documents
 DROP TABLE IF EXISTS documents;
 
 CREATE TABLE documents (
     document_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id          REFERENCES projects (project_id) ON DELETE CASCADE
+                        NOT NULL
+                        DEFAULT 1,
     name        TEXT    NOT NULL,
     pages       INTEGER,
     text        TEXT,
-    processed   BOOLEAN NOT NULL DEFAULT FALSE
+    processed   BOOLEAN NOT NULL DEFAULT FALSE,
+    status      TEXT    NOT NULL
+                        DEFAULT 'new'
+                        CHECK (status IN ('new', 'inProgress', 'annotated', 'inReview', 'approved', 'rejected')),
+    original    BLOB
+);
+
+
+-- Table: projects
+DROP TABLE IF EXISTS projects;
+
+CREATE TABLE projects (
+    project_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name       TEXT    NOT NULL
+                       UNIQUE,
+    settings   TEXT    NOT NULL
+                       DEFAULT '{}'
+);
+
+INSERT INTO projects (project_id, name) VALUES (1, 'Default');
+
+
+-- Table: relation_types
+DROP TABLE IF EXISTS relation_types;
+
+CREATE TABLE relation_types (
+    relation_type_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id               REFERENCES projects (project_id) ON DELETE CASCADE
+                             NOT NULL
+                             DEFAULT 1,
+    relation_type    TEXT    NOT NULL,
+    UNIQUE (project_id, relation_type)
+);
+
+
+-- Table: relations
+DROP TABLE IF EXISTS relations;
+
+CREATE TABLE relations (
+    relation_id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    document_id                REFERENCES documents (document_id) ON DELETE CASCADE
+                               NOT NULL,
+    relation_type_id           REFERENCES relation_types (relation_type_id) ON DELETE CASCADE
+                               NOT NULL,
+    from_annotation_id         REFERENCES annotations (annotation_id) ON DELETE CASCADE
+                               NOT NULL,
+    to_annotation_id           REFERENCES annotations (annotation_id) ON DELETE CASCADE
+                               NOT NULL,
+    user_id                    REFERENCES users (user_id) ON DELETE SET NULL
+);
+
+
+-- Table: role_assignments
+DROP TABLE IF EXISTS role_assignments;
+
+CREATE TABLE role_assignments (
+    role_assignment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id                    REFERENCES users (user_id) ON DELETE CASCADE
+                               NOT NULL,
+    project_id                 REFERENCES projects (project_id) ON DELETE CASCADE,
+    role               TEXT    NOT NULL
+                               CHECK (role IN ('reader', 'annotator', 'reviewer', 'admin'))
+);
+
+
+-- Table: sessions
+DROP TABLE IF EXISTS sessions;
+
+CREATE TABLE sessions (
+    session_id INTEGER  PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT     NOT NULL
+                        UNIQUE,
+    user_id             REFERENCES users (user_id) ON DELETE CASCADE
+                        NOT NULL,
+    expires_at DATETIME NOT NULL
+);
+
+
+-- Table: tasks
+DROP TABLE IF EXISTS tasks;
+
+CREATE TABLE tasks (
+    task_id      INTEGER  PRIMARY KEY AUTOINCREMENT,
+    document_id           REFERENCES documents (document_id) ON DELETE CASCADE
+                          NOT NULL,
+    user_id               REFERENCES users (user_id) ON DELETE CASCADE
+                          NOT NULL,
+    status       TEXT     NOT NULL
+                          DEFAULT 'assigned'
+                          CHECK (status IN ('assigned', 'claimed', 'done')),
+    assigned_by           REFERENCES users (user_id) ON DELETE SET NULL,
+    created_at   DATETIME NOT NULL,
+    claimed_at   DATETIME,
+    expires_at   DATETIME,
+    completed_at DATETIME,
+    UNIQUE (document_id, user_id)
 );
 
 
@@ -57,11 +229,58 @@ CREATE TABLE documents (
 DROP TABLE IF EXISTS topics;
 
 CREATE TABLE topics (
-    topic_id INTEGER PRIMARY KEY AUTOINCREMENT,
-    topic    TEXT    NOT NULL
-                     UNIQUE
+    topic_id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id              REFERENCES projects (project_id) ON DELETE CASCADE
+                            NOT NULL
+                            DEFAULT 1,
+    topic           TEXT    NOT NULL,
+    parent_topic_id         REFERENCES topics (topic_id) ON DELETE SET NULL,
+    color           TEXT    NOT NULL
+                            DEFAULT '',
+    description     TEXT    NOT NULL
+                            DEFAULT '',
+    shortcut        TEXT,
+    value_type      TEXT    NOT NULL
+                            DEFAULT ''
+                            CHECK (value_type IN ('', 'date', 'money', 'number', 'percentage', 'enum')),
+    enum_values     TEXT    NOT NULL
+                            DEFAULT '[]',
+    UNIQUE (project_id, topic),
+    UNIQUE (project_id, shortcut)
+);
+
+
+-- Table: users
+DROP TABLE IF EXISTS users;
+
+CREATE TABLE users (
+    user_id       INTEGER  PRIMARY KEY AUTOINCREMENT,
+    username      TEXT     NOT NULL
+                           UNIQUE,
+    password_hash TEXT     NOT NULL,
+    created_at    DATETIME NOT NULL
 );
 
 
+-- Trigger: audit_log_no_delete
+DROP TRIGGER IF EXISTS audit_log_no_delete;
+CREATE TRIGGER audit_log_no_delete
+        BEFORE DELETE
+            ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'The audit log is append-only');
+END;
+
+
+-- Trigger: audit_log_no_update
+DROP TRIGGER IF EXISTS audit_log_no_update;
+CREATE TRIGGER audit_log_no_update
+        BEFORE UPDATE
+            ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'The audit log is append-only');
+END;
+
+
 COMMIT TRANSACTION;
 PRAGMA foreign_keys = on;
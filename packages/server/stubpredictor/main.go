@@ -0,0 +1,98 @@
+// Stub prediction backend used to test pre-annotation without a real model.
+//
+// It labels every case-insensitive occurrence of a topic name in the document text.
+// Run it as a command (`-predictor-command go -predictor-arg run -predictor-arg ./stubpredictor`)
+// or as an HTTP server (`go run ./stubpredictor -listen 127.0.0.1:9000` with
+// `-predictor-url http://127.0.0.1:9000/predict`).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type predictionRequest struct {
+	DocumentID uint     `json:"documentId"`
+	Text       string   `json:"text"`
+	Topics     []string `json:"topics"`
+}
+
+type predictedSpan struct {
+	CharacterStart uint    `json:"characterStart"`
+	CharacterEnd   uint    `json:"characterEnd"`
+	Topic          string  `json:"topic"`
+	Confidence     float64 `json:"confidence"`
+}
+
+type predictionResponse struct {
+	Model string          `json:"model"`
+	Spans []predictedSpan `json:"spans"`
+}
+
+func predict(request predictionRequest) predictionResponse {
+	response := predictionResponse{Model: "stub", Spans: []predictedSpan{}}
+	text := strings.ToLower(request.Text)
+
+	for _, topic := range request.Topics {
+		needle := strings.ToLower(topic)
+		if needle == "" {
+			continue
+		}
+
+		offset := 0
+		for {
+			index := strings.Index(text[offset:], needle)
+			if index == -1 {
+				break
+			}
+
+			start := offset + index
+			response.Spans = append(response.Spans, predictedSpan{
+				CharacterStart: uint(start),
+				CharacterEnd:   uint(start + len(needle)),
+				Topic:          topic,
+				Confidence:     0.5,
+			})
+			offset = start + len(needle)
+		}
+	}
+
+	return response
+}
+
+func predictHandler(w http.ResponseWriter, r *http.Request) {
+	var request predictionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(predict(request))
+}
+
+func main() {
+	listen := flag.String("listen", "", "serve predictions over HTTP on this address instead of using stdin/stdout")
+	flag.Parse()
+
+	if *listen != "" {
+		log.Printf("Stub predictor listening on %s", *listen)
+		log.Fatal(http.ListenAndServe(*listen, http.HandlerFunc(predictHandler)))
+	}
+
+	var request predictionRequest
+	err := json.NewDecoder(os.Stdin).Decode(&request)
+	if err != nil {
+		log.Fatalf("Unable to decode request: %v", err)
+	}
+
+	err = json.NewEncoder(os.Stdout).Encode(predict(request))
+	if err != nil {
+		log.Fatalf("Unable to encode response: %v", err)
+	}
+}
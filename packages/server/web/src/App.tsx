@@ -48,7 +48,7 @@ function App(props: AppProps) {
 
   React.useEffect(() => {
     async function fetchTopics() {
-      let response = await fetch("/topics");
+      let response = await fetch("/topics?flat=true");
       let topics = await response.json();
 
       setTopics(topics);
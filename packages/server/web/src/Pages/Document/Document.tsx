@@ -36,10 +36,26 @@ const Document = (props: DocumentProps) => {
   }, [id]);
 
   React.useEffect(() => {
+    // Once adjudicated the gold layer is shown, otherwise the accepted annotations of every annotator; showing
+    // both would draw each gold annotation over the one it was copied from. Suggestions are shown with them to be
+    // reviewed.
     async function fetchAnnotations() {
-      let response = await fetch("/document/" + id + "/annotations");
+      let response = await fetch("/document/" + id + "/annotations?gold=true");
       let anns = await response.json();
-      setAnnotations(anns);
+
+      if (anns.length === 0) {
+        response = await fetch(
+          "/document/" + id + "/annotations?status=accepted&gold=false"
+        );
+        anns = await response.json();
+      }
+
+      response = await fetch(
+        "/document/" + id + "/annotations?status=suggested&gold=false"
+      );
+      let suggestions = await response.json();
+
+      setAnnotations(anns.concat(suggestions));
     }
 
     function onMessage(message: MessageEvent) {
@@ -139,6 +155,33 @@ const Document = (props: DocumentProps) => {
     [document]
   );
 
+  const handleAnnotationReview = React.useCallback(
+    (annotation: any, review: "accept" | "reject") => {
+      fetch(
+        "/document/" +
+          document?.id +
+          "/annotation/" +
+          annotation.annotationId +
+          "/" +
+          review,
+        {
+          method: "post",
+        }
+      )
+        .then((response: any) => {
+          if (!response.ok) {
+            throw new Error(response.statusText);
+          }
+
+          console.log("Annotation reviewed:", response);
+        })
+        .catch((error: any) => {
+          console.error("Annotation review:", error);
+        });
+    },
+    [document]
+  );
+
   return (
     <Box height="100%" width="100%" margin={"0"}>
       {document && (
@@ -162,6 +205,10 @@ const Document = (props: DocumentProps) => {
           summaryProps={{
             annotations: annotations,
             onAnnotationDelete: handleAnnotationDelete,
+            onAnnotationAccept: (annotation: Annotation) =>
+              handleAnnotationReview(annotation, "accept"),
+            onAnnotationReject: (annotation: Annotation) =>
+              handleAnnotationReview(annotation, "reject"),
           }}
           muiClassGenerator={classGenerator}
         />
@@ -34,12 +34,29 @@ const useStyles = makeStyles((theme) => ({
 
 type AnnotationsProps = {
   annotations: Annotation[];
+  onAnnotationAccept: (annotation: Annotation) => void;
   onAnnotationDelete: (annotation: Annotation) => void;
+  onAnnotationReject: (annotation: Annotation) => void;
   viewerRef: ViewerRef;
 };
 
+// suggestedBy describes where a suggestion comes from, and how confident its model was
+const suggestedBy = (annotation: Annotation) => {
+  let by = annotation.model || annotation.source;
+  if (annotation.confidence !== null) {
+    by += " (" + Math.round(annotation.confidence * 100) + "%)";
+  }
+  return "Suggested by " + by;
+};
+
 const Annotations = (props: AnnotationsProps) => {
-  const { annotations, onAnnotationDelete, viewerRef } = props;
+  const {
+    annotations,
+    onAnnotationAccept,
+    onAnnotationDelete,
+    onAnnotationReject,
+    viewerRef,
+  } = props;
   const classes = useStyles();
 
   const handleContentClick = (index: number) => {
@@ -63,9 +80,32 @@ const Annotations = (props: AnnotationsProps) => {
                   <Typography className={classes.annotation} variant="body1">
                     {annotation.text}
                   </Typography>
+                  {annotation.status === "suggested" && (
+                    <Typography variant="caption" color="textSecondary">
+                      {suggestedBy(annotation)}
+                    </Typography>
+                  )}
                 </CardContent>
               </CardActionArea>
               <CardActions>
+                {annotation.status === "suggested" && (
+                  <>
+                    <Button
+                      size="small"
+                      color="primary"
+                      onClick={() => onAnnotationAccept(annotation)}
+                    >
+                      Accept
+                    </Button>
+                    <Button
+                      size="small"
+                      color="primary"
+                      onClick={() => onAnnotationReject(annotation)}
+                    >
+                      Reject
+                    </Button>
+                  </>
+                )}
                 <Button
                   size="small"
                   color="primary"
@@ -122,7 +122,9 @@ const NavLink = (props: NavLinkProps): JSX.Element => {
 
 type ViewerSummaryProps = {
   annotations: Annotation[];
+  onAnnotationAccept: (annotation: Annotation) => void;
   onAnnotationDelete: (annotation: Annotation) => void;
+  onAnnotationReject: (annotation: Annotation) => void;
 };
 
 const Summary = React.forwardRef(
@@ -130,8 +132,15 @@ const Summary = React.forwardRef(
     props: SummaryProps & ViewerSummaryProps,
     ref: React.Ref<unknown>
   ): JSX.Element => {
-    const { annotations, onAnnotationDelete, onZoomChange, viewerRef, zoom } =
-      props;
+    const {
+      annotations,
+      onAnnotationAccept,
+      onAnnotationDelete,
+      onAnnotationReject,
+      onZoomChange,
+      viewerRef,
+      zoom,
+    } = props;
 
     const classes = useStyles();
     const match = useRouteMatch();
@@ -204,7 +213,9 @@ const Summary = React.forwardRef(
               <Route path={`${match.url}/extractions`}>
                 <Annotations
                   annotations={annotations}
+                  onAnnotationAccept={onAnnotationAccept}
                   onAnnotationDelete={onAnnotationDelete}
+                  onAnnotationReject={onAnnotationReject}
                   viewerRef={viewerRef}
                 />
               </Route>
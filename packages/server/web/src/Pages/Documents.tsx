@@ -137,6 +137,9 @@ const Documents = (props: DocumentsProps) => {
                   <Typography variant="body2">Processing...</Typography>
                 )}
               </Box>
+              <Box marginRight={3}>
+                <Typography variant="body2">{document.status}</Typography>
+              </Box>
               <IconButton
                 disabled={!document.processed}
                 onClick={(event: React.MouseEvent) => {
@@ -0,0 +1,105 @@
+import React from "react";
+
+import { Box, Button, TextField, Typography } from "@material-ui/core";
+
+type LoginProps = {
+  children: (socket: WebSocket) => React.ReactNode;
+};
+
+// Login shows the login form until the user has a session, the websocket is
+// opened afterwards because the server refuses it without a session cookie
+const Login = (props: LoginProps) => {
+  const { children } = props;
+
+  const [loggedIn, setLoggedIn] = React.useState<boolean | undefined>();
+  const [socket, setSocket] = React.useState<WebSocket>();
+  const [username, setUsername] = React.useState<string>("");
+  const [password, setPassword] = React.useState<string>("");
+  const [error, setError] = React.useState<string>("");
+
+  React.useEffect(() => {
+    fetch("/me").then((response: any) => setLoggedIn(response.ok));
+  }, []);
+
+  React.useEffect(() => {
+    if (!loggedIn) return;
+
+    let socket = new WebSocket("ws://127.0.0.1:8000/ws");
+    setSocket(socket);
+
+    return () => {
+      socket.close();
+    };
+  }, [loggedIn]);
+
+  const handleLogin = React.useCallback(
+    (event: React.FormEvent) => {
+      event.preventDefault();
+
+      fetch("/login", {
+        method: "post",
+        body: JSON.stringify({ username: username, password: password }),
+      })
+        .then((response: any) => {
+          if (!response.ok) {
+            throw new Error("Invalid username or password");
+          }
+
+          setError("");
+          setLoggedIn(true);
+        })
+        .catch((error: any) => {
+          setError(error.message);
+        });
+    },
+    [username, password]
+  );
+
+  if (loggedIn === undefined) return null;
+
+  if (loggedIn && socket) return <>{children(socket)}</>;
+
+  if (loggedIn) return null;
+
+  return (
+    <Box
+      component="form"
+      onSubmit={handleLogin}
+      display="flex"
+      flexDirection="column"
+      margin="auto"
+      marginTop={10}
+      width="300px"
+    >
+      <img className="Logo" src="logo.svg" alt="logo" height="100" />
+      <Typography variant="h5" align="center">
+        Spectator
+      </Typography>
+      <TextField
+        label="Username"
+        margin="normal"
+        value={username}
+        onChange={(event) => setUsername(event.target.value)}
+      />
+      <TextField
+        label="Password"
+        type="password"
+        margin="normal"
+        value={password}
+        onChange={(event) => setPassword(event.target.value)}
+      />
+      {error !== "" && (
+        <Typography color="error" variant="body2">
+          {error}
+        </Typography>
+      )}
+      <Box marginTop={2}>
+        <Button type="submit" variant="contained" color="primary" fullWidth>
+          Log in
+        </Button>
+      </Box>
+    </Box>
+  );
+};
+
+export default Login;
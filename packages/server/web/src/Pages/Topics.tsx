@@ -15,6 +15,13 @@ import DeleteIcon from "@material-ui/icons/Delete";
 
 import { Topic } from "../types";
 
+const flattenTopics = (topics: Topic[]): Topic[] =>
+  topics.reduce(
+    (flat: Topic[], topic: Topic) =>
+      flat.concat(topic, flattenTopics(topic.children || [])),
+    []
+  );
+
 const useStyles = makeStyles((theme) => ({
   input: {
     marginRight: theme.spacing(2),
@@ -58,21 +65,80 @@ const Topics = (props: TopicsProps) => {
       });
   }, [topic]);
 
-  const handleTopicDelete = React.useCallback((topicId: number) => {
-    fetch("/topic/" + topicId, {
-      method: "delete",
-    })
-      .then((response: any) => {
+  const sendTopicDelete = React.useCallback(
+    (topicId: number, parameters: string = "") =>
+      fetch("/topic/" + topicId + parameters, {
+        method: "delete",
+      }).then((response: any) => {
         if (!response.ok) {
           throw new Error(response.statusText);
         }
 
         console.log("Topic deleted:", response);
+      }),
+    []
+  );
+
+  // A topic still used by annotations is only deleted once the user chose to
+  // move them to another topic or to delete them along with it
+  const handleTopicConflict = React.useCallback(
+    (topicId: number, message: string) => {
+      const into = window.prompt(
+        message +
+          ".\n\nType the name of the topic to move them to, or leave empty to delete them with the topic."
+      );
+      if (into === null) return Promise.resolve();
+
+      if (into !== "") {
+        const intoTopic = flattenTopics(topics).find(
+          (t: Topic) => t.topic === into && t.id !== topicId
+        );
+        if (!intoTopic) {
+          window.alert("There is no topic " + into + ".");
+          return Promise.resolve();
+        }
+
+        return sendTopicDelete(topicId, "?reassign=" + intoTopic.id);
+      }
+
+      if (
+        !window.confirm(
+          "Are you sure you want to delete these annotations? This cannot be undone from this page."
+        )
+      ) {
+        return Promise.resolve();
+      }
+
+      return sendTopicDelete(topicId, "?force=true");
+    },
+    [topics, sendTopicDelete]
+  );
+
+  const handleTopicDelete = React.useCallback(
+    (topicId: number) => {
+      fetch("/topic/" + topicId, {
+        method: "delete",
       })
-      .catch((error: any) => {
-        console.error("Topic delete:", error);
-      });
-  }, []);
+        .then((response: any) => {
+          if (response.status === 409) {
+            return response
+              .text()
+              .then((message: string) =>
+                handleTopicConflict(topicId, message.trim())
+              );
+          }
+          if (!response.ok) {
+            throw new Error(response.statusText);
+          }
+
+          console.log("Topic deleted:", response);
+        })
+        .catch((error: any) => {
+          console.error("Topic delete:", error);
+        });
+    },
+    [handleTopicConflict]
+  );
 
   const handleTopicChange = React.useCallback(
     (event: React.ChangeEvent<HTMLInputElement>) => {
@@ -101,7 +167,7 @@ const Topics = (props: TopicsProps) => {
               onClick={() => {
                 if (
                   window.confirm(
-                    "Are you sure you want to delete this topic? It will delete all the annotations of this topic in all documents."
+                    "Are you sure you want to delete this topic?"
                   )
                 ) {
                   handleTopicDelete(topic.id);
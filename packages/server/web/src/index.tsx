@@ -8,10 +8,9 @@ import {
 } from "@material-ui/core";
 import App from "./App";
 import classGenerator from "./classGenerator";
+import Login from "./Pages/Login";
 import * as serviceWorker from "./serviceWorker";
 
-var socket = new WebSocket("ws://127.0.0.1:8000/ws");
-
 var theme = createMuiTheme({
   overrides: {
     MuiCssBaseline: {
@@ -38,7 +37,7 @@ ReactDOM.render(
     <StylesProvider generateClassName={classGenerator}>
       <ThemeProvider theme={theme}>
         <CssBaseline />
-        <App socket={socket} />
+        <Login>{(socket: WebSocket) => <App socket={socket} />}</Login>
       </ThemeProvider>
     </StylesProvider>
   </React.StrictMode>,
@@ -1,15 +1,27 @@
 import { Annotation as dvAnnotation } from "@kirasystems/document-viewer";
 
-export type Annotation = dvAnnotation & { text: string };
+export type Annotation = dvAnnotation & {
+  text: string;
+  status: string;
+  source: string;
+  confidence: number | null;
+  model: string;
+};
 
 export type Document = {
   id: number;
   name: string;
   pages: number;
   processed: boolean;
+  status: string;
 };
 
 export type Topic = {
   id: number;
   topic: string;
+  parentId: number | null;
+  color: string;
+  description: string;
+  shortcut: string;
+  children?: Topic[];
 };